@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// keyAudit is one S3 logging configuration's access key, as IAM knows it.
+type keyAudit struct {
+	service  service
+	endpoint string
+	key      string
+	user     string
+	created  time.Time
+	lastUsed time.Time
+	err      error
+}
+
+// auditRow is a keyAudit as -output json or yaml prints it.
+type auditRow struct {
+	ServiceID   string     `json:"service_id"`
+	ServiceName string     `json:"service_name"`
+	Endpoint    string     `json:"endpoint"`
+	AccessKey   string     `json:"access_key,omitempty"`
+	IAMRole     string     `json:"iam_role,omitempty"`
+	User        string     `json:"user,omitempty"`
+	Created     *time.Time `json:"created,omitempty"`
+	LastUsed    *time.Time `json:"last_used,omitempty"`
+	AgeDays     int        `json:"age_days,omitempty"`
+	Old         bool       `json:"old"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// audit lists every S3 logging configuration across the account with its
+// access key and when (according to IAM) the key was created and last used,
+// flagging keys older than -maxKeyAge.
+func audit(args []string) {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	serviceID := fs.String("serviceID", "", "A Fastly Service ID (default: every service on the account).")
+	maxKeyAge := fs.Int("maxKeyAge", 0, "Flag access keys older than this many days (default: the config file's rotation_policy max_key_age_days, or 90).")
+	tagFlags(fs)
+	outputFlag(fs, "table")
+	checkpointFlags(fs)
+	commonFlags(fs)
+
+	fs.Usage = commandUsage(fs, "Note, FASTLY_KEY (or the tokens of each account in the config file) must be provided as env vars, and AWS credentials with IAM read access in the standard AWS env vars.")
+	parseFlags(fs, args)
+
+	if *maxKeyAge == 0 {
+		*maxKeyAge = maxKeyAgeDays()
+	}
+
+	creds := awsEnvCredentials()
+	checkArg("AWS_ACCESS_KEY_ID", creds.accessKey)
+	iam := newAWSClient(creds, "")
+	ctx := context.Background()
+
+	cp := loadCheckpoint()
+	var audits []keyAudit
+	for _, s := range servicesFor(ctx, *serviceID) {
+		if s.svc.Version == 0 {
+			continue
+		}
+		loggings, err := cp.s3Loggings(ctx, s)
+		cp.stopIfExhausted(err)
+		check(err)
+
+		for _, l := range loggings {
+			if !hasTags(l.Name, selectedTags) {
+				continue
+			}
+			a := keyAudit{service: s.svc, endpoint: "s3/" + l.Name, key: l.AccessKey}
+			if l.IAMRole != "" {
+				a.key, a.user = "-", l.IAMRole
+			} else if a.err = a.lookup(ctx, iam); a.err != nil {
+				fmt.Fprintf(messages(), "Unable to look up %s (%s on %s): %s\n", l.AccessKey, a.endpoint, s.svc.ID, a.err.Error())
+			}
+			audits = append(audits, a)
+		}
+	}
+	cp.finish()
+	sort.SliceStable(audits, func(i, j int) bool { return audits[i].created.Before(audits[j].created) })
+
+	var ages []time.Duration
+	for _, a := range audits {
+		if a.err == nil && a.key != "-" {
+			ages = append(ages, time.Since(a.created))
+		}
+	}
+
+	maxAge := time.Duration(*maxKeyAge) * 24 * time.Hour
+	if outputFormat != "table" {
+		rows := []auditRow{}
+		for _, a := range audits {
+			rows = append(rows, a.row(maxAge))
+		}
+		printStructured(rows)
+		check(checkMaxKeyAge(ages))
+		return
+	}
+
+	flagged := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVICE\tENDPOINT\tKEY\tUSER\tCREATED\tLAST USED\t")
+	for _, a := range audits {
+		created, lastUsed, note := "-", "-", ""
+		switch {
+		case a.err != nil:
+			note = "unknown"
+		case a.key == "-":
+			note = "IAM role"
+		default:
+			created = a.created.Format("2006-01-02")
+			lastUsed = "never"
+			if !a.lastUsed.IsZero() {
+				lastUsed = a.lastUsed.Format("2006-01-02")
+			}
+			if age := time.Since(a.created); age > maxAge {
+				note = fmt.Sprintf("OLD (%d days)", days(age))
+				flagged++
+			}
+		}
+		fmt.Fprintf(w, "%s (%s)\t%s\t%s\t%s\t%s\t%s\t%s\n", a.service.Name, a.service.ID, a.endpoint, a.key, a.user, created, lastUsed, note)
+	}
+	w.Flush()
+
+	fmt.Printf("\n%d of %d access key(s) are older than %d days.\n", flagged, len(audits), *maxKeyAge)
+	check(checkMaxKeyAge(ages))
+}
+
+func (a keyAudit) row(maxAge time.Duration) auditRow {
+	r := auditRow{ServiceID: a.service.ID, ServiceName: a.service.Name, Endpoint: a.endpoint, User: a.user}
+	switch {
+	case a.err != nil:
+		r.AccessKey, r.Error = a.key, a.err.Error()
+	case a.key == "-":
+		r.IAMRole, r.User = a.user, ""
+	default:
+		created := a.created
+		r.AccessKey, r.Created = a.key, &created
+		if !a.lastUsed.IsZero() {
+			lastUsed := a.lastUsed
+			r.LastUsed = &lastUsed
+		}
+		age := time.Since(a.created)
+		r.AgeDays, r.Old = days(age), age > maxAge
+	}
+	return r
+}
+
+// lookup finds the key's IAM user, creation and last use.
+func (a *keyAudit) lookup(ctx context.Context, iam *awsClient) error {
+	if a.key == "" {
+		return fmt.Errorf("no access key")
+	}
+	lastUsed, err := iam.accessKeyLastUsed(ctx, a.key)
+	if err != nil {
+		return err
+	}
+	a.user, a.lastUsed = lastUsed.UserName, lastUsed.LastUsedDate
+
+	keys, err := iam.listAccessKeys(ctx, lastUsed.UserName)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if k.AccessKeyID == a.key {
+			a.created = k.CreateDate
+			return nil
+		}
+	}
+	return fmt.Errorf("not found for user %s", lastUsed.UserName)
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+type awsCredentials struct {
+	accessKey    string
+	secretKey    string
+	sessionToken string
+}
+
+// awsClient makes SigV4-signed requests to AWS APIs.
+//
+// https://docs.aws.amazon.com/general/latest/gr/sigv4_signing.html
+type awsClient struct {
+	creds  awsCredentials
+	region string
+	client *http.Client
+}
+
+// awsEnvCredentials are the operator's own credentials from the standard AWS
+// env vars (including AWS_SESSION_TOKEN, for temporary credentials), or else
+// those of the -webIdentityRole, or the shared credentials file or instance
+// role, as distinct from the logging credentials being rotated. With
+// -assumeRole, they are instead those of the role, assumed with those
+// credentials.
+func awsEnvCredentials() awsCredentials {
+	creds, err := operatorCredentials()
+	check(err)
+	return creds
+}
+
+// operatorCredentials is awsEnvCredentials, returning any error obtaining
+// them rather than exiting, as the daemon must.
+func operatorCredentials() (awsCredentials, error) {
+	var err error
+	creds := awsCredentials{accessKey: os.Getenv("AWS_ACCESS_KEY_ID")}
+	if creds.secretKey, err = cachedSecret("AWS_SECRET_ACCESS_KEY"); err != nil {
+		return awsCredentials{}, err
+	}
+	if creds.sessionToken, err = cachedSecret("AWS_SESSION_TOKEN"); err != nil {
+		return awsCredentials{}, err
+	}
+
+	if creds.accessKey == "" && webIdentityRoleARN() != "" {
+		if creds, err = webIdentityCredentials(); err != nil {
+			return awsCredentials{}, err
+		}
+	} else if creds.accessKey == "" {
+		creds = chainCredentials()
+	}
+	if assumeRoleARN != "" {
+		return assumedRoleCredentials(creds)
+	}
+	return creds, nil
+}
+
+func newAWSClient(creds awsCredentials, region string) *awsClient {
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &awsClient{creds: creds, region: region, client: httpClient()}
+}
+
+type awsError struct {
+	op         string
+	statusCode int
+	body       string
+}
+
+func (e *awsError) Error() string {
+	return fmt.Sprintf("%s failed: %d, %s", e.op, e.statusCode, e.body)
+}
+
+// isAWSStatus reports whether err is an AWS error response with the given
+// HTTP status code.
+func isAWSStatus(err error, statusCode int) bool {
+	e, ok := err.(*awsError)
+	return ok && e.statusCode == statusCode
+}
+
+type awsRequest struct {
+	service string
+	region  string // overrides the client region, e.g. for global services
+	method  string
+	host    string
+	path    string
+	query   url.Values
+	header  http.Header
+	body    []byte
+}
+
+// do signs and sends r, returning the response body and headers.
+func (a *awsClient) do(ctx context.Context, op string, r awsRequest) ([]byte, http.Header, error) {
+	if r.path == "" {
+		r.path = "/"
+	}
+	if r.region == "" {
+		r.region = a.region
+	}
+
+	canonicalURI := awsEscape(r.path, false)
+	canonicalQuery := awsCanonicalQuery(r.query)
+
+	reqURL := "https://" + r.host + canonicalURI
+	if canonicalQuery != "" {
+		reqURL += "?" + canonicalQuery
+	}
+
+	req, err := http.NewRequestWithContext(ctx, r.method, reqURL, bytes.NewReader(r.body))
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, vs := range r.header {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+
+	a.sign(req, r, canonicalURI, canonicalQuery, time.Now())
+
+	start := time.Now()
+	resp, err := a.client.Do(req)
+	recordCall(op, time.Since(start), false)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, resp.Header, &awsError{op: op, statusCode: resp.StatusCode, body: string(body)}
+	}
+	return body, resp.Header, nil
+}
+
+func (a *awsClient) sign(req *http.Request, r awsRequest, canonicalURI, canonicalQuery string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	scope := strings.Join([]string{amzDate[:8], r.region, r.service, "aws4_request"}, "/")
+	payloadHash := sha256Hex(r.body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if a.creds.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", a.creds.sessionToken)
+	}
+
+	headers := map[string]string{"host": r.host}
+	for k, vs := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(vs, ","))
+	}
+
+	var names []string
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		r.method, canonicalURI, canonicalQuery, canonicalHeaders.String(), signedHeaders, payloadHash,
+	}, "\n")
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := []byte("AWS4" + a.creds.secretKey)
+	for _, part := range []string{amzDate[:8], r.region, r.service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		a.creds.accessKey, scope, signedHeaders, signature))
+}
+
+// queryAPI calls an AWS Query protocol API (IAM, STS) and decodes the XML
+// response into out.
+func (a *awsClient) queryAPI(ctx context.Context, service, region, host, action, apiVersion string, params url.Values, out interface{}) error {
+	form := url.Values{"Action": {action}, "Version": {apiVersion}}
+	for k, vs := range params {
+		form[k] = vs
+	}
+
+	body, _, err := a.do(ctx, service+":"+action, awsRequest{
+		service: service,
+		region:  region,
+		method:  http.MethodPost,
+		host:    host,
+		header:  http.Header{"Content-Type": {"application/x-www-form-urlencoded; charset=utf-8"}},
+		body:    []byte(form.Encode()),
+	})
+	if err != nil || out == nil {
+		return err
+	}
+	return xml.Unmarshal(body, out)
+}
+
+// https://docs.aws.amazon.com/STS/latest/APIReference/API_GetCallerIdentity.html
+func (a *awsClient) callerIdentity(ctx context.Context) (string, error) {
+	var resp struct {
+		Arn string `xml:"GetCallerIdentityResult>Arn"`
+	}
+	err := a.queryAPI(ctx, "sts", "us-east-1", "sts.amazonaws.com", "GetCallerIdentity", "2011-06-15", nil, &resp)
+	return resp.Arn, err
+}
+
+// awsEscape URI-encodes s as SigV4 requires: everything but unreserved
+// characters (and '/' in paths) is percent-encoded.
+func awsEscape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func awsCanonicalQuery(query url.Values) string {
+	var pairs [][2]string
+	for k, vs := range query {
+		for _, v := range vs {
+			pairs = append(pairs, [2]string{awsEscape(k, true), awsEscape(v, true)})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i][0] != pairs[j][0] {
+			return pairs[i][0] < pairs[j][0]
+		}
+		return pairs[i][1] < pairs[j][1]
+	})
+
+	var parts []string
+	for _, p := range pairs {
+		parts = append(parts, p[0]+"="+p[1])
+	}
+	return strings.Join(parts, "&")
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sharedCredentialsFile is the AWS CLI's credentials file.
+func sharedCredentialsFile() string {
+	if file := os.Getenv("AWS_SHARED_CREDENTIALS_FILE"); file != "" {
+		return file
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".aws", "credentials")
+}
+
+// awsProfile reads a profile's settings from the shared credentials file.
+func awsProfile(name string) (map[string]string, error) {
+	file, err := os.Open(sharedCredentialsFile())
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var profile map[string]string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			if profile != nil {
+				return profile, nil
+			}
+			if strings.TrimSpace(line[1:len(line)-1]) == name {
+				profile = map[string]string{}
+			}
+		case profile != nil:
+			parts := strings.SplitN(line, "=", 2)
+			if len(parts) == 2 {
+				profile[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if profile == nil {
+		return nil, fmt.Errorf("No profile %q in %s", name, sharedCredentialsFile())
+	}
+	return profile, nil
+}
+
+func profileCredentials(name string) (awsCredentials, error) {
+	profile, err := awsProfile(name)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	creds := awsCredentials{
+		accessKey:    profile["aws_access_key_id"],
+		secretKey:    profile["aws_secret_access_key"],
+		sessionToken: profile["aws_session_token"],
+	}
+	if creds.accessKey == "" || creds.secretKey == "" {
+		return awsCredentials{}, fmt.Errorf("Profile %q in %s has no access key", name, sharedCredentialsFile())
+	}
+	return creds, nil
+}
+
+// useAWSProfile takes the logging credentials being rotated from a profile
+// of the shared credentials file, in place of -awsAccessKey and
+// AWS_SECRET_KEY, returning the access key.
+func useAWSProfile(name string) string {
+	creds, err := profileCredentials(name)
+	check(err)
+	secretFroms["AWS_SECRET_KEY"] = "awsprofile://" + name
+	return creds.accessKey
+}
+
+// profileSecret is a setting of a profile, by default its secret key, for
+// -secretFrom NAME=awsprofile://profile#setting.
+func profileSecret(name, setting string) (string, error) {
+	if setting == "" {
+		setting = "aws_secret_access_key"
+	}
+	profile, err := awsProfile(name)
+	if err != nil {
+		return "", err
+	}
+	value := profile[setting]
+	if value == "" {
+		return "", fmt.Errorf("Profile %q in %s has no %s", name, sharedCredentialsFile(), setting)
+	}
+	return value, nil
+}
+
+var (
+	chainCreds    awsCredentials
+	chainExpiry   time.Time
+	chainTried    bool
+	chainCredsMux sync.Mutex
+)
+
+// chainCredentials are the operator's AWS credentials when none are in the
+// env vars: from the AWS_PROFILE (or default) profile of the shared
+// credentials file, or else the instance's IAM role, as the AWS SDKs would
+// find them.
+func chainCredentials() awsCredentials {
+	chainCredsMux.Lock()
+	defer chainCredsMux.Unlock()
+
+	if chainTried && (chainExpiry.IsZero() || time.Until(chainExpiry) > refreshBefore) {
+		return chainCreds
+	}
+	chainTried = true
+
+	profile := os.Getenv("AWS_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+	if creds, err := profileCredentials(profile); err == nil {
+		chainCreds = creds
+		return creds
+	}
+
+	creds, expiry, err := instanceCredentials(context.Background())
+	if err != nil {
+		return awsCredentials{}
+	}
+	chainCreds, chainExpiry = creds, expiry
+	return creds
+}
+
+// instanceCredentials are the credentials of the IAM role of the EC2
+// instance (or ECS task) the tool runs on.
+//
+// https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/instance-metadata-security-credentials.html
+// https://docs.aws.amazon.com/AmazonECS/latest/developerguide/task-iam-roles.html
+func instanceCredentials(ctx context.Context) (awsCredentials, time.Time, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	get := func(url string, header http.Header) ([]byte, error) {
+		return metadataRequest(ctx, http.MethodGet, url, header)
+	}
+
+	var body []byte
+	if relative := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); relative != "" {
+		var err error
+		if body, err = get("http://169.254.170.2"+relative, nil); err != nil {
+			return awsCredentials{}, time.Time{}, err
+		}
+	} else {
+		// IMDSv2 needs a session token first.
+		token, err := metadataRequest(ctx, http.MethodPut, "http://169.254.169.254/latest/api/token", http.Header{"X-Aws-Ec2-Metadata-Token-Ttl-Seconds": {"300"}})
+		if err != nil {
+			return awsCredentials{}, time.Time{}, err
+		}
+		header := http.Header{"X-Aws-Ec2-Metadata-Token": {string(token)}}
+
+		base := "http://169.254.169.254/latest/meta-data/iam/security-credentials/"
+		role, err := get(base, header)
+		if err != nil {
+			return awsCredentials{}, time.Time{}, err
+		}
+		if body, err = get(base+strings.TrimSpace(string(role)), header); err != nil {
+			return awsCredentials{}, time.Time{}, err
+		}
+	}
+
+	var resp struct {
+		AccessKeyID     string    `json:"AccessKeyId"`
+		SecretAccessKey string    `json:"SecretAccessKey"`
+		Token           string    `json:"Token"`
+		Expiration      time.Time `json:"Expiration"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return awsCredentials{}, time.Time{}, err
+	}
+	if resp.AccessKeyID == "" {
+		return awsCredentials{}, time.Time{}, errors.New("No instance credentials")
+	}
+	return awsCredentials{accessKey: resp.AccessKeyID, secretKey: resp.SecretAccessKey, sessionToken: resp.Token}, resp.Expiration, nil
+}
+
+func metadataRequest(ctx context.Context, method, url string, header http.Header) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+
+	// The metadata service must be reached directly, never through a proxy.
+	resp, err := (&http.Client{Transport: &http.Transport{}}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s failed: %d", method, url, resp.StatusCode)
+	}
+	return body, nil
+}
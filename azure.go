@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// https://developer.fastly.com/reference/api/logging/azureblob/
+type azureBlobLogging struct {
+	Name        string  `json:"name"`
+	AccountName string  `json:"account_name"`
+	Container   string  `json:"container"`
+	Path        string  `json:"path"`
+	SASToken    string  `json:"sas_token"`
+	Period      flexInt `json:"period"`
+}
+
+func (f *fastlyClient) azureBlobLogging(ctx context.Context, serviceID string, version int, name string) (azureBlobLogging, error) {
+	var l azureBlobLogging
+	err := f.do(ctx, http.MethodGet, fmt.Sprintf("/service/%s/version/%d/logging/azureblob/%s", serviceID, version, name), nil, &l)
+	return l, err
+}
+
+func (f *fastlyClient) updateAzureSASToken(ctx context.Context, serviceID string, version int, name, sasToken string) error {
+	return f.updateLogging(ctx, serviceID, version, "azureblob", name, url.Values{"sas_token": {sasToken}})
+}
+
+const azureSASVersion = "2020-12-06"
+
+// containerSAS creates a service SAS granting permissions on a container.
+//
+// https://learn.microsoft.com/en-us/rest/api/storageservices/create-service-sas
+func containerSAS(accountName, accountKey, container, permissions string, expiry time.Time) (string, error) {
+	key, err := base64.StdEncoding.DecodeString(accountKey)
+	if err != nil {
+		return "", fmt.Errorf("Invalid storage account key: %s", err.Error())
+	}
+
+	se := expiry.UTC().Format(time.RFC3339)
+	stringToSign := strings.Join([]string{
+		permissions,
+		"", // start
+		se,
+		fmt.Sprintf("/blob/%s/%s", accountName, container),
+		"", // identifier
+		"", // IP range
+		"https",
+		azureSASVersion,
+		"c",                // resource: container
+		"",                 // snapshot time
+		"",                 // encryption scope
+		"", "", "", "", "", // response header overrides
+	}, "\n")
+
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(stringToSign))
+
+	token := url.Values{
+		"sv":  {azureSASVersion},
+		"sr":  {"c"},
+		"sp":  {permissions},
+		"se":  {se},
+		"spr": {"https"},
+		"sig": {base64.StdEncoding.EncodeToString(h.Sum(nil))},
+	}
+	return token.Encode(), nil
+}
+
+// sasExpiry reads the expiry (se) out of a SAS token.
+func sasExpiry(token string) (time.Time, error) {
+	values, err := url.ParseQuery(strings.TrimPrefix(token, "?"))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, values.Get("se"))
+}
+
+// renewAzureSAS replaces the SAS token of an Azure Blob logging configuration
+// when it is close to expiry. The expiry is carried in the token itself (and
+// recorded in the version comment), so scheduled runs can simply be rerun.
+func renewAzureSAS(args []string) {
+	fs := flag.NewFlagSet("renew-azure-sas", flag.ExitOnError)
+	serviceID := fs.String("serviceID", "", "A Fastly Service ID.")
+	loggingName := fs.String("loggingName", "", "Name of your service Azure Blob logging configuration in Fastly.")
+	expiryDays := fs.Int("expiryDays", 90, "Number of days the new SAS token is valid for.")
+	renewBeforeDays := fs.Int("renewBeforeDays", 14, "Only renew tokens expiring within this many days (0 to always renew).")
+	permissions := fs.String("permissions", "acw", "Permissions granted by the new SAS token.")
+	draftFlags(fs)
+	notifyFlags(fs)
+	commonFlags(fs)
+
+	fs.Usage = commandUsage(fs, "Note, FASTLY_KEY and AZURE_STORAGE_KEY (the storage account key) must be provided as env vars.")
+	parseFlags(fs, args)
+
+	accountKey := secret("AZURE_STORAGE_KEY")
+
+	checkArg("serviceID", *serviceID)
+	checkArg("loggingName", *loggingName)
+	checkArg("AZURE_STORAGE_KEY", accountKey)
+
+	f := fastlyFor(*serviceID)
+	ctx := context.Background()
+
+	active, err := f.activeVersion(ctx, *serviceID)
+	check(err)
+
+	current, err := f.azureBlobLogging(ctx, *serviceID, active, *loggingName)
+	check(err)
+
+	renewBy := time.Now().AddDate(0, 0, *renewBeforeDays)
+	if expiry, err := sasExpiry(current.SASToken); err == nil && *renewBeforeDays > 0 && expiry.After(renewBy) {
+		msg := fmt.Sprintf("SAS token expires %s, not renewing until %d days before.", expiry.Format(time.RFC3339), *renewBeforeDays)
+		notify(outcome{ServiceID: *serviceID, Status: statusSkipped, Detail: msg})
+		flushNotifications()
+		fmt.Fprintln(messages(), msg)
+		return
+	}
+
+	expiry := time.Now().AddDate(0, 0, *expiryDays)
+	token, err := containerSAS(current.AccountName, accountKey, current.Container, *permissions, expiry)
+	check(err)
+
+	number, err := withDraft(ctx, f, *serviceID, func(number int) error {
+		err := f.updateAzureSASToken(ctx, *serviceID, number, *loggingName, token)
+		if err != nil {
+			return err
+		}
+		comment := fmt.Sprintf("Renewed SAS token for %s, expires %s,", *loggingName, expiry.UTC().Format(time.RFC3339))
+		return f.setVersionComment(ctx, *serviceID, number, versionComment(comment))
+	})
+	if err != nil {
+		notify(failedOutcome(*serviceID, err))
+		flushNotifications()
+		check(err)
+	}
+
+	msg := fmt.Sprintf("Activated version %d of service %s. New SAS token expires %s.", number, *serviceID, expiry.UTC().Format(time.RFC3339))
+	recordRotation("azureblob", *serviceID, *loggingName, active, number, current.SASToken, token)
+	notify(outcome{ServiceID: *serviceID, Status: statusRotated, Detail: msg})
+	flushNotifications()
+	fmt.Fprintln(messages(), msg)
+}
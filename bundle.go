@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// changeBundle is an exact, reviewable set of logging changes to a service,
+// signed by plan so that apply can refuse anything but what was reviewed.
+// Plan signs with the ed25519 private key FLC_BUNDLE_KEY, and apply verifies
+// with only its public key FLC_BUNDLE_PUBLIC_KEY, so whoever can apply can't
+// also forge a plan.
+type changeBundle struct {
+	ServiceID   string          `json:"service_id"`
+	BaseVersion int             `json:"base_version"`
+	Changes     []plannedChange `json:"changes"`
+	CreatedAt   time.Time       `json:"created_at"`
+	ExpiresAt   time.Time       `json:"expires_at"`
+	Signature   string          `json:"signature,omitempty"`
+}
+
+type plannedChange struct {
+	Type    string                  `json:"type"`
+	Name    string                  `json:"name"`
+	Old     map[string]string       `json:"old,omitempty"`
+	Set     map[string]string       `json:"set,omitempty"`
+	Secrets map[string]bundleSecret `json:"secrets,omitempty"`
+}
+
+// bundleSecret stands in for a secret value, which is never written to the
+// bundle: apply reads it from Env again and checks it hashes the same.
+type bundleSecret struct {
+	Env    string `json:"env"`
+	SHA256 string `json:"sha256"`
+}
+
+func (b *changeBundle) sign(key ed25519.PrivateKey) error {
+	payload, err := b.payload()
+	if err != nil {
+		return err
+	}
+	b.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, payload))
+	return nil
+}
+
+func (b *changeBundle) verify(key ed25519.PublicKey) error {
+	payload, err := b.payload()
+	if err != nil {
+		return err
+	}
+	sig, err := base64.StdEncoding.DecodeString(b.Signature)
+	if err != nil || !ed25519.Verify(key, payload, sig) {
+		return errors.New("Change bundle signature is invalid: it was not created by plan with the FLC_BUNDLE_KEY of this FLC_BUNDLE_PUBLIC_KEY, or has been modified since")
+	}
+	if time.Now().After(b.ExpiresAt) {
+		return fmt.Errorf("Change bundle expired at %s", b.ExpiresAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// payload is what's signed: the bundle without its signature.
+func (b changeBundle) payload() ([]byte, error) {
+	b.Signature = ""
+	return json.Marshal(b)
+}
+
+// bundleSigningKey is FLC_BUNDLE_KEY, the ed25519 private key plan signs
+// bundles with, as its base64-encoded 32-byte seed.
+func bundleSigningKey() (ed25519.PrivateKey, error) {
+	key := secret("FLC_BUNDLE_KEY")
+	checkArg("FLC_BUNDLE_KEY", key)
+	seed, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, errors.New("FLC_BUNDLE_KEY must be an ed25519 seed, 32 bytes base64-encoded: create one with plan -generateKey")
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// bundleVerifyingKey is FLC_BUNDLE_PUBLIC_KEY, the base64-encoded public key
+// of FLC_BUNDLE_KEY, which apply verifies bundles with.
+func bundleVerifyingKey() (ed25519.PublicKey, error) {
+	key := secret("FLC_BUNDLE_PUBLIC_KEY")
+	checkArg("FLC_BUNDLE_PUBLIC_KEY", key)
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return nil, errors.New("FLC_BUNDLE_PUBLIC_KEY must be an ed25519 public key, 32 bytes base64-encoded, as printed by plan -generateKey")
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// generateBundleKey prints a new FLC_BUNDLE_KEY and its FLC_BUNDLE_PUBLIC_KEY.
+func generateBundleKey() {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	check(err)
+	fmt.Printf("FLC_BUNDLE_KEY=%s\n", base64.StdEncoding.EncodeToString(private.Seed()))
+	fmt.Printf("FLC_BUNDLE_PUBLIC_KEY=%s\n", base64.StdEncoding.EncodeToString(public))
+}
+
+// describe prints the changes for review.
+func (b *changeBundle) describe() {
+	fmt.Fprintf(os.Stderr, "Service %s, based on version %d:\n", b.ServiceID, b.BaseVersion)
+	for _, c := range b.Changes {
+		fmt.Fprintf(os.Stderr, "  %s logging %q:\n", c.Type, c.Name)
+
+		var fields []string
+		for field := range c.Set {
+			fields = append(fields, field)
+		}
+		for field := range c.Secrets {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+
+		for _, field := range fields {
+			if s, ok := c.Secrets[field]; ok {
+				fmt.Fprintf(os.Stderr, "    %s: (secret from %s, sha256 %s)\n", field, s.Env, s.SHA256[:12])
+			} else {
+				fmt.Fprintf(os.Stderr, "    %s: %q -> %q\n", field, c.Old[field], c.Set[field])
+			}
+		}
+	}
+}
+
+// plan writes a signed change bundle for review. A bundle can hold any number
+// of logging changes to a service, from -awsAccessKey rotations and -set
+// fields, and can be added to with -add, so that they are all made by apply
+// in a single new version.
+func plan(args []string) {
+	fs := flag.NewFlagSet("plan", flag.ExitOnError)
+	serviceID := fs.String("serviceID", "", "A Fastly Service ID.")
+	loggingName := fs.String("loggingName", "", "Name of your service S3 logging configuration in Fastly, to rotate.")
+	awsAccessKey := fs.String("awsAccessKey", "", "AWS Access Key for S3 write access to the target bucket.")
+	awsProfile := fs.String("awsProfile", "", "Profile of the AWS shared credentials file to take the new access key and secret from, in place of -awsAccessKey and AWS_SECRET_KEY.")
+	var sets listFlag
+	fs.Var(&sets, "set", "A field to change, as type/name.field=value, e.g. s3/logs.gzip_level=9. Can be repeated.")
+	add := fs.String("add", "", "Existing change bundle to add these changes to, rather than starting a new one.")
+	out := fs.String("out", "", "File to write the change bundle to (default stdout).")
+	ttl := fs.Duration("ttl", 24*time.Hour, "How long the change bundle can be applied for.")
+	generateKey := fs.Bool("generateKey", false, "Print a new FLC_BUNDLE_KEY to sign bundles with, and the FLC_BUNDLE_PUBLIC_KEY for apply to verify them with, rather than planning.")
+	schema := schemaFlag(fs)
+	commonFlags(fs)
+
+	fs.Usage = commandUsage(fs, "Note, FASTLY_KEY and FLC_BUNDLE_KEY (the ed25519 private key bundles are signed with), and AWS_SECRET_KEY with -awsAccessKey, must be provided as env vars.")
+	parseFlags(fs, args)
+
+	if *schema {
+		printSchema("plan")
+		return
+	}
+	if *generateKey {
+		generateBundleKey()
+		return
+	}
+
+	bundleKey, err := bundleSigningKey()
+	check(err)
+
+	now := time.Now().UTC()
+	b := changeBundle{ServiceID: *serviceID, CreatedAt: now}
+	if *add != "" {
+		data, err := ioutil.ReadFile(*add)
+		check(err)
+		check(json.Unmarshal(data, &b))
+		check(b.verify(bundleKey.Public().(ed25519.PublicKey)))
+		if *serviceID != "" && *serviceID != b.ServiceID {
+			check(fmt.Errorf("%s is a bundle for service %s, not %s", *add, b.ServiceID, *serviceID))
+		}
+	}
+	checkArg("serviceID", b.ServiceID)
+
+	f := fastlyFor(b.ServiceID)
+	ctx := context.Background()
+
+	active, err := f.activeVersion(ctx, b.ServiceID)
+	check(err)
+	if b.BaseVersion == 0 {
+		b.BaseVersion = active
+	} else if active != b.BaseVersion {
+		check(fmt.Errorf("Service %s has moved from version %d to %d since %s was planned, re-plan it", b.ServiceID, b.BaseVersion, active, *add))
+	}
+
+	// Fetch each endpoint changed once, for the values being replaced.
+	current := map[string]map[string]map[string]interface{}{}
+	old := func(typ, name, field string) string {
+		if current[typ] == nil {
+			loggings, err := f.loggings(ctx, b.ServiceID, active, typ)
+			check(err)
+			current[typ] = byName(loggings)
+		}
+		l, ok := current[typ][name]
+		if !ok {
+			check(fmt.Errorf("Service %s has no %s logging configuration named %q", b.ServiceID, typ, name))
+		}
+		if l[field] == nil {
+			return ""
+		}
+		return fmt.Sprint(l[field])
+	}
+
+	if *awsProfile != "" {
+		*awsAccessKey = useAWSProfile(*awsProfile)
+	}
+
+	planned := 0
+	if *awsAccessKey != "" {
+		awsSecretKey := secret("AWS_SECRET_KEY")
+		checkArg("loggingName", *loggingName)
+		checkArg("AWS_SECRET_KEY", awsSecretKey)
+		check(checkLongLivedKey(*awsAccessKey))
+
+		c := b.change("s3", *loggingName)
+		c.Old["access_key"] = old("s3", *loggingName, "access_key")
+		c.Set["access_key"] = *awsAccessKey
+		c.Secrets["secret_key"] = bundleSecret{Env: "AWS_SECRET_KEY", SHA256: sha256Hex([]byte(awsSecretKey))}
+		planned++
+	}
+
+	for _, set := range sets {
+		typ, name, field, value, err := parseSet(set)
+		check(err)
+		if isSecretField(typ, field) {
+			check(fmt.Errorf("-set can't be used for the secret %s", field))
+		}
+
+		c := b.change(typ, name)
+		c.Old[field] = old(typ, name, field)
+		c.Set[field] = value
+		planned++
+	}
+
+	if planned == 0 {
+		check(errors.New("Nothing to plan: give -awsAccessKey (with -loggingName) or -set"))
+	}
+
+	b.ExpiresAt = now.Add(*ttl)
+	check(b.sign(bundleKey))
+
+	b.describe()
+
+	data, err := json.MarshalIndent(b, "", "  ")
+	check(err)
+	if *out == "" {
+		fmt.Println(string(data))
+		return
+	}
+	// The bundle holds hashes of the secrets, so it is kept as private.
+	check(ioutil.WriteFile(*out, append(data, '\n'), 0600))
+	fmt.Fprintf(os.Stderr, "Wrote change bundle to %s.\n", *out)
+}
+
+// change is the bundle's change to an endpoint, added if there isn't one yet.
+func (b *changeBundle) change(typ, name string) *plannedChange {
+	for i, c := range b.Changes {
+		if c.Type == typ && c.Name == name {
+			return &b.Changes[i]
+		}
+	}
+
+	b.Changes = append(b.Changes, plannedChange{Type: typ, Name: name})
+	c := &b.Changes[len(b.Changes)-1]
+	c.Old, c.Set, c.Secrets = map[string]string{}, map[string]string{}, map[string]bundleSecret{}
+	return c
+}
+
+// parseSet parses a -set value of the form type/name.field=value. Names may
+// contain dots, so the field is taken from after the last one.
+func parseSet(set string) (typ, name, field, value string, err error) {
+	invalid := fmt.Errorf("Invalid -set %q, expected type/name.field=value", set)
+
+	eq := strings.Index(set, "=")
+	slash := strings.Index(set, "/")
+	if eq < 0 || slash < 0 || slash > eq {
+		return "", "", "", "", invalid
+	}
+	typ, target, value := set[:slash], set[slash+1:eq], set[eq+1:]
+
+	dot := strings.LastIndex(target, ".")
+	if dot <= 0 || dot == len(target)-1 {
+		return "", "", "", "", invalid
+	}
+	if !contains(loggingTypes, typ) {
+		return "", "", "", "", fmt.Errorf("Unknown logging type %q in -set, expected one of %s", typ, strings.Join(loggingTypes, ","))
+	}
+	return typ, target[:dot], target[dot+1:], value, nil
+}
+
+// apply executes exactly the changes in an approved bundle, and nothing else.
+func apply(args []string) {
+	fs := flag.NewFlagSet("apply", flag.ExitOnError)
+	approve := fs.String("approve", "", "Change bundle created by plan, to apply.")
+	gitRepo := fs.String("gitRepo", "", "Git repository to commit the resulting logging configuration to.")
+	draftFlags(fs)
+	commonFlags(fs)
+
+	fs.Usage = commandUsage(fs, "Note, FASTLY_KEY, FLC_BUNDLE_PUBLIC_KEY (the public key of plan's FLC_BUNDLE_KEY) and any secrets named in the bundle must be provided as env vars. Apply can't sign bundles, so running it without FLC_BUNDLE_KEY ensures that it only makes changes someone else planned.")
+	parseFlags(fs, args)
+
+	checkArg("approve", *approve)
+	bundleKey, err := bundleVerifyingKey()
+	check(err)
+
+	data, err := ioutil.ReadFile(*approve)
+	check(err)
+
+	var b changeBundle
+	check(json.Unmarshal(data, &b))
+	check(b.verify(bundleKey))
+
+	f := fastlyFor(b.ServiceID)
+	ctx := context.Background()
+
+	active, err := f.activeVersion(ctx, b.ServiceID)
+	check(err)
+	if active != b.BaseVersion {
+		check(fmt.Errorf("Service %s has moved from version %d to %d since the bundle was planned, re-plan to apply", b.ServiceID, b.BaseVersion, active))
+	}
+
+	forms := make([]url.Values, len(b.Changes))
+	for i, c := range b.Changes {
+		forms[i] = url.Values{}
+		for field, value := range c.Set {
+			forms[i].Set(field, value)
+		}
+		for field, s := range c.Secrets {
+			value := secret(s.Env)
+			if sha256Hex([]byte(value)) != s.SHA256 {
+				check(fmt.Errorf("%s does not match the secret the bundle was planned with", s.Env))
+			}
+			forms[i].Set(field, value)
+		}
+	}
+
+	b.describe()
+
+	number, err := withDraft(ctx, f, b.ServiceID, func(number int) error {
+		for i, c := range b.Changes {
+			if err := f.updateLogging(ctx, b.ServiceID, number, c.Type, c.Name, forms[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	check(err)
+
+	fmt.Printf("Activated version %d of service %s.\n", number, b.ServiceID)
+	for _, c := range b.Changes {
+		if accessKey, ok := c.Set["access_key"]; ok {
+			recordRotation(c.Type, b.ServiceID, c.Name, b.BaseVersion, number, c.Old["access_key"], accessKey)
+		}
+	}
+
+	if *gitRepo != "" {
+		m, err := exportManifest(ctx, f, b.ServiceID, number)
+		check(err)
+		check(commitManifest(*gitRepo, m, fmt.Sprintf("Apply change bundle to %s (%s): version %d activated by %s", m.ServiceName, m.ServiceID, number, operatorName)))
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+)
+
+func TestParseSet(t *testing.T) {
+	for set, want := range map[string][4]string{
+		"s3/logs.path=/fastly/": {"s3", "logs", "path", "/fastly/"},
+		"s3/my.logs.period=300": {"s3", "my.logs", "period", "300"},
+		"s3/logs.format=a=b":    {"s3", "logs", "format", "a=b"},
+		"s3/logs.path=":         {"s3", "logs", "path", ""},
+	} {
+		typ, name, field, value, err := parseSet(set)
+		if err != nil {
+			t.Errorf("parseSet(%q): %s", set, err)
+		} else if got := [4]string{typ, name, field, value}; got != want {
+			t.Errorf("parseSet(%q) = %q, want %q", set, got, want)
+		}
+	}
+
+	for _, set := range []string{"s3logs.path=x", "s3/logs=x", "s3/logs.=x", "s3/.path=x", "s3/logs.path", "nope/logs.path=x"} {
+		if _, _, _, _, err := parseSet(set); err == nil {
+			t.Errorf("parseSet(%q) succeeded, want an error", set)
+		}
+	}
+}
+
+func TestChangeBundleSignature(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := changeBundle{ServiceID: "svc", BaseVersion: 3, ExpiresAt: time.Now().Add(time.Hour)}
+	b.change("s3", "logs").Set["path"] = "/fastly/"
+	if err := b.sign(private); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.verify(public); err != nil {
+		t.Errorf("verify: %s", err)
+	}
+	if err := b.verify(other); err == nil {
+		t.Error("verify with another key succeeded, want an error")
+	}
+
+	b.Changes[0].Set["path"] = "/elsewhere/"
+	if err := b.verify(public); err == nil {
+		t.Error("verify of a modified bundle succeeded, want an error")
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+)
+
+var (
+	canaryServiceID string
+	canaryVerify    bool
+	canaryTimeout   time.Duration
+)
+
+// canaryFlags adds the flags of commands that rotate many services, to
+// rotate one first as a canary.
+func canaryFlags(fs *flag.FlagSet) {
+	fs.StringVar(&canaryServiceID, "canary", "", "Service ID to rotate and verify first, before the rest, which aren't rotated if it fails.")
+	fs.BoolVar(&canaryVerify, "canaryVerify", false, "Verify the -canary by waiting for logs to be delivered with the new key (with AWS credentials able to list its buckets), rather than asking for confirmation.")
+	fs.DurationVar(&canaryTimeout, "canaryTimeout", 0, "How long to wait for logs from the -canary, with -canaryVerify (default: two logging periods plus 10m).")
+}
+
+// verifyCanary checks the canary's rotated logging configurations are
+// delivering logs since rotatedAt, or asks the operator whether they are.
+func verifyCanary(ctx context.Context, loggings []s3Logging, rotatedAt time.Time) error {
+	if !canaryVerify {
+		if !confirm(fmt.Sprintf("Canary %s is rotated. Check it is delivering logs: rotate the rest?", canaryServiceID)) {
+			return errors.New("Not confirmed")
+		}
+		return nil
+	}
+
+	creds := awsEnvCredentials()
+	if creds.accessKey == "" {
+		return errors.New("-canaryVerify needs AWS credentials in the standard AWS env vars")
+	}
+	for _, l := range loggings {
+		a, err := s3ClientFor(ctx, l, creds)
+		if err != nil {
+			return err
+		}
+		timeout := canaryTimeout
+		if timeout == 0 {
+			timeout = keyDeliveryTimeout(int(l.Period))
+		}
+
+		fmt.Fprintf(messages(), "Waiting up to %s for logs to be delivered to s3://%s by canary %s/%s...\n", timeout, l.BucketName, canaryServiceID, l.Name)
+		object, err := waitForS3Delivery(ctx, a, l, rotatedAt, timeout)
+		if err != nil {
+			return fmt.Errorf("%s: %s", l.Name, err.Error())
+		}
+		fmt.Fprintf(messages(), "Delivery verified: s3://%s/%s\n", l.BucketName, object)
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	zstdMagic   = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	snappyMagic = []byte("\xff\x06\x00\x00sNaPpY")
+)
+
+// checkLogs samples the most recently delivered log files of an S3 logging
+// configuration and reports files that are corrupt, or whose lines don't
+// match the configured format and message type.
+func checkLogs(args []string) {
+	fs := flag.NewFlagSet("check-logs", flag.ExitOnError)
+	serviceID := fs.String("serviceID", "", "A Fastly Service ID.")
+	loggingName := fs.String("loggingName", "", "Name of your service S3 logging configuration in Fastly.")
+	sample := fs.Int("sample", 5, "Number of the most recent log files to check.")
+	lines := fs.Int("lines", 10, "Number of lines to check in each log file.")
+	prefix := fs.String("prefix", "", "Prefix to list log files under (default the directory of the logging path, for today and yesterday).")
+	commonFlags(fs)
+
+	fs.Usage = commandUsage(fs, "Note, FASTLY_KEY and AWS credentials able to list and read the bucket (in the standard AWS env vars) must be provided.")
+	parseFlags(fs, args)
+
+	checkArg("serviceID", *serviceID)
+	checkArg("loggingName", *loggingName)
+
+	creds := awsEnvCredentials()
+	checkArg("AWS_ACCESS_KEY_ID", creds.accessKey)
+
+	f := fastlyFor(*serviceID)
+	ctx := context.Background()
+
+	active, err := f.activeVersion(ctx, *serviceID)
+	check(err)
+
+	l, err := f.s3Logging(ctx, *serviceID, active, *loggingName)
+	check(err)
+
+	a, err := s3ClientFor(ctx, l, creds)
+	check(err)
+
+	*prefix = strings.TrimPrefix(*prefix, "/")
+	if *prefix == "" && deliveryPrefix(l.Path, time.Now()) == "" {
+		check(fmt.Errorf("The path of %s (%q) has no directory to list log files under, and the whole bucket won't be listed: give the -prefix of the log files", *loggingName, l.Path))
+	}
+
+	objects, err := recentObjects(ctx, a, l, *prefix, *sample)
+	check(err)
+	if len(objects) == 0 {
+		shown := *prefix
+		if shown == "" {
+			shown = deliveryPrefix(l.Path, time.Now())
+		}
+		check(fmt.Errorf("No log files found in s3://%s/%s", l.BucketName, shown))
+	}
+
+	anomalies := 0
+	for _, o := range objects {
+		body, err := a.getObject(ctx, l.Domain, l.BucketName, o.Key)
+		check(err)
+
+		problems := checkLogFile(l, body, *lines)
+		if len(problems) == 0 {
+			fmt.Printf("%s: ok\n", o.Key)
+			continue
+		}
+
+		fmt.Printf("%s:\n", o.Key)
+		for _, p := range problems {
+			fmt.Printf("  - %s\n", p)
+		}
+		anomalies += len(problems)
+	}
+
+	fmt.Printf("Checked %d log file(s) of %s, found %d anomalies.\n", len(objects), l.Name, anomalies)
+	if anomalies > 0 {
+		os.Exit(1)
+	}
+}
+
+// recentObjects lists the n most recent log files under prefix or, if it is
+// blank, from the current and previous day's prefixes (Fastly paths are
+// commonly dated).
+func recentObjects(ctx context.Context, a *awsClient, l s3Logging, prefix string, n int) ([]s3ObjectInfo, error) {
+	now := time.Now()
+	prefixes := []string{prefix}
+	if prefix == "" {
+		prefixes = []string{deliveryPrefix(l.Path, now)}
+		if yesterday := deliveryPrefix(l.Path, now.AddDate(0, 0, -1)); yesterday != prefixes[0] {
+			prefixes = append(prefixes, yesterday)
+		}
+	}
+
+	var objects []s3ObjectInfo
+	for _, prefix := range prefixes {
+		found, err := a.listObjects(ctx, l.Domain, l.BucketName, prefix, maxPollPages)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, found...)
+	}
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].LastModified.After(objects[j].LastModified) })
+	if len(objects) > n {
+		objects = objects[:n]
+	}
+	return objects, nil
+}
+
+// checkLogFile checks a log file decompresses as configured, and that its
+// first lines are in the configured format.
+func checkLogFile(l s3Logging, body []byte, lines int) []string {
+	var problems []string
+
+	codec := l.CompressionCodec
+	if codec == "" && l.GzipLevel > 0 {
+		codec = "gzip"
+	}
+
+	switch codec {
+	case "gzip":
+		r, err := gzip.NewReader(bytes.NewReader(body))
+		if err == nil {
+			body, err = ioutil.ReadAll(r)
+		}
+		if err != nil {
+			return append(problems, fmt.Sprintf("not a valid gzip file: %s", err.Error()))
+		}
+	case "zstd", "snappy":
+		magic := zstdMagic
+		if codec == "snappy" {
+			magic = snappyMagic
+		}
+		if !bytes.HasPrefix(body, magic) {
+			problems = append(problems, fmt.Sprintf("compression_codec is %s, but the file has no %s header.", codec, codec))
+		}
+		// There's no decoder for these in the standard library, so the
+		// contents can't be checked.
+		return problems
+	}
+
+	if !strings.HasPrefix(strings.TrimSpace(l.Format), "{") {
+		return problems
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; n <= lines && scanner.Scan(); n++ {
+		line := scanner.Bytes()
+		if json.Valid(line) {
+			continue
+		}
+
+		if i := bytes.IndexByte(line, '{'); i > 0 && json.Valid(line[i:]) {
+			problems = append(problems, fmt.Sprintf("line %d has a %q prefix before the JSON: message_type is %q, set it to \"blank\" for plain JSON lines.", n, line[:i], l.MessageType))
+		} else {
+			problems = append(problems, fmt.Sprintf("line %d is not valid JSON: %.120s", n, line))
+		}
+		break // the rest of the file will be the same
+	}
+	return problems
+}
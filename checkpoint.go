@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// checkpoint records the per-service results of a run across every service,
+// so that a run stopped early (e.g. by -maxApiCalls) resumes where it left
+// off rather than starting again.
+type checkpoint struct {
+	Command string                     `json:"command"`
+	Done    map[string]json.RawMessage `json:"done"`
+}
+
+var checkpointFile string
+
+func checkpointFlags(fs *flag.FlagSet) {
+	fs.StringVar(&checkpointFile, "checkpoint", "", "File to record progress in, so that a run stopped early (e.g. by -maxApiCalls) resumes where it left off. Not used with -dryRun.")
+}
+
+// loadCheckpoint resumes from -checkpoint, if it was left by an earlier run
+// of the same command. A -dryRun neither resumes nor records anything, as
+// a real run would then skip what it only pretended to do.
+func loadCheckpoint() *checkpoint {
+	c := &checkpoint{Command: commandName, Done: map[string]json.RawMessage{}}
+	if checkpointFile == "" || dryRun {
+		return c
+	}
+
+	data, err := ioutil.ReadFile(checkpointFile)
+	if os.IsNotExist(err) {
+		return c
+	}
+	check(err)
+
+	var saved checkpoint
+	check(json.Unmarshal(data, &saved))
+	if saved.Command == commandName && saved.Done != nil {
+		c.Done = saved.Done
+	}
+	return c
+}
+
+// result decodes the result recorded for id, reporting whether there was one.
+func (c *checkpoint) result(id string, out interface{}) bool {
+	raw, ok := c.Done[id]
+	return ok && json.Unmarshal(raw, out) == nil
+}
+
+// done records the result for id.
+func (c *checkpoint) done(id string, result interface{}) {
+	raw, err := json.Marshal(result)
+	check(err)
+	c.Done[id] = raw
+}
+
+// save writes the checkpoint, for a run that is stopping early.
+func (c *checkpoint) save() {
+	if checkpointFile == "" || dryRun {
+		return
+	}
+
+	data, err := json.MarshalIndent(c, "", "  ")
+	check(err)
+	check(ioutil.WriteFile(checkpointFile, data, 0600))
+}
+
+// finish removes the checkpoint of a completed run.
+func (c *checkpoint) finish() {
+	if checkpointFile != "" && !dryRun {
+		os.Remove(checkpointFile)
+	}
+}
+
+// stopIfExhausted stops the run cleanly if err is for want of API calls:
+// the notifications so far are sent, and the checkpoint is saved for a rerun
+// to resume from. What failed isn't recorded as done, so is tried again.
+func (c *checkpoint) stopIfExhausted(err error) {
+	if err == nil || !errors.Is(err, errBudgetExhausted) && !budgetSpent() {
+		return
+	}
+	flushNotifications()
+	c.save()
+
+	resume := "Give -checkpoint for a run stopped like this to be resumed."
+	if checkpointFile != "" {
+		resume = "Rerun with the same -checkpoint to resume."
+	}
+	check(fmt.Errorf("Stopped: %s. %s", errBudgetExhausted.Error(), resume))
+}
+
+// stopIfOutOfCalls is stopIfExhausted for the outcome of a change.
+func (c *checkpoint) stopIfOutOfCalls(o outcome) {
+	if o.Status == statusFailed {
+		c.stopIfExhausted(errors.New(o.Detail))
+	}
+}
+
+// s3Loggings is s3Loggings of a service's active version, recorded in the
+// checkpoint (without their secrets) so that resuming doesn't list them
+// again.
+func (c *checkpoint) s3Loggings(ctx context.Context, s accountService) ([]s3Logging, error) {
+	id := "s3:" + s.svc.ID
+	var loggings []s3Logging
+	if c.result(id, &loggings) {
+		return loggings, nil
+	}
+
+	loggings, err := s.f.s3Loggings(ctx, s.svc.ID, s.svc.Version)
+	if err != nil {
+		return nil, err
+	}
+	saved := make([]s3Logging, len(loggings))
+	for i, l := range loggings {
+		l.SecretKey = ""
+		saved[i] = l
+	}
+	c.done(id, saved)
+	return loggings, nil
+}
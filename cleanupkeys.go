@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// staleKey is an access key of a logging IAM user that no endpoint uses.
+type staleKey struct {
+	userName string
+	key      accessKeyMetadata
+	lastUsed time.Time // zero if never used
+	reason   string
+}
+
+// cleanupKeys deletes the old access keys left on logging IAM users by past
+// rotations: keys no S3 logging configuration uses that are disabled, or
+// haven't been used for -unusedDays.
+func cleanupKeys(args []string) {
+	fs := flag.NewFlagSet("cleanup-keys", flag.ExitOnError)
+	serviceID := fs.String("serviceID", "", "A Fastly Service ID (default: every service on the account).")
+	unusedDays := fs.Int("unusedDays", 30, "Delete active keys that haven't been used for this many days.")
+	yes := fs.Bool("yes", false, "Delete the keys without asking for confirmation.")
+	checkpointFlags(fs)
+	commonFlags(fs)
+
+	fs.Usage = commandUsage(fs, "Note, FASTLY_KEY (or the tokens of each account in the config file) must be provided as env vars, and AWS credentials able to list and delete the logging users' access keys in the standard AWS env vars.")
+	parseFlags(fs, args)
+
+	creds := awsEnvCredentials()
+	checkArg("AWS_ACCESS_KEY_ID", creds.accessKey)
+	iam := newAWSClient(creds, "")
+	ctx := context.Background()
+
+	// The keys in use by any endpoint, by the IAM user that owns them.
+	inUse := map[string]bool{}
+	users := map[string]bool{}
+	cp := loadCheckpoint()
+	for _, s := range servicesFor(ctx, *serviceID) {
+		if s.svc.Version == 0 {
+			continue
+		}
+		loggings, err := cp.s3Loggings(ctx, s)
+		cp.stopIfExhausted(err)
+		check(err)
+
+		for _, l := range loggings {
+			if l.AccessKey == "" || l.IAMRole != "" {
+				continue
+			}
+			inUse[l.AccessKey] = true
+
+			lastUsed, err := iam.accessKeyLastUsed(ctx, l.AccessKey)
+			if err != nil {
+				fmt.Fprintf(messages(), "Unable to find the IAM user of %s (s3/%s on %s): %s\n", l.AccessKey, l.Name, s.svc.ID, err.Error())
+				continue
+			}
+			users[lastUsed.UserName] = true
+		}
+	}
+	cp.finish()
+
+	maxUnused := time.Duration(*unusedDays) * 24 * time.Hour
+	var stale []staleKey
+	for userName := range users {
+		keys, err := iam.listAccessKeys(ctx, userName)
+		check(err)
+
+		for _, k := range keys {
+			if inUse[k.AccessKeyID] {
+				continue
+			}
+			lastUsed, err := iam.accessKeyLastUsed(ctx, k.AccessKeyID)
+			check(err)
+
+			since := lastUsed.LastUsedDate
+			if since.IsZero() {
+				since = k.CreateDate
+			}
+
+			if k.Status == "Inactive" {
+				stale = append(stale, staleKey{userName, k, lastUsed.LastUsedDate, "disabled"})
+			} else if time.Since(since) > maxUnused {
+				stale = append(stale, staleKey{userName, k, lastUsed.LastUsedDate, fmt.Sprintf("unused for %d days", days(time.Since(since)))})
+			}
+		}
+	}
+	sort.Slice(stale, func(i, j int) bool { return stale[i].key.CreateDate.Before(stale[j].key.CreateDate) })
+
+	if len(stale) == 0 {
+		fmt.Fprintf(messages(), "No stale access keys on %d logging IAM user(s).\n", len(users))
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "USER\tKEY\tCREATED\tLAST USED\tREASON")
+	for _, s := range stale {
+		lastUsed := "never"
+		if !s.lastUsed.IsZero() {
+			lastUsed = s.lastUsed.Format("2006-01-02")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", s.userName, s.key.AccessKeyID, s.key.CreateDate.Format("2006-01-02"), lastUsed, s.reason)
+	}
+	w.Flush()
+
+	if dryRun {
+		fmt.Fprintf(messages(), "Would delete %d access key(s).\n", len(stale))
+		return
+	}
+	if !*yes && !confirm(fmt.Sprintf("Delete these %d access key(s)?", len(stale))) {
+		fmt.Fprintln(messages(), "Not deleting any keys.")
+		return
+	}
+
+	for _, s := range stale {
+		check(iam.deleteAccessKey(ctx, s.userName, s.key.AccessKeyID))
+		fmt.Fprintf(messages(), "Deleted %s of %s.\n", s.key.AccessKeyID, s.userName)
+	}
+}
+
+// accountService is a service along with the client for its account.
+type accountService struct {
+	f   *fastlyClient
+	svc service
+}
+
+// servicesFor is the one service given, or else every service across the
+// configured accounts.
+func servicesFor(ctx context.Context, serviceID string) []accountService {
+	if serviceID == "" {
+		return servicesForIDs(ctx, nil)
+	}
+	return servicesForIDs(ctx, []string{serviceID})
+}
+
+// servicesForIDs is servicesFor several services: every service if there
+// are none.
+func servicesForIDs(ctx context.Context, serviceIDs []string) []accountService {
+	if len(serviceIDs) > 0 {
+		var services []accountService
+		for _, id := range serviceIDs {
+			f := fastlyFor(id)
+			s, err := f.service(ctx, id)
+			check(err)
+			services = append(services, accountService{f, s})
+		}
+		return services
+	}
+
+	var all []accountService
+	for _, f := range fastlyAccounts() {
+		services, err := f.services(ctx)
+		check(err)
+		for _, s := range services {
+			all = append(all, accountService{f, s})
+		}
+	}
+	return all
+}
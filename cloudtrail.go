@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	cloudTrailCheck   bool
+	cloudTrailRegions listFlag
+	athenaTable       string
+	athenaOutput      string
+	athenaWorkgroup   string
+)
+
+// keyUsageFlags adds the flags for where to look for uses of an access key.
+func keyUsageFlags(fs *flag.FlagSet) {
+	fs.Var(&cloudTrailRegions, "cloudTrailRegion", "Region to look up CloudTrail management events in (default: us-east-1, for IAM, and AWS_REGION). Can be repeated.")
+	fs.StringVar(&athenaTable, "athenaTable", "", "Athena table over a CloudTrail trail (e.g. default.cloudtrail_logs) to query too, as only trails record S3 data events such as Fastly's uploads.")
+	fs.StringVar(&athenaOutput, "athenaOutput", "", "S3 location for Athena query results, e.g. s3://athena-results/, with -athenaTable (default: the workgroup's).")
+	fs.StringVar(&athenaWorkgroup, "athenaWorkgroup", "primary", "Athena workgroup to query in, with -athenaTable.")
+}
+
+// cloudTrailFlags adds the flags of commands that retire old access keys, to
+// check CloudTrail for uses of them first.
+func cloudTrailFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&cloudTrailCheck, "cloudTrailCheck", false, "Before deleting an old access key, check CloudTrail (and -athenaTable) for any use of it since the new key took over, and keep it if there is any.")
+	keyUsageFlags(fs)
+}
+
+// keyUsageEvent is a use of an access key recorded by CloudTrail.
+type keyUsageEvent struct {
+	Time        time.Time `json:"time"`
+	Source      string    `json:"source"`
+	EventSource string    `json:"event_source"`
+	EventName   string    `json:"event_name"`
+	Region      string    `json:"region"`
+	SourceIP    string    `json:"source_ip"`
+	UserAgent   string    `json:"user_agent"`
+}
+
+// keyUsageReport is the evidence that an access key is (or isn't) dead.
+type keyUsageReport struct {
+	AccessKey   string          `json:"access_key"`
+	Since       time.Time       `json:"since"`
+	Until       time.Time       `json:"until"`
+	Regions     []string        `json:"cloudtrail_regions"`
+	AthenaTable string          `json:"athena_table,omitempty"`
+	Events      []keyUsageEvent `json:"events"`
+}
+
+// keyUsage looks for uses of an access key since a time, in CloudTrail's
+// event history of each region and in -athenaTable. Event history only has
+// management events, and CloudTrail can take 15 minutes to record them.
+func keyUsage(ctx context.Context, accessKey string, since time.Time) (keyUsageReport, error) {
+	r := keyUsageReport{AccessKey: accessKey, Since: since.UTC(), Until: time.Now().UTC(), Regions: cloudTrailRegions, AthenaTable: athenaTable}
+	if len(r.Regions) == 0 {
+		r.Regions = []string{"us-east-1"}
+		if region := awsRegion(); region != "" && region != "us-east-1" {
+			r.Regions = append(r.Regions, region)
+		}
+	}
+
+	creds, err := operatorCredentials()
+	if err != nil {
+		return r, err
+	}
+	if creds.accessKey == "" {
+		return r, errors.New("Checking CloudTrail needs AWS credentials in the standard AWS env vars")
+	}
+	for _, region := range r.Regions {
+		events, err := lookupKeyEvents(ctx, newAWSClient(creds, region), accessKey, since, r.Until)
+		if err != nil {
+			return r, err
+		}
+		r.Events = append(r.Events, events...)
+	}
+	if athenaTable != "" {
+		events, err := athenaKeyEvents(ctx, newAWSClient(creds, awsRegion()), accessKey, since)
+		if err != nil {
+			return r, err
+		}
+		r.Events = append(r.Events, events...)
+	}
+
+	sort.Slice(r.Events, func(i, j int) bool { return r.Events[i].Time.Before(r.Events[j].Time) })
+	return r, nil
+}
+
+// https://docs.aws.amazon.com/awscloudtrail/latest/APIReference/API_LookupEvents.html
+func lookupKeyEvents(ctx context.Context, a *awsClient, accessKey string, since, until time.Time) ([]keyUsageEvent, error) {
+	var events []keyUsageEvent
+	in := map[string]interface{}{
+		"LookupAttributes": []map[string]string{{"AttributeKey": "AccessKeyId", "AttributeValue": accessKey}},
+		"StartTime":        since.Unix(),
+		"EndTime":          until.Unix(),
+	}
+	for {
+		var resp struct {
+			Events []struct {
+				EventTime       float64 `json:"EventTime"`
+				CloudTrailEvent string  `json:"CloudTrailEvent"`
+			} `json:"Events"`
+			NextToken string `json:"NextToken"`
+		}
+		if err := a.jsonAPI(ctx, "cloudtrail", "com.amazonaws.cloudtrail.v20131101.CloudTrail_20131101.LookupEvents", in, &resp); err != nil {
+			return nil, err
+		}
+
+		for _, e := range resp.Events {
+			var detail struct {
+				EventSource     string `json:"eventSource"`
+				EventName       string `json:"eventName"`
+				AWSRegion       string `json:"awsRegion"`
+				SourceIPAddress string `json:"sourceIPAddress"`
+				UserAgent       string `json:"userAgent"`
+			}
+			json.Unmarshal([]byte(e.CloudTrailEvent), &detail)
+			events = append(events, keyUsageEvent{
+				Time:        time.Unix(int64(e.EventTime), 0).UTC(),
+				Source:      "cloudtrail:" + a.region,
+				EventSource: detail.EventSource,
+				EventName:   detail.EventName,
+				Region:      detail.AWSRegion,
+				SourceIP:    detail.SourceIPAddress,
+				UserAgent:   detail.UserAgent,
+			})
+		}
+
+		if resp.NextToken == "" {
+			return events, nil
+		}
+		in["NextToken"] = resp.NextToken
+	}
+}
+
+var athenaTableName = regexp.MustCompile(`^[A-Za-z0-9_]+(\.[A-Za-z0-9_]+)?$`)
+
+// athenaKeyEvents queries an Athena table of CloudTrail logs, in the standard
+// schema, for uses of an access key.
+//
+// https://docs.aws.amazon.com/athena/latest/ug/cloudtrail-logs.html
+func athenaKeyEvents(ctx context.Context, a *awsClient, accessKey string, since time.Time) ([]keyUsageEvent, error) {
+	if !athenaTableName.MatchString(athenaTable) || !awsAccessKeyID.MatchString(accessKey) {
+		return nil, fmt.Errorf("Invalid -athenaTable %q or access key %q", athenaTable, accessKey)
+	}
+	query := fmt.Sprintf("SELECT eventtime, eventsource, eventname, awsregion, sourceipaddress, useragent FROM %s "+
+		"WHERE useridentity.accesskeyid = '%s' AND eventtime > '%s' ORDER BY eventtime LIMIT 1000",
+		athenaTable, accessKey, since.UTC().Format("2006-01-02T15:04:05Z"))
+
+	in := map[string]interface{}{"QueryString": query, "WorkGroup": athenaWorkgroup}
+	if athenaOutput != "" {
+		in["ResultConfiguration"] = map[string]string{"OutputLocation": athenaOutput}
+	}
+	var started struct {
+		QueryExecutionID string `json:"QueryExecutionId"`
+	}
+	if err := a.jsonAPI(ctx, "athena", "AmazonAthena.StartQueryExecution", in, &started); err != nil {
+		return nil, err
+	}
+	id := map[string]string{"QueryExecutionId": started.QueryExecutionID}
+
+	err := waitFor(ctx, 10*time.Minute, 5*time.Second, func() (bool, error) {
+		var resp struct {
+			QueryExecution struct {
+				Status struct {
+					State             string `json:"State"`
+					StateChangeReason string `json:"StateChangeReason"`
+				} `json:"Status"`
+			} `json:"QueryExecution"`
+		}
+		if err := a.jsonAPI(ctx, "athena", "AmazonAthena.GetQueryExecution", id, &resp); err != nil {
+			return false, err
+		}
+		switch s := resp.QueryExecution.Status; s.State {
+		case "SUCCEEDED":
+			return true, nil
+		case "FAILED", "CANCELLED":
+			return false, fmt.Errorf("Athena query %s %s: %s", started.QueryExecutionID, strings.ToLower(s.State), s.StateChangeReason)
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var events []keyUsageEvent
+	for {
+		var resp struct {
+			ResultSet struct {
+				Rows []struct {
+					Data []struct {
+						VarCharValue string `json:"VarCharValue"`
+					} `json:"Data"`
+				} `json:"Rows"`
+			} `json:"ResultSet"`
+			NextToken string `json:"NextToken"`
+		}
+		if err := a.jsonAPI(ctx, "athena", "AmazonAthena.GetQueryResults", id, &resp); err != nil {
+			return nil, err
+		}
+
+		for _, row := range resp.ResultSet.Rows {
+			if len(row.Data) != 6 || row.Data[0].VarCharValue == "eventtime" {
+				continue // the header
+			}
+			t, _ := time.Parse(time.RFC3339, row.Data[0].VarCharValue)
+			events = append(events, keyUsageEvent{
+				Time:        t,
+				Source:      "athena:" + athenaTable,
+				EventSource: row.Data[1].VarCharValue,
+				EventName:   row.Data[2].VarCharValue,
+				Region:      row.Data[3].VarCharValue,
+				SourceIP:    row.Data[4].VarCharValue,
+				UserAgent:   row.Data[5].VarCharValue,
+			})
+		}
+
+		if resp.NextToken == "" {
+			return events, nil
+		}
+		id["NextToken"] = resp.NextToken
+	}
+}
+
+// keyUsageCommand reports any use of an access key since a rotation away
+// from it, as evidence the key is dead before it is deleted.
+func keyUsageCommand(args []string) {
+	fs := flag.NewFlagSet("key-usage", flag.ExitOnError)
+	accessKey := fs.String("accessKey", "", "The old access key ID to look for.")
+	sinceFlag := fs.String("since", "", "Time to look for uses since, in RFC 3339 (default: the last rotation away from the key in the state file).")
+	out := fs.String("out", "", "File to write the report to as JSON, e.g. for the security team.")
+	keyUsageFlags(fs)
+	commonFlags(fs)
+
+	fs.Usage = commandUsage(fs, "Note, AWS credentials with cloudtrail:LookupEvents (and Athena access, with -athenaTable) must be provided in the standard AWS env vars.")
+	parseFlags(fs, args)
+
+	checkArg("accessKey", *accessKey)
+
+	var since time.Time
+	if *sinceFlag != "" {
+		var err error
+		since, err = time.Parse(time.RFC3339, *sinceFlag)
+		check(err)
+	} else {
+		state, err := loadState()
+		check(err)
+		for _, e := range state.History {
+			if e.OldKey == *accessKey {
+				since = e.RotatedAt
+			}
+		}
+		if since.IsZero() {
+			check(fmt.Errorf("No rotation away from %s in the state file, give -since", *accessKey))
+		}
+	}
+
+	r, err := keyUsage(context.Background(), *accessKey, since)
+	check(err)
+
+	if *out != "" {
+		data, err := json.MarshalIndent(r, "", "  ")
+		check(err)
+		check(ioutil.WriteFile(*out, append(data, '\n'), 0644))
+	}
+
+	where := "CloudTrail in " + strings.Join(r.Regions, ",")
+	if r.AthenaTable != "" {
+		where += " and Athena table " + r.AthenaTable
+	}
+	if len(r.Events) == 0 {
+		fmt.Printf("No use of %s found in %s between %s and %s.\n", r.AccessKey, where, r.Since.Format(time.RFC3339), r.Until.Format(time.RFC3339))
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tSOURCE\tEVENT\tREGION\tSOURCE IP\tUSER AGENT")
+	for _, e := range r.Events {
+		fmt.Fprintf(w, "%s\t%s\t%s:%s\t%s\t%s\t%s\n", e.Time.Format(time.RFC3339), e.Source, e.EventSource, e.EventName, e.Region, e.SourceIP, e.UserAgent)
+	}
+	w.Flush()
+	check(fmt.Errorf("%s was used %d time(s) since %s", r.AccessKey, len(r.Events), r.Since.Format(time.RFC3339)))
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// compare diffs the logging configuration of two services, such as staging
+// and production, to check they are set up the same way.
+func compare(args []string) {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	serviceA := fs.String("serviceA", "", "A Fastly Service ID, e.g. of staging.")
+	serviceB := fs.String("serviceB", "", "The Fastly Service ID to compare it with, e.g. of production.")
+	ignore := fs.String("ignore", "path", "Comma-separated fields expected to differ between the services.")
+	credentials := fs.Bool("credentials", false, "Also compare credentials (the "+strings.Join(credentialFields, ", ")+" and secret fields), which are otherwise expected to differ between the services.")
+	commonFlags(fs)
+
+	fs.Usage = commandUsage(fs, "Note, FASTLY_KEY (or the tokens of the services' accounts in the config file) must be provided as env vars. Exits with status 1 if the configurations differ.")
+	parseFlags(fs, args)
+
+	checkArg("serviceA", *serviceA)
+	checkArg("serviceB", *serviceB)
+
+	ctx := context.Background()
+	a := activeLoggingConfig(ctx, *serviceA)
+	b := activeLoggingConfig(ctx, *serviceB)
+
+	differences := diffLoggingConfig(a, b, parseFields(*ignore), *credentials)
+	for _, d := range differences {
+		fmt.Println(d)
+	}
+
+	fmt.Printf("Found %d difference(s) between the logging of %s and %s.\n", len(differences), *serviceA, *serviceB)
+	if len(differences) > 0 {
+		os.Exit(1)
+	}
+}
+
+func activeLoggingConfig(ctx context.Context, serviceID string) map[string][]map[string]interface{} {
+	f := fastlyFor(serviceID)
+
+	active, err := f.activeVersion(ctx, serviceID)
+	check(err)
+
+	config, err := f.loggingConfig(ctx, serviceID, active)
+	check(err)
+	return config
+}
+
+// diffLoggingConfig describes how b differs from a, endpoint by endpoint,
+// leaving out the ignored fields, and the credentials unless asked to compare
+// them.
+func diffLoggingConfig(a, b map[string][]map[string]interface{}, ignore []string, credentials bool) []string {
+	var differences []string
+	for _, t := range loggingTypes {
+		as, bs := byName(a[t]), byName(b[t])
+
+		var names []string
+		for name := range as {
+			names = append(names, name)
+		}
+		for name := range bs {
+			if _, ok := as[name]; !ok {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			la, inA := as[name]
+			lb, inB := bs[name]
+			switch {
+			case !inB:
+				differences = append(differences, fmt.Sprintf("%s/%s: only in A", t, name))
+			case !inA:
+				differences = append(differences, fmt.Sprintf("%s/%s: only in B", t, name))
+			default:
+				for _, field := range fieldNames(la, lb) {
+					if contains(ignore, field) || !credentials && (isSecretField(t, field) || contains(credentialFields, field)) {
+						continue
+					}
+					va, vb := jsonString(la[field]), jsonString(lb[field])
+					if va != vb {
+						differences = append(differences, fmt.Sprintf("%s/%s: %s is %s in A, %s in B", t, name, field, va, vb))
+					}
+				}
+			}
+		}
+	}
+	return differences
+}
+
+func byName(loggings []map[string]interface{}) map[string]map[string]interface{} {
+	named := map[string]map[string]interface{}{}
+	for _, l := range loggings {
+		named[fmt.Sprint(l["name"])] = l
+	}
+	return named
+}
+
+// fieldNames is the sorted union of the fields of a and b.
+func fieldNames(a, b map[string]interface{}) []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, m := range []map[string]interface{}{a, b} {
+		for name := range m {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func jsonString(v interface{}) string {
+	b, err := json.Marshal(v)
+	check(err)
+	return string(b)
+}
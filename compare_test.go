@@ -0,0 +1,35 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffLoggingConfig(t *testing.T) {
+	staging := map[string][]map[string]interface{}{"s3": {
+		{"name": "logs", "bucket_name": "logs", "path": "/staging/", "period": 300.0, "access_key": "AKIASTAGING", "secret_key": maskSecret("staging")},
+		{"name": "debug", "bucket_name": "logs"},
+	}}
+	prod := map[string][]map[string]interface{}{"s3": {
+		{"name": "logs", "bucket_name": "logs", "path": "/prod/", "period": 60.0, "access_key": "AKIAPROD", "secret_key": maskSecret("prod")},
+	}}
+
+	got := diffLoggingConfig(staging, prod, []string{"path"}, false)
+	want := []string{
+		"s3/debug: only in A",
+		"s3/logs: period is 300 in A, 60 in B",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("diffLoggingConfig() = %q, want %q", got, want)
+	}
+
+	got = diffLoggingConfig(staging, prod, []string{"path", "period"}, true)
+	want = []string{
+		"s3/debug: only in A",
+		`s3/logs: access_key is "AKIASTAGING" in A, "AKIAPROD" in B`,
+		`s3/logs: secret_key is "` + maskSecret("staging") + `" in A, "` + maskSecret("prod") + `" in B`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("diffLoggingConfig() with credentials = %q, want %q", got, want)
+	}
+}
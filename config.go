@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// config is the optional configuration file, given with -config or
+// FLC_CONFIG.
+type config struct {
+	Accounts   []accountConfig   `json:"accounts"`
+	Promotions []promotionConfig `json:"promotions"`
+	Schedules  []scheduleConfig  `json:"schedules"`
+	// RotationPolicy is held to by every change.
+	RotationPolicy rotationPolicy `json:"rotation_policy"`
+	// Flags are values for the flags of any command, by flag name, e.g.
+	// {"approvedBy": "ci"}, and Commands for the flags of one command, e.g.
+	// {"rotate-creds": {"loggingName": "logs", "tag": ["prod"]}}. Flags given
+	// on the command line or as env vars override both.
+	Flags    map[string]interface{}            `json:"flags"`
+	Commands map[string]map[string]interface{} `json:"commands"`
+}
+
+// accountConfig maps a group of services to the Fastly token for the account
+// they live in.
+type accountConfig struct {
+	Name string `json:"name"`
+	// Token names the secret holding the account's token: an env var, or a
+	// -secretCmd name. Defaults to FASTLY_KEY.
+	Token string `json:"token"`
+	// Services lists the IDs of the account's services. The account with no
+	// services listed is the default for any service not listed elsewhere.
+	Services []string `json:"services"`
+}
+
+// promotionConfig describes how the logging of one service differs when it
+// is promoted to another: for each field, strings to replace, e.g.
+// {"bucket_name": {"logs-staging": "logs-prod"}}.
+type promotionConfig struct {
+	From          string                       `json:"from"`
+	To            string                       `json:"to"`
+	Substitutions map[string]map[string]string `json:"substitutions"`
+}
+
+// scheduleConfig is a rotation for daemon to make on a schedule: minting a
+// new access key for an IAM user and putting it in place on an S3 logging
+// configuration.
+type scheduleConfig struct {
+	ServiceID   string `json:"service_id"`
+	LoggingName string `json:"logging_name"`
+	// Schedule is a cron expression, in UTC, e.g. "0 9 1 */3 *" or @quarterly.
+	Schedule string `json:"schedule"`
+	IAMUser  string `json:"iam_user"`
+	// RetireOldKey is keep, deactivate or delete, as with rotate-creds
+	// -retireOldKey. Defaults to delete, as IAM users can only have two keys.
+	RetireOldKey string `json:"retire_old_key"`
+}
+
+func (a accountConfig) tokenName() string {
+	if a.Token == "" {
+		return "FASTLY_KEY"
+	}
+	return a.Token
+}
+
+var (
+	configFile string
+	cfg        config
+	cfgOnce    sync.Once
+)
+
+// commonFlags adds the flags every command takes.
+func commonFlags(fs *flag.FlagSet) {
+	fs.StringVar(&configFile, "config", "", "Configuration file, JSON or (with a .yaml or .yml extension) YAML. TOML isn't supported.")
+	fs.BoolVar(&verbose, "verbose", false, "Log every Fastly API request and response (method, URL, status, timing and body), with secrets redacted.")
+	fs.BoolVar(&showStats, "stats", false, "Print API call counts, latencies and retries at the end of the run.")
+	fs.BoolVar(&dryRun, "dryRun", false, "Print the Fastly API calls that would make changes, with secrets redacted, without making them.")
+	fs.IntVar(&maxAPICalls, "maxApiCalls", 0, "Stop cleanly after this many Fastly API calls (0 for no limit).")
+	transportFlags(fs)
+	secretFlags(fs)
+	awsFlags(fs)
+}
+
+// loadConfig reads the configuration file, if there is one: JSON, or YAML by
+// its extension.
+func loadConfig() config {
+	cfgOnce.Do(func() {
+		if configFile == "" {
+			return
+		}
+
+		if strings.EqualFold(filepath.Ext(configFile), ".toml") {
+			check(fmt.Errorf("Config file %s is TOML, which isn't supported: use JSON, or YAML with a .yaml or .yml extension", configFile))
+		}
+		data, err := ioutil.ReadFile(configFile)
+		check(err)
+		if isYAMLFile(configFile) {
+			if data, err = yamlToJSON(data); err != nil {
+				check(fmt.Errorf("Invalid config file %s: %s", configFile, err.Error()))
+			}
+		}
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			check(fmt.Errorf("Invalid config file %s: %s", configFile, err.Error()))
+		}
+	})
+	return cfg
+}
+
+// setConfigFlags sets the flags that weren't given from the config file: the
+// command's own values, or else those for every command. Repeatable flags
+// can be given a list.
+func setConfigFlags(fs *flag.FlagSet, given map[string]bool) {
+	c := loadConfig()
+	own := c.Commands[commandName]
+	for name := range own {
+		if fs.Lookup(name) == nil {
+			check(fmt.Errorf("Invalid config file %s: %s has no flag -%s", configFile, commandName, name))
+		}
+	}
+
+	fs.VisitAll(func(f *flag.Flag) {
+		value, ok := own[f.Name]
+		if !ok {
+			value, ok = c.Flags[f.Name]
+		}
+		if given[f.Name] || !ok || f.Name == "config" {
+			return
+		}
+
+		values, ok := value.([]interface{})
+		if !ok {
+			values = []interface{}{value}
+		}
+		for _, v := range values {
+			if err := fs.Set(f.Name, configValue(v)); err != nil {
+				check(fmt.Errorf("Invalid config file %s: -%s: %s", configFile, f.Name, err.Error()))
+			}
+		}
+	})
+}
+
+// configValue is a JSON value as a flag value.
+func configValue(v interface{}) string {
+	if n, ok := v.(float64); ok {
+		return strconv.FormatFloat(n, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// fastlyFor is a client for the Fastly account a service lives in (or the
+// default account, for a blank serviceID).
+func fastlyFor(serviceID string) *fastlyClient {
+	tokenName := fastlyTokenName(serviceID)
+	key := secret(tokenName)
+	checkArg(tokenName, key)
+	return newFastlyClient(key)
+}
+
+// fastlyClientFor is fastlyFor, returning any error obtaining the token
+// rather than exiting, as the daemon must.
+func fastlyClientFor(serviceID string) (*fastlyClient, error) {
+	tokenName := fastlyTokenName(serviceID)
+	key, err := cachedSecret(tokenName)
+	if err != nil {
+		return nil, err
+	}
+	if key == "" {
+		return nil, fmt.Errorf("Missing required arg '%s'.", tokenName)
+	}
+	return newFastlyClient(key), nil
+}
+
+// fastlyTokenName is the name of the secret holding the token of the account
+// with the service.
+func fastlyTokenName(serviceID string) string {
+	tokenName := "FASTLY_KEY"
+	for _, a := range loadConfig().Accounts {
+		if len(a.Services) == 0 {
+			tokenName = a.tokenName()
+		}
+	}
+	for _, a := range loadConfig().Accounts {
+		for _, id := range a.Services {
+			if id == serviceID && serviceID != "" {
+				tokenName = a.tokenName()
+			}
+		}
+	}
+	return tokenName
+}
+
+// fastlyAccounts is a client for each configured account, for commands that
+// work across every service.
+func fastlyAccounts() []*fastlyClient {
+	accounts := loadConfig().Accounts
+	if len(accounts) == 0 {
+		return []*fastlyClient{fastlyFor("")}
+	}
+
+	var clients []*fastlyClient
+	seen := map[string]bool{}
+	for _, a := range accounts {
+		if seen[a.tokenName()] {
+			continue
+		}
+		seen[a.tokenName()] = true
+
+		key := secret(a.tokenName())
+		checkArg(a.tokenName(), key)
+		clients = append(clients, newFastlyClient(key))
+	}
+	return clients
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// create adds a logging configuration of any type to a service. Fields are
+// given with -set, and secret fields read from env vars with -secret.
+func create(args []string) {
+	fs := flag.NewFlagSet("create", flag.ExitOnError)
+	serviceID := fs.String("serviceID", "", "A Fastly Service ID.")
+	typ := fs.String("type", "", "Type of logging configuration to create, one of "+strings.Join(loggingTypes, ",")+".")
+	loggingName := fs.String("loggingName", "", "Name of the new logging configuration.")
+	var sets, secrets listFlag
+	fs.Var(&sets, "set", "A field of the configuration, as field=value, e.g. bucket_name=my-logs. Can be repeated.")
+	fs.Var(&secrets, "secret", "A secret field of the configuration, as field=NAME to read it from the NAME env var (or -secretCmd), e.g. secret_key=GCS_SECRET_KEY. Can be repeated.")
+	role := fs.String("iamRoleArn", "", "ARN of an IAM role for Fastly to assume to deliver logs, in place of access keys, with -type s3 or kinesis.")
+	providerFlags(fs)
+	draftFlags(fs)
+	notifyFlags(fs)
+	commonFlags(fs)
+
+	fs.Usage = commandUsage(fs, "Note, FASTLY_KEY and the secrets named by -secret must be provided as env vars.")
+	parseFlags(fs, args)
+
+	checkArg("serviceID", *serviceID)
+	checkArg("type", *typ)
+	checkArg("loggingName", *loggingName)
+	p, err := providerFor(*typ)
+	check(err)
+
+	fields := url.Values{"name": {*loggingName}}
+	for _, set := range sets {
+		field, value, err := parseAssignment(set)
+		check(err)
+		fields.Set(field, value)
+	}
+	for _, s := range secrets {
+		field, name, err := parseAssignment(s)
+		check(err)
+		value := secret(name)
+		checkArg(name, value)
+		fields.Set(field, value)
+	}
+
+	if *role != "" {
+		check(checkIAMRole(*typ, *role))
+		if fields.Get("access_key") != "" || fields.Get("secret_key") != "" {
+			check(errors.New("-iamRoleArn can't be used with access keys"))
+		}
+		fields.Set("iam_role", *role)
+	}
+
+	if domain, ok := providerDomain(); ok && *typ == "s3" {
+		fields.Set("domain", domain)
+	}
+
+	f := fastlyFor(*serviceID)
+	ctx := context.Background()
+
+	number, err := withDraft(ctx, f, *serviceID, func(number int) error {
+		return p.create(ctx, f, *serviceID, number, fields)
+	})
+	if err != nil {
+		notify(failedOutcome(*serviceID, err))
+		flushNotifications()
+		check(err)
+	}
+
+	msg := fmt.Sprintf("Activated version %d of service %s, with new %s logging %s.", number, *serviceID, *typ, *loggingName)
+	notify(outcome{ServiceID: *serviceID, Status: statusRotated, Detail: msg})
+	flushNotifications()
+	fmt.Fprintln(messages(), msg)
+}
+
+// parseAssignment parses field=value.
+func parseAssignment(s string) (string, string, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", "", fmt.Errorf("Invalid %q, expected field=value", s)
+	}
+	return parts[0], parts[1], nil
+}
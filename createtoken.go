@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// https://developer.fastly.com/reference/api/auth-tokens/automation/
+type automationToken struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	AccessToken string `json:"access_token"`
+	ExpiresAt   string `json:"expires_at"`
+}
+
+// createAutomationToken mints an automation token limited to the given services, with
+// the engineer role and global scope that cloning, changing and activating
+// versions needs, for a scheduled deployment of this tool to use.
+func (f *fastlyClient) createAutomationToken(ctx context.Context, name string, services []string, expiresAt time.Time) (automationToken, error) {
+	params := url.Values{
+		"name":       {name},
+		"role":       {"engineer"},
+		"scope":      {"global"},
+		"expires_at": {expiresAt.UTC().Format(time.RFC3339)},
+	}
+	for _, s := range services {
+		params.Add("services[]", s)
+	}
+
+	var t automationToken
+	err := f.do(ctx, http.MethodPost, "/automation-tokens", params, &t)
+	return t, err
+}
+
+// createToken mints a least-privilege automation token for one scheduled
+// deployment, stores it and records its expiry in the state file.
+func createToken(args []string) {
+	fs := flag.NewFlagSet("create-token", flag.ExitOnError)
+	name := fs.String("name", "", "Name of the token, e.g. after the deployment that will use it (default: fastly-logging-creds and the host name).")
+	var services listFlag
+	fs.Var(&services, "serviceID", "A Fastly Service ID the token may change. Can be repeated.")
+	expiresIn := fs.Duration("expiresIn", 90*24*time.Hour, "How long the token is valid for.")
+	store := fs.String("store", "", "Where to put the token: 'keyring' (as FASTLY_KEY), or a command given the token on stdin (default: print it).")
+	commonFlags(fs)
+
+	fs.Usage = commandUsage(fs, "Note, FASTLY_KEY must be a token of a superuser, able to create automation tokens.")
+	parseFlags(fs, args)
+
+	if len(services) == 0 {
+		checkArg("serviceID", "")
+	}
+	if *name == "" {
+		*name = tokenName("automation")
+	}
+
+	f := fastlyFor(services[0])
+	ctx := context.Background()
+
+	expiresAt := time.Now().Add(*expiresIn)
+	t, err := f.createAutomationToken(ctx, *name, services, expiresAt)
+	check(err)
+
+	switch *store {
+	case "":
+		fmt.Println(t.AccessToken)
+	case "keyring":
+		check(keyringSet("FASTLY_KEY", t.AccessToken))
+	default:
+		cmd := shellCommand(ctx, *store)
+		cmd.Stdin = strings.NewReader(t.AccessToken)
+		cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+		if err := cmd.Run(); err != nil {
+			check(fmt.Errorf("Created token %s, but -store failed: %s. Revoke it, or store it by hand.", t.ID, err.Error()))
+		}
+	}
+
+	if parsed, err := time.Parse(time.RFC3339, t.ExpiresAt); err == nil {
+		expiresAt = parsed
+	}
+	recordToken(t.ID, tokenRecord{Name: *name, Services: services, CreatedAt: time.Now().UTC(), ExpiresAt: expiresAt.UTC()})
+	fmt.Fprintf(os.Stderr, "Created automation token %s for %s, expiring %s.\n", t.ID, strings.Join(services, ", "), expiresAt.UTC().Format(time.RFC3339))
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// credentialSpec describes the credentials of a logging type, for
+// rotate-creds.
+type credentialSpec struct {
+	// secrets maps the secret fields to the secret (env var) holding the
+	// new value.
+	secrets map[string]string
+	// optionalSecrets are secret fields that are only changed if their
+	// secret is set.
+	optionalSecrets map[string]string
+	// required are the fields that identify the credentials, and so must be
+	// given along with the secrets.
+	required []string
+	// iamRole is set for AWS types that can be given an IAM role to assume
+	// (with -set iam_role=...) in place of keys.
+	iamRole bool
+}
+
+// addSecrets checks the fields identifying new credentials are given, and
+// adds the secrets to them.
+func (spec credentialSpec) addSecrets(typ string, fields url.Values) error {
+	if spec.iamRole && fields.Get("iam_role") != "" {
+		// Switching to a role, so the keys are cleared.
+		for field := range spec.secrets {
+			fields.Set(field, "")
+		}
+		for _, field := range spec.required {
+			fields.Set(field, "")
+		}
+		return nil
+	}
+
+	for _, field := range spec.required {
+		if fields.Get(field) == "" {
+			return fmt.Errorf("Missing required field '%s' for %s, give it with -set %s=...", field, typ, field)
+		}
+	}
+
+	rotated := false
+	for field, name := range spec.secrets {
+		value := secret(name)
+		if value == "" {
+			return fmt.Errorf("Missing required arg '%s'.", name)
+		}
+		fields.Set(field, value)
+		rotated = true
+	}
+	for field, name := range spec.optionalSecrets {
+		if value := secret(name); value != "" {
+			fields.Set(field, value)
+			rotated = true
+		}
+	}
+	if !rotated && spec.rotatable() {
+		return fmt.Errorf("No new credentials for %s: set %s", typ, spec.secretsUsage())
+	}
+	if !rotated {
+		return fmt.Errorf("Rotating the credentials of %s logging isn't supported", typ)
+	}
+	return nil
+}
+
+// rotatableTypes are the logging types other than s3 that rotate-creds can
+// set new credentials for.
+func rotatableTypes() []string {
+	var types []string
+	for _, t := range loggingTypes {
+		if t != "s3" && loggingProviders[t].credentials().rotatable() {
+			types = append(types, t)
+		}
+	}
+	sort.Strings(types)
+	return types
+}
+
+// credentialSecretsUsage lists the secrets rotate-creds reads, by type.
+func credentialSecretsUsage() string {
+	uses := []string{"AWS_SECRET_KEY for s3"}
+	for _, t := range rotatableTypes() {
+		uses = append(uses, fmt.Sprintf("%s for %s", loggingProviders[t].credentials().secretsUsage(), t))
+	}
+	return strings.Join(uses, ", ")
+}
+
+func (spec credentialSpec) rotatable() bool {
+	return len(spec.secrets) > 0 || len(spec.optionalSecrets) > 0
+}
+
+// secretsUsage names the secrets of a type, e.g. "A (and optionally B)".
+func (spec credentialSpec) secretsUsage() string {
+	names := func(secrets map[string]string, sep string) string {
+		var names []string
+		for _, name := range secrets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return strings.Join(names, sep)
+	}
+
+	switch {
+	case len(spec.optionalSecrets) == 0:
+		return names(spec.secrets, " and ")
+	case len(spec.secrets) == 0:
+		return names(spec.optionalSecrets, " and/or ")
+	}
+	return fmt.Sprintf("%s (and optionally %s)", names(spec.secrets, " and "), names(spec.optionalSecrets, " and "))
+}
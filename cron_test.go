@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronScheduleMatches(t *testing.T) {
+	expect := func(expr, at string, want bool) {
+		t.Helper()
+		s, err := parseCron(expr)
+		if err != nil {
+			t.Errorf("parseCron(%q): %s", expr, err)
+			return
+		}
+		when, err := time.Parse("2006-01-02 15:04", at)
+		if err != nil {
+			panic(err)
+		}
+		if got := s.matches(when); got != want {
+			t.Errorf("%q matches(%s) = %t, want %t", expr, when.Format("Mon 2006-01-02 15:04"), got, want)
+		}
+	}
+
+	// 2024-01-01 is a Monday.
+	expect("* * * * *", "2024-01-01 12:34", true)
+	expect("0 9 * * *", "2024-01-01 09:00", true)
+	expect("0 9 * * *", "2024-01-01 09:01", false)
+	expect("*/15 * * * *", "2024-01-01 10:45", true)
+	expect("*/15 * * * *", "2024-01-01 10:50", false)
+	expect("5/20 * * * *", "2024-01-01 10:45", true)
+	expect("0 9-17 * * 1-5", "2024-01-05 17:00", true)
+	expect("0 9-17 * * 1-5", "2024-01-06 12:00", false)
+	expect("0 9 1 */3 *", "2024-04-01 09:00", true)
+	expect("0 9 1 */3 *", "2024-02-01 09:00", false)
+
+	// Sunday is 0 or 7.
+	expect("0 0 * * 0", "2024-01-07 00:00", true)
+	expect("0 0 * * 7", "2024-01-07 00:00", true)
+
+	// As in cron, either day field matches when both are restricted, and
+	// both have to when either starts with *.
+	expect("0 0 13 * 5", "2024-01-13 00:00", true)
+	expect("0 0 13 * 5", "2024-01-12 00:00", true)
+	expect("0 0 13 * 5", "2024-01-11 00:00", false)
+	expect("0 0 */2 * *", "2024-01-02 00:00", false)
+	expect("0 0 */2 * 1", "2024-01-08 00:00", false)
+	expect("0 0 */2 * 1", "2024-01-15 00:00", true)
+
+	expect("@hourly", "2024-01-01 13:00", true)
+	expect("@daily", "2024-01-01 01:00", false)
+	expect("@weekly", "2024-01-07 00:00", true)
+	expect("@quarterly", "2024-07-01 00:00", true)
+	expect("@yearly", "2024-02-01 00:00", false)
+
+	for _, expr := range []string{"", "* * * *", "* * * * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "5-1 * * * *", "*/0 * * * *", "a * * * *", "@sometimes"} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parseCron(%q) succeeded, want an error", expr)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+)
+
+// daemon runs until stopped, making the rotations in the config file's
+// schedules when they fall due, and recording the result of each in the
+// state file. A key older than the rotation policy's max_key_age_days is
+// rotated without waiting for its schedule.
+func daemon(args []string) {
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	cloudTrailFlags(fs)
+	notifyFlags(fs)
+	draftFlags(fs)
+	commonFlags(fs)
+
+	fs.Usage = commandUsage(fs, "Note, -config (or FLC_CONFIG) must give the schedules, and FASTLY_KEY (or the tokens of each account in the config file) and AWS credentials with access to create and retire the IAM users' keys must be provided as env vars.")
+	parseFlags(fs, args)
+
+	schedules := loadConfig().Schedules
+	if len(schedules) == 0 {
+		check(errors.New("No schedules in the config file"))
+	}
+
+	crons := make([]cronSchedule, len(schedules))
+	for i, s := range schedules {
+		checkArg("service_id", s.ServiceID)
+		checkArg("logging_name", s.LoggingName)
+		checkArg("iam_user", s.IAMUser)
+		if s.RetireOldKey == "" {
+			schedules[i].RetireOldKey = "delete"
+		} else if !contains([]string{"keep", "deactivate", "delete"}, s.RetireOldKey) {
+			check(fmt.Errorf("Unknown retire_old_key '%s' for %s, expected keep, deactivate or delete", s.RetireOldKey, s.LoggingName))
+		}
+
+		var err error
+		crons[i], err = parseCron(s.Schedule)
+		check(err)
+		fmt.Fprintf(messages(), "%s/%s: %s, next at %s.\n", s.ServiceID, s.LoggingName, s.Schedule, crons[i].next(time.Now().UTC()).Format(time.RFC3339))
+	}
+	if dryRun {
+		return
+	}
+
+	creds := awsEnvCredentials()
+	checkArg("AWS_ACCESS_KEY_ID", creds.accessKey)
+	for _, s := range schedules {
+		fastlyFor(s.ServiceID)
+	}
+
+	ctx := context.Background()
+	// Every minute since the last check is checked, so that a schedule that
+	// fell due during a long rotation still runs, late, rather than being
+	// missed. A schedule that fell due more than once runs once.
+	checked := time.Now().UTC().Truncate(time.Minute).Add(-time.Minute)
+	// Key ages are checked on starting, and then hourly.
+	agesChecked := time.Time{}
+	for {
+		now := time.Now().UTC().Truncate(time.Minute)
+		checkAges := now.Sub(agesChecked) >= time.Hour
+		if checkAges {
+			agesChecked = now
+		}
+		for i, s := range schedules {
+			due := time.Time{}
+			for t := checked.Add(time.Minute); !t.After(now); t = t.Add(time.Minute) {
+				if crons[i].matches(t) {
+					due = t
+				}
+			}
+			if due.IsZero() && checkAges {
+				overdue, err := keyOverdue(ctx, s)
+				if err != nil {
+					fmt.Fprintf(messages(), "Unable to tell the age of the key of %s/%s: %s\n", s.ServiceID, s.LoggingName, err.Error())
+				} else if overdue {
+					fmt.Fprintf(messages(), "%s/%s: the key is older than the rotation policy's max_key_age_days, rotating it now.\n", s.ServiceID, s.LoggingName)
+					due = now
+				}
+			}
+			if due.IsZero() || ranAt(s, due) {
+				continue
+			}
+
+			o := runSchedule(ctx, s)
+			notify(o)
+			flushNotifications()
+			outcomes = nil
+			recordScheduleRun(s, due, o)
+			fmt.Fprintf(messages(), "%s %s/%s %s: %s\n", due.Format(time.RFC3339), s.ServiceID, s.LoggingName, o.Status, o.Detail)
+		}
+		checked = now
+
+		time.Sleep(time.Until(now.Add(time.Minute)))
+	}
+}
+
+// runSchedule mints a new key for the schedule's IAM user and rotates to it,
+// deleting it again if it isn't put in place. Unlike other commands, it
+// returns any failure as the outcome rather than exiting, so the daemon keeps
+// running.
+func runSchedule(ctx context.Context, s scheduleConfig) outcome {
+	failed := func(err error) outcome {
+		return outcome{ServiceID: s.ServiceID, Status: statusFailed, Detail: err.Error()}
+	}
+
+	operator, err := operatorCredentials()
+	if err != nil {
+		return failed(err)
+	}
+	a := newAWSClient(operator, "")
+	f, err := fastlyClientFor(s.ServiceID)
+	if err != nil {
+		return failed(err)
+	}
+	creds, err := a.createAccessKey(ctx, s.IAMUser)
+	if err != nil {
+		return failed(err)
+	}
+
+	var o outcome
+	rotated := false
+	if err := waitForAccessKey(ctx, creds); err != nil {
+		o = failed(err)
+	} else if s.RetireOldKey == "keep" {
+		o = verifyPolicyDeliveries(ctx, []outcome{rotateService(ctx, f, s.ServiceID, s.LoggingName, creds.accessKey, creds.secretKey)})[0]
+		rotated = o.Status == statusRotated || o.Status == statusUnverified
+	} else {
+		o, rotated = rotateIAMKey(ctx, f, a, s.ServiceID, s.LoggingName, creds.accessKey, creds.secretKey, s.RetireOldKey, 0, 0)
+	}
+
+	if !rotated {
+		if err := a.deleteAccessKey(ctx, s.IAMUser, creds.accessKey); err != nil {
+			o.Detail = fmt.Sprintf("%s Unable to delete the new access key %s: %s", o.Detail, creds.accessKey, err.Error())
+		}
+	}
+	return o
+}
+
+// keyOverdue tells whether the access key of the schedule's logging
+// configuration is older than the rotation policy's max_key_age_days.
+func keyOverdue(ctx context.Context, s scheduleConfig) (bool, error) {
+	days := loadConfig().RotationPolicy.MaxKeyAgeDays
+	if days <= 0 {
+		return false, nil
+	}
+
+	f, err := fastlyClientFor(s.ServiceID)
+	if err != nil {
+		return false, err
+	}
+	active, err := f.activeVersion(ctx, s.ServiceID)
+	if err != nil {
+		return false, err
+	}
+	l, err := f.s3Logging(ctx, s.ServiceID, active, s.LoggingName)
+	if err != nil || l.AccessKey == "" {
+		return false, err
+	}
+
+	creds, err := operatorCredentials()
+	if err != nil {
+		return false, err
+	}
+	created, err := newAWSClient(creds, "").accessKeyCreated(ctx, l.AccessKey)
+	if err != nil {
+		return false, err
+	}
+	return time.Since(created) > time.Duration(days)*24*time.Hour, nil
+}
+
+// ranAt tells whether the schedule has already run in this minute, e.g.
+// before the daemon was restarted.
+func ranAt(s scheduleConfig, t time.Time) bool {
+	state, err := loadState()
+	if err != nil {
+		return false
+	}
+	r, ok := state.Schedules[stateKey("s3", s.ServiceID, s.LoggingName)]
+	return ok && !r.RanAt.Before(t)
+}
+
+// recordScheduleRun notes the result of a scheduled rotation in the state
+// file. Failures are reported but not fatal, so the daemon keeps running.
+func recordScheduleRun(s scheduleConfig, t time.Time, o outcome) {
+	err := updateState(func(state *rotationState) {
+		state.Schedules[stateKey("s3", s.ServiceID, s.LoggingName)] = scheduleRecord{Schedule: s.Schedule, RanAt: t, Status: o.Status, Detail: o.Detail}
+	})
+	if err != nil {
+		fmt.Fprintf(messages(), "Unable to record scheduled run in state file: %s\n", err.Error())
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// https://developer.fastly.com/reference/api/logging/datadog/
+type datadogLogging struct {
+	Name   string `json:"name"`
+	Token  string `json:"token"`
+	Region string `json:"region"`
+}
+
+func (f *fastlyClient) datadogLogging(ctx context.Context, serviceID string, version int, name string) (datadogLogging, error) {
+	var l datadogLogging
+	err := f.do(ctx, http.MethodGet, fmt.Sprintf("/service/%s/version/%d/logging/datadog/%s", serviceID, version, name), nil, &l)
+	return l, err
+}
+
+func (f *fastlyClient) updateDatadogToken(ctx context.Context, serviceID string, version int, name, token string) error {
+	return f.updateLogging(ctx, serviceID, version, "datadog", name, url.Values{"token": {token}})
+}
+
+// datadogClient calls the Datadog API with an operator's API and application
+// keys.
+//
+// https://docs.datadoghq.com/api/latest/key-management/
+type datadogClient struct {
+	site   string
+	apiKey string
+	appKey string
+	client *http.Client
+}
+
+func (d *datadogClient) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, "https://api."+d.site+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("DD-API-KEY", d.apiKey)
+	req.Header.Add("DD-APPLICATION-KEY", d.appKey)
+	req.Header.Add("Accept", "application/json")
+	if body != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s %s failed: %d, %s", method, path, resp.StatusCode, string(respBody))
+	}
+
+	if out == nil || len(respBody) == 0 {
+		return nil
+	}
+	return json.Unmarshal(respBody, out)
+}
+
+type datadogAPIKey struct {
+	ID         string `json:"id"`
+	Attributes struct {
+		Name  string `json:"name"`
+		Key   string `json:"key"`
+		Last4 string `json:"last4"`
+	} `json:"attributes"`
+}
+
+// apiKeyFor finds the key with the given value. Datadog only lists the last
+// four characters of keys, so this refuses to guess between several matches.
+func (d *datadogClient) apiKeyFor(ctx context.Context, key string) (datadogAPIKey, error) {
+	if len(key) < 4 {
+		return datadogAPIKey{}, fmt.Errorf("Invalid Datadog API key")
+	}
+	last4 := key[len(key)-4:]
+
+	var resp struct {
+		Data []datadogAPIKey `json:"data"`
+	}
+	if err := d.do(ctx, http.MethodGet, "/api/v2/api_keys?page[size]=1000&filter="+url.QueryEscape(last4), nil, &resp); err != nil {
+		return datadogAPIKey{}, err
+	}
+
+	var matches []datadogAPIKey
+	for _, k := range resp.Data {
+		if k.Attributes.Last4 == last4 {
+			matches = append(matches, k)
+		}
+	}
+
+	switch len(matches) {
+	case 1:
+		return matches[0], nil
+	case 0:
+		return datadogAPIKey{}, fmt.Errorf("No Datadog API key ends in %s", last4)
+	default:
+		return datadogAPIKey{}, fmt.Errorf("%d Datadog API keys end in %s, unable to tell which is in use", len(matches), last4)
+	}
+}
+
+func (d *datadogClient) createAPIKey(ctx context.Context, name string) (datadogAPIKey, error) {
+	in := map[string]interface{}{
+		"data": map[string]interface{}{
+			"type":       "api_keys",
+			"attributes": map[string]string{"name": name},
+		},
+	}
+
+	var resp struct {
+		Data datadogAPIKey `json:"data"`
+	}
+	err := d.do(ctx, http.MethodPost, "/api/v2/api_keys", in, &resp)
+	return resp.Data, err
+}
+
+func (d *datadogClient) revokeAPIKey(ctx context.Context, id string) error {
+	return d.do(ctx, http.MethodDelete, "/api/v2/api_keys/"+url.PathEscape(id), nil, nil)
+}
+
+// hasLogs reports whether any logs matching query have arrived since t.
+//
+// https://docs.datadoghq.com/api/latest/logs/#search-logs
+func (d *datadogClient) hasLogs(ctx context.Context, query string, t time.Time) (bool, error) {
+	in := map[string]interface{}{
+		"filter": map[string]string{"query": query, "from": t.UTC().Format(time.RFC3339), "to": "now"},
+		"page":   map[string]int{"limit": 1},
+	}
+
+	var resp struct {
+		Data []json.RawMessage `json:"data"`
+	}
+	err := d.do(ctx, http.MethodPost, "/api/v2/logs/events/search", in, &resp)
+	return len(resp.Data) > 0, err
+}
+
+// datadogSite maps a Fastly Datadog region to its Datadog site.
+func datadogSite(region string) string {
+	if region == "EU" {
+		return "datadoghq.eu"
+	}
+	return "datadoghq.com"
+}
+
+// rotateDatadogKey creates a new Datadog API key, switches Fastly to it,
+// checks log intake continues, then revokes the old key.
+func rotateDatadogKey(args []string) {
+	fs := flag.NewFlagSet("rotate-datadog-key", flag.ExitOnError)
+	serviceID := fs.String("serviceID", "", "A Fastly Service ID.")
+	loggingName := fs.String("loggingName", "", "Name of your service Datadog logging configuration in Fastly.")
+	verifyQuery := fs.String("verifyQuery", "source:fastly", "Datadog log search query matching the logs this endpoint sends.")
+	keepOldKey := fs.Bool("keepOldKey", false, "Don't revoke the old API key after verifying log intake.")
+	verifyTimeout := fs.Duration("verifyTimeout", 10*time.Minute, "How long to wait for logs to arrive with the new key.")
+	draftFlags(fs)
+	commonFlags(fs)
+
+	fs.Usage = commandUsage(fs, "Note, FASTLY_KEY, DD_API_KEY and DD_APP_KEY (Datadog keys able to manage API keys and search logs) must be provided as env vars.")
+	parseFlags(fs, args)
+	refuseDryRun("rotate-datadog-key")
+
+	ddAPIKey := secret("DD_API_KEY")
+	ddAppKey := secret("DD_APP_KEY")
+
+	checkArg("serviceID", *serviceID)
+	checkArg("loggingName", *loggingName)
+	checkArg("DD_API_KEY", ddAPIKey)
+	checkArg("DD_APP_KEY", ddAppKey)
+
+	f := fastlyFor(*serviceID)
+	ctx := context.Background()
+
+	active, err := f.activeVersion(ctx, *serviceID)
+	check(err)
+
+	current, err := f.datadogLogging(ctx, *serviceID, active, *loggingName)
+	check(err)
+
+	d := &datadogClient{site: datadogSite(current.Region), apiKey: ddAPIKey, appKey: ddAppKey, client: httpClient()}
+
+	old, err := d.apiKeyFor(ctx, current.Token)
+	check(err)
+
+	name := fmt.Sprintf("fastly-%s-%s-%s", *serviceID, *loggingName, time.Now().UTC().Format("20060102150405"))
+	created, err := d.createAPIKey(ctx, name)
+	check(err)
+	fmt.Printf("Created API key %s.\n", name)
+
+	number, err := withDraft(ctx, f, *serviceID, func(number int) error {
+		return f.updateDatadogToken(ctx, *serviceID, number, *loggingName, created.Attributes.Key)
+	})
+	if err != nil {
+		// Unless the draft got as far as activation, nothing uses the new
+		// key, so don't leave it lying around.
+		if maybeActivated(err) {
+			fmt.Fprintf(messages(), "Leaving new API key %s in place, as the version using it may be live.\n", name)
+		} else {
+			d.revokeAPIKey(ctx, created.ID)
+		}
+		check(err)
+	}
+	activatedAt := time.Now()
+	fmt.Printf("Activated version %d of service %s.\n", number, *serviceID)
+	recordRotation("datadog", *serviceID, *loggingName, active, number, current.Token, created.Attributes.Key)
+
+	fmt.Printf("Waiting up to %s for logs matching %q...\n", *verifyTimeout, *verifyQuery)
+	err = waitFor(ctx, *verifyTimeout, 30*time.Second, func() (bool, error) {
+		return d.hasLogs(ctx, *verifyQuery, activatedAt)
+	})
+	if err != nil {
+		check(fmt.Errorf("Unable to verify log intake, leaving old API key %s in place: %s", old.Attributes.Name, err.Error()))
+	}
+	fmt.Println("Log intake verified.")
+
+	if *keepOldKey {
+		fmt.Printf("Leaving old API key %s in place.\n", old.Attributes.Name)
+		return
+	}
+
+	check(d.revokeAPIKey(ctx, old.ID))
+	fmt.Printf("Revoked old API key %s.\n", old.Attributes.Name)
+}
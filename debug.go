@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// verbose is -verbose, which logs every Fastly API request and response.
+var verbose bool
+
+// debugSecretFields are redacted in -verbose logs as well as the secret
+// fields: access key IDs aren't secret, but are half of a credential, and
+// tokens are returned when they're created.
+var debugSecretFields = []string{"access_key", "access_token"}
+
+// maxDebugBody is how much of a response body -verbose logs.
+const maxDebugBody = 16 * 1024
+
+// logRequest logs a Fastly API request, with secrets redacted. The
+// Fastly-Key header is never logged.
+func logRequest(method string, reqURL url.URL, params url.Values) {
+	if !verbose {
+		return
+	}
+	redacted := redactDebugParams(reqURL.Path, params)
+	inQuery := reqURL.RawQuery != ""
+	if inQuery {
+		reqURL.RawQuery = redacted.Encode()
+	}
+	fmt.Fprintf(messages(), "> %s %s\n> Fastly-Key: ****\n", method, reqURL.String())
+	if !inQuery && len(params) > 0 {
+		fmt.Fprintf(messages(), "> %s\n", redacted.Encode())
+	}
+}
+
+// logResponse logs a Fastly API response, with secrets in JSON bodies
+// redacted.
+func logResponse(method, path string, statusCode int, body []byte, elapsed time.Duration, err error) {
+	if !verbose {
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(messages(), "< %s %s failed after %s: %s\n", method, path, elapsed.Round(time.Millisecond), err.Error())
+		return
+	}
+	fmt.Fprintf(messages(), "< %d (%s)\n", statusCode, elapsed.Round(time.Millisecond))
+	if len(body) > 0 {
+		fmt.Fprintf(messages(), "< %s\n", redactDebugBody(path, body))
+	}
+}
+
+func redactDebugParams(path string, params url.Values) url.Values {
+	redacted := redactParams(path, params)
+	for _, field := range debugSecretFields {
+		for i, v := range redacted[field] {
+			if v != "" {
+				redacted[field][i] = maskSecret(v)
+			}
+		}
+	}
+	return redacted
+}
+
+// redactDebugBody masks the secret fields anywhere in a JSON body. Bodies
+// that aren't JSON are logged as they are, as Fastly only returns secrets
+// in JSON.
+func redactDebugBody(path string, body []byte) string {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return truncateDebugBody(string(body))
+	}
+	data, err := json.Marshal(redactDebugValue(loggingTypeOf(path), v))
+	if err != nil {
+		return "(unable to redact body)"
+	}
+	return truncateDebugBody(string(data))
+}
+
+func redactDebugValue(typ string, v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, value := range v {
+			if s, ok := value.(string); ok && s != "" && (isSecretField(typ, k) || contains(debugSecretFields, k)) {
+				v[k] = maskSecret(s)
+			} else {
+				v[k] = redactDebugValue(typ, value)
+			}
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = redactDebugValue(typ, value)
+		}
+	}
+	return v
+}
+
+func truncateDebugBody(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) > maxDebugBody {
+		return s[:maxDebugBody] + fmt.Sprintf("... (%d bytes)", len(s))
+	}
+	return s
+}
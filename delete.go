@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// endpointRef names a logging configuration of a service.
+type endpointRef struct {
+	typ  string
+	name string
+	// destination is where it delivers to, for confirming deletions.
+	destination string
+}
+
+// MarshalJSON writes an endpointRef as [type, name, destination], for
+// -checkpoint.
+func (e endpointRef) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]string{e.typ, e.name, e.destination})
+}
+
+func (e *endpointRef) UnmarshalJSON(data []byte) error {
+	var fields []string
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	if len(fields) != 3 {
+		return fmt.Errorf("Invalid endpoint %s, expected [type, name, destination]", data)
+	}
+	e.typ, e.name, e.destination = fields[0], fields[1], fields[2]
+	return nil
+}
+
+// destinationFields are the fields of logging configurations that say where
+// they deliver to.
+var destinationFields = []string{"bucket_name", "container", "dataset", "table", "project_id", "account_name", "index", "domain", "path", "url", "address", "hostname", "port"}
+
+// deleteEndpoints deletes the logging configurations whose names match a
+// pattern, from one service or all of them, one new version per service.
+func deleteEndpoints(args []string) {
+	fs := flag.NewFlagSet("delete", flag.ExitOnError)
+	serviceID := fs.String("serviceID", "", "A Fastly Service ID.")
+	allServices := fs.Bool("allServices", false, "Delete from every service on the account, rather than just -serviceID.")
+	namePattern := fs.String("namePattern", "", "Names of the logging configurations to delete, as a glob such as 'tmp-*'.")
+	typ := fs.String("type", "", "Only delete logging configurations of this type, e.g. gcs (default: all).")
+	yes := fs.Bool("yes", false, "Delete without asking for confirmation.")
+	checkpointFlags(fs)
+	draftFlags(fs)
+	notifyFlags(fs)
+	commonFlags(fs)
+
+	fs.Usage = commandUsage(fs, "Note, FASTLY_KEY (or the tokens of each account in the config file) must be provided as env vars.")
+	parseFlags(fs, args)
+
+	checkArg("namePattern", *namePattern)
+	if _, err := path.Match(*namePattern, ""); err != nil {
+		check(fmt.Errorf("Invalid -namePattern: %s", err.Error()))
+	}
+	if !*allServices {
+		checkArg("serviceID", *serviceID)
+	} else if *serviceID != "" {
+		check(fmt.Errorf("Use one of -serviceID and -allServices"))
+	}
+
+	ctx := context.Background()
+
+	type deletion struct {
+		accountService
+		endpoints []endpointRef
+	}
+	cp := loadCheckpoint()
+	var deletions []deletion
+	total, done := 0, 0
+	failed := false
+	for _, s := range servicesFor(ctx, *serviceID) {
+		if s.svc.Version == 0 {
+			continue
+		}
+		var o outcome
+		if cp.result(s.svc.ID, &o) {
+			fmt.Fprintf(messages(), "%s (%s): %s (before resuming)\n", s.svc.Name, s.svc.ID, o.Detail)
+			failed = failed || o.Status == statusFailed
+			done++
+			continue
+		}
+
+		var endpoints []endpointRef
+		if !cp.result("endpoints:"+s.svc.ID, &endpoints) {
+			var err error
+			endpoints, err = s.f.matchingEndpoints(ctx, s.svc.ID, s.svc.Version, *typ, *namePattern)
+			cp.stopIfExhausted(err)
+			check(err)
+			cp.done("endpoints:"+s.svc.ID, endpoints)
+		}
+		if len(endpoints) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(messages(), "%s (%s), version %d:\n", s.svc.Name, s.svc.ID, s.svc.Version)
+		for _, e := range endpoints {
+			fmt.Fprintf(messages(), "  %s/%s\t%s\n", e.typ, e.name, e.destination)
+		}
+
+		deletions = append(deletions, deletion{s, endpoints})
+		total += len(endpoints)
+	}
+
+	if total == 0 {
+		cp.finish()
+		if done > 0 {
+			fmt.Fprintf(messages(), "No more logging configurations match '%s'.\n", *namePattern)
+		} else {
+			fmt.Fprintf(messages(), "No logging configurations match '%s'.\n", *namePattern)
+		}
+		if failed {
+			check(fmt.Errorf("Unable to delete from every service"))
+		}
+		return
+	}
+	if !*yes && !dryRun {
+		// Typing the service's name (or for several, how many) guards
+		// against confirming out of habit.
+		want := fmt.Sprint(len(deletions))
+		if len(deletions) == 1 {
+			want = deletions[0].svc.Name
+		}
+		question := fmt.Sprintf("This deletes %d logging configuration(s) from %d service(s) and activates the new version(s).", total, len(deletions))
+		if !confirmTyped(question, want) {
+			fmt.Fprintln(messages(), "Not deleting anything.")
+			return
+		}
+	}
+
+	for _, d := range deletions {
+		f, id := d.f, d.svc.ID
+		number, err := withDraft(ctx, f, id, func(number int) error {
+			for _, e := range d.endpoints {
+				if err := f.deleteLogging(ctx, id, number, e); err != nil {
+					return err
+				}
+			}
+			return f.setVersionComment(ctx, id, number, versionComment(fmt.Sprintf("Deleted logging matching '%s'", *namePattern)))
+		})
+		if errors.Is(err, errDryRun) {
+			// The calls it would make have been printed.
+			continue
+		}
+		if err != nil {
+			cp.stopIfExhausted(err)
+			o := outcome{ServiceID: id, Status: statusFailed, Detail: err.Error()}
+			cp.done(id, o)
+			notify(o)
+			fmt.Fprintf(messages(), "%s: %s\n", id, err.Error())
+			failed = true
+			continue
+		}
+
+		msg := fmt.Sprintf("Activated version %d of service %s, deleting %d logging configuration(s).", number, id, len(d.endpoints))
+		o := outcome{ServiceID: id, Status: statusRotated, Detail: msg}
+		cp.done(id, o)
+		notify(o)
+		fmt.Fprintln(messages(), msg)
+	}
+	if dryRun {
+		fmt.Fprintf(messages(), "Would delete %d logging configuration(s) from %d service(s).\n", total, len(deletions))
+	}
+	flushNotifications()
+	cp.finish()
+
+	if failed {
+		check(fmt.Errorf("Unable to delete from every service"))
+	}
+}
+
+// matchingEndpoints finds the logging configurations of a version (of one
+// type, or any if typ is blank) whose names match a glob.
+func (f *fastlyClient) matchingEndpoints(ctx context.Context, serviceID string, version int, typ, pattern string) ([]endpointRef, error) {
+	var matches []endpointRef
+	for _, t := range loggingTypes {
+		if typ != "" && t != typ {
+			continue
+		}
+		list := f.listableLoggings
+		if typ != "" {
+			list = f.loggings
+		}
+		loggings, err := list(ctx, serviceID, version, t)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range loggings {
+			name := fmt.Sprint(l["name"])
+			if ok, _ := path.Match(pattern, name); ok {
+				matches = append(matches, endpointRef{typ: t, name: name, destination: destination(t, l)})
+			}
+		}
+	}
+	return matches, nil
+}
+
+func (f *fastlyClient) deleteLogging(ctx context.Context, serviceID string, version int, e endpointRef) error {
+	p, err := providerFor(e.typ)
+	if err != nil {
+		return err
+	}
+	return p.delete(ctx, f, serviceID, version, e.name)
+}
+
+// destination describes where a logging configuration delivers to, e.g.
+// bucket_name=logs path=/fastly/. Fields that are secret for the type, such
+// as a Sumo Logic collector URL, are masked.
+func destination(typ string, l map[string]interface{}) string {
+	var parts []string
+	for _, field := range destinationFields {
+		v, ok := l[field]
+		if !ok || v == nil || fmt.Sprint(v) == "" {
+			continue
+		}
+		value := fmt.Sprint(v)
+		if isSecretField(typ, field) {
+			value = maskSecret(value)
+		}
+		parts = append(parts, field+"="+value)
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var errTimeout = errors.New("Timed out")
+
+// waitFor polls done every interval until it reports true, fails, or timeout
+// elapses.
+func waitFor(ctx context.Context, timeout, interval time.Duration, done func() (bool, error)) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		ok, err := done()
+		if err != nil || ok {
+			return err
+		}
+
+		if time.Now().Add(interval).After(deadline) {
+			return errTimeout
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// maxPollPages is how many pages of objects (of up to 1000 each) a poll for
+// delivered log files lists under a prefix before giving up on it, rather
+// than listing a prefix that holds much more than a day's logs every poll.
+const maxPollPages = 5
+
+// deliveryPrefix is the object prefix logs delivered at t are written under.
+// It is blank for a path with no directory, whose log files can't be listed
+// without listing the whole bucket.
+func deliveryPrefix(path string, t time.Time) string {
+	prefix := strings.TrimPrefix(strftime(path, t.UTC()), "/")
+	return prefix[:strings.LastIndex(prefix, "/")+1]
+}
+
+// checkDeliveryPrefix refuses a logging configuration whose path has no
+// directory, as its log files can't be polled for without listing the whole
+// bucket.
+func checkDeliveryPrefix(l s3Logging) error {
+	if deliveryPrefix(l.Path, time.Now()) == "" {
+		return fmt.Errorf("The path of %s (%q) has no directory to list log files under, and the whole bucket won't be listed every poll", l.Name, l.Path)
+	}
+	return nil
+}
+
+// loggingPeriod is how often a logging configuration writes a log file.
+func loggingPeriod(period int) time.Duration {
+	if period <= 0 {
+		period = 3600
+	}
+	return time.Duration(period) * time.Second
+}
+
+// deliveryTimeout is how long to wait for the first log file after a change:
+// a full logging period, plus some slack for Fastly to deliver it.
+func deliveryTimeout(period int) time.Duration {
+	return loggingPeriod(period) + 10*time.Minute
+}
+
+// keyDeliveryTimeout is how long to wait for a log file delivered with a new
+// key, which waitForS3Delivery only takes from a logging period after it was
+// activated.
+func keyDeliveryTimeout(period int) time.Duration {
+	return loggingPeriod(period) + deliveryTimeout(period)
+}
+
+// waitForS3Delivery waits for a log file delivered with the configuration
+// activated at activatedAt, returning its key. Files modified within a
+// logging period of activation may still have been written by POPs on the
+// previous version, so only later ones count.
+func waitForS3Delivery(ctx context.Context, a *awsClient, l s3Logging, activatedAt time.Time, timeout time.Duration) (string, error) {
+	if err := checkDeliveryPrefix(l); err != nil {
+		return "", err
+	}
+
+	since := activatedAt.Add(loggingPeriod(int(l.Period)))
+	var delivered string
+	err := waitFor(ctx, timeout, 30*time.Second, func() (bool, error) {
+		objects, err := a.listObjects(ctx, l.Domain, l.BucketName, deliveryPrefix(l.Path, time.Now()), maxPollPages)
+		if err != nil {
+			return false, err
+		}
+		for _, o := range objects {
+			if o.LastModified.After(since) {
+				delivered = o.Key
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	return delivered, err
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// describe prints the logging configuration of a service.
+func describe(args []string) {
+	fs := flag.NewFlagSet("describe", flag.ExitOnError)
+	serviceID := fs.String("serviceID", "", "A Fastly Service ID.")
+	version := fs.Int("version", 0, "Version to describe (default: the active version).")
+	typ := fs.String("type", "", "Only describe logging configurations of this type, e.g. gcs (default: all).")
+	fields := fs.String("fields", "", "Comma-separated fields to include for each logging configuration, e.g. name,bucket_name,path,access_key (default: all).")
+	schema := schemaFlag(fs)
+	outputFlag(fs, "json")
+	commonFlags(fs)
+
+	fs.Usage = commandUsage(fs, "Note, FASTLY_KEY must be provided as an env var.")
+	parseFlags(fs, args)
+
+	if *schema {
+		printSchema("describe")
+		return
+	}
+
+	checkArg("serviceID", *serviceID)
+
+	f := fastlyFor(*serviceID)
+	ctx := context.Background()
+
+	number := *version
+	if number == 0 {
+		active, err := f.activeVersion(ctx, *serviceID)
+		check(err)
+		number = active
+	}
+
+	config, err := f.loggingConfig(ctx, *serviceID, number)
+	check(err)
+
+	if *typ != "" {
+		for t := range config {
+			if t != *typ {
+				delete(config, t)
+			}
+		}
+	}
+
+	if selected := parseFields(*fields); len(selected) > 0 {
+		for _, loggings := range config {
+			for i, l := range loggings {
+				loggings[i] = selectFields(l, selected)
+			}
+		}
+	}
+
+	if printStructured(config) {
+		return
+	}
+	printLoggingTable(config, parseFields(*fields))
+}
+
+// printLoggingTable prints a row for each logging configuration, with the
+// given fields, or those most logging types have.
+func printLoggingTable(config map[string][]map[string]interface{}, fields []string) {
+	if len(fields) == 0 {
+		fields = []string{"format_version", "period", "placement", "response_condition"}
+	}
+	var columns []string
+	for _, field := range fields {
+		if field != "name" {
+			columns = append(columns, field)
+		}
+	}
+
+	var types []string
+	for t := range config {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "TYPE\tNAME\t%s\t\n", strings.ToUpper(strings.Join(columns, "\t")))
+	for _, t := range types {
+		for _, l := range config[t] {
+			row := []string{t, orDash(fmt.Sprint(valueOrEmpty(l["name"])))}
+			for _, field := range columns {
+				row = append(row, orDash(fmt.Sprint(valueOrEmpty(l[field]))))
+			}
+			fmt.Fprintln(w, strings.Join(row, "\t")+"\t")
+		}
+	}
+	w.Flush()
+}
+
+func valueOrEmpty(v interface{}) interface{} {
+	if v == nil {
+		return ""
+	}
+	return v
+}
+
+func parseFields(s string) []string {
+	var fields []string
+	for _, field := range strings.Split(s, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// selectFields keeps only the given fields of m.
+func selectFields(m map[string]interface{}, fields []string) map[string]interface{} {
+	selected := map[string]interface{}{}
+	for _, field := range fields {
+		if v, ok := m[field]; ok {
+			selected[field] = v
+		}
+	}
+	return selected
+}
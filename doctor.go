@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+type diagnosis struct {
+	problem string
+	fix     string
+}
+
+// doctor reports likely misconfigurations in a service's S3 logging, with
+// suggested fixes.
+func doctor(args []string) {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	serviceID := fs.String("serviceID", "", "A Fastly Service ID.")
+	maxKeyAge := fs.Int("maxKeyAge", 90, "Maximum age in days of an access key before it is due for rotation.")
+	commonFlags(fs)
+
+	fs.Usage = commandUsage(fs, "Note, FASTLY_KEY must be provided as an env var. Key ages are checked with IAM when AWS credentials with IAM read access are available in the standard AWS env vars, or else from the state file of past rotations (FLC_STATE_FILE, decrypted with FLC_STATE_KEY if set).")
+	parseFlags(fs, args)
+
+	checkArg("serviceID", *serviceID)
+
+	f := fastlyFor(*serviceID)
+	ctx := context.Background()
+
+	active, err := f.activeVersion(ctx, *serviceID)
+	check(err)
+
+	loggings, err := f.s3Loggings(ctx, *serviceID, active)
+	check(err)
+
+	var iam *awsClient
+	if creds := awsEnvCredentials(); creds.accessKey != "" {
+		iam = newAWSClient(creds, "")
+	}
+
+	state, err := loadState()
+	check(err)
+
+	problems := 0
+	for _, l := range loggings {
+		diagnoses := diagnose(ctx, *serviceID, l, iam, state, time.Duration(*maxKeyAge)*24*time.Hour)
+		if len(diagnoses) == 0 {
+			fmt.Printf("%s: ok\n", l.Name)
+			continue
+		}
+
+		fmt.Printf("%s:\n", l.Name)
+		for _, d := range diagnoses {
+			fmt.Printf("  - %s\n    Fix: %s\n", d.problem, d.fix)
+		}
+		problems += len(diagnoses)
+	}
+
+	fmt.Printf("Checked %d S3 logging configuration(s) on version %d of service %s, found %d problem(s).\n", len(loggings), active, *serviceID, problems)
+	if problems > 0 {
+		os.Exit(1)
+	}
+}
+
+func diagnose(ctx context.Context, serviceID string, l s3Logging, iam *awsClient, state rotationState, maxKeyAge time.Duration) []diagnosis {
+	var ds []diagnosis
+	add := func(problem, fix string) {
+		ds = append(ds, diagnosis{problem, fix})
+	}
+
+	if l.Path != "" && !strings.HasSuffix(l.Path, "/") {
+		add(fmt.Sprintf("path %q has no trailing slash, so it prefixes object names rather than naming a folder.", l.Path),
+			fmt.Sprintf("set path to %q.", l.Path+"/"))
+	}
+
+	if l.GzipLevel == 0 && l.CompressionCodec == "" {
+		add("log files are uncompressed.", "set compression_codec to gzip (or zstd).")
+	}
+
+	if l.FormatVersion == 1 {
+		add("format_version is 1, which is deprecated.", "set format_version to 2, updating the format string to use req.-prefixed variables.")
+	}
+
+	exists, _, err := headBucket(ctx, l.Domain, l.BucketName)
+	if err != nil {
+		add(fmt.Sprintf("unable to check bucket %q: %s", l.BucketName, err.Error()), "check the bucket_name and domain are correct.")
+	} else if !exists {
+		add(fmt.Sprintf("bucket %q does not exist.", l.BucketName), "recreate the bucket or point the endpoint at an existing one.")
+	}
+
+	if l.IAMRole == "" && l.AccessKey != "" {
+		created, err := keyCreated(ctx, serviceID, l, iam, state)
+		if err != nil {
+			add(fmt.Sprintf("unable to tell the age of access key %s: %s", l.AccessKey, err.Error()), "check the key still exists in IAM, or rotate it with rotate-creds to start tracking its age.")
+		} else if age := time.Since(created); age > maxKeyAge {
+			add(fmt.Sprintf("access key %s is %d days old.", l.AccessKey, int(age.Hours()/24)), "rotate it with rotate-creds.")
+		}
+	}
+
+	return ds
+}
+
+// keyCreated asks IAM when an access key was created, falling back on when
+// this tool last put it in place if IAM can't (or can't be) asked.
+func keyCreated(ctx context.Context, serviceID string, l s3Logging, iam *awsClient, state rotationState) (time.Time, error) {
+	err := errors.New("no AWS credentials in the environment to ask IAM")
+	if iam != nil {
+		var created time.Time
+		if created, err = iam.accessKeyCreated(ctx, l.AccessKey); err == nil {
+			return created, nil
+		}
+	}
+
+	r, ok := state.Rotations[stateKey("s3", serviceID, l.Name)]
+	if ok && r.KeyFingerprint == fingerprint(l.AccessKey) {
+		return r.RotatedAt, nil
+	}
+	return time.Time{}, err
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Fastly has no API for deleting a service version, so a draft that is
+// abandoned part way through a run is marked as discarded and locked instead.
+const discardedComment = "Discarded by fastly-logging-creds: %s"
+
+// draft is a version cloned by this run that has not yet been activated.
+type draft struct {
+	mu        sync.Mutex
+	f         *fastlyClient
+	serviceID string
+	number    int
+	keep      bool // leave drafts in place for inspection rather than discarding them
+}
+
+var (
+	pending        draft
+	interruptsOnce sync.Once
+
+	approvedBy     string
+	operatorName   string
+	skipWriteCheck bool
+	operatorOnce   sync.Once
+)
+
+// draftFlags adds the flags of commands that clone and activate versions.
+func draftFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&pending.keep, "keepDraft", false, "Leave the cloned version in place if the run fails, for inspection.")
+	fs.StringVar(&approvedBy, "approvedBy", "", "Who approved the change, recorded as the operator (default: the Fastly token's owner, or $USER).")
+	fs.StringVar(&policy, "policy", "", "Rego policy file (evaluated with the opa CLI) or OPA server URL that must allow the new version before it is activated.")
+	fs.StringVar(&policyQuery, "policyQuery", "data.fastly_logging.deny", "Query for the set of policy violations, when -policy is a file.")
+	fs.BoolVar(&overridePolicy, "override", false, "Make the change even if the config file's rotation_policy forbids it, e.g. outside its window.")
+	fs.BoolVar(&skipWriteCheck, "skipWriteCheck", false, "Don't check that S3 logging configurations with new credentials (or buckets) can write to their buckets, before changing Fastly or activating.")
+	fs.Var(&preActivateHooks, "preActivate", "Command or webhook URL to run before activating, given the operation as JSON. Can be repeated.")
+	fs.Var(&postActivateHooks, "postActivate", "Command or webhook URL to run after activating, given the operation as JSON. Can be repeated.")
+}
+
+func (d *draft) set(f *fastlyClient, serviceID string, number int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.f, d.serviceID, d.number = f, serviceID, number
+}
+
+func (d *draft) clear() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.number = 0
+}
+
+// discard marks the pending draft (if any) as discarded and locks it.
+func (d *draft) discard(reason string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.number == 0 {
+		return
+	}
+
+	if d.keep {
+		fmt.Fprintf(messages(), "Leaving draft version %d of service %s in place.\n", d.number, d.serviceID)
+		d.number = 0
+		return
+	}
+
+	ctx := withoutBudget(context.Background())
+	err := d.f.setVersionComment(ctx, d.serviceID, d.number, fmt.Sprintf(discardedComment, reason))
+	if err == nil {
+		err = d.f.lockVersion(ctx, d.serviceID, d.number)
+	}
+
+	if err != nil {
+		fmt.Fprintf(messages(), "Unable to discard draft version %d of service %s: %s\n", d.number, d.serviceID, err.Error())
+	} else {
+		fmt.Fprintf(messages(), "Discarded draft version %d of service %s.\n", d.number, d.serviceID)
+	}
+	d.number = 0
+}
+
+// activationError is a failed request to activate a draft, which may have
+// been activated regardless, e.g. if only the response was lost.
+type activationError struct {
+	err error
+}
+
+func (e activationError) Error() string { return e.err.Error() }
+func (e activationError) Unwrap() error { return e.err }
+
+// maybeActivated is whether the draft that withDraft returned err for may be
+// live, so the credentials it uses mustn't be cleaned up.
+func maybeActivated(err error) bool {
+	return errors.As(err, new(activationError))
+}
+
+// withDraft clones the active version of a service and applies change to the
+// clone, then validates and activates it. If change or validation fails, the
+// clone is discarded (unless pending.keep is set) so that reruns start from a
+// clean version history. Once the clone is activated, it returns no error; if
+// activating it fails, the error is an activationError.
+func withDraft(ctx context.Context, f *fastlyClient, serviceID string, change func(number int) error) (int, error) {
+	active, err := f.activeVersion(ctx, serviceID)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := checkRotationPolicy(time.Now()); err != nil {
+		return 0, err
+	}
+
+	if dryRun {
+		return dryRunDraft(ctx, f, serviceID, active, change)
+	}
+
+	handleInterrupts()
+
+	number, err := f.cloneVersion(ctx, serviceID, active)
+	if err != nil {
+		return 0, err
+	}
+	pending.set(f, serviceID, number)
+
+	// Once cloned, validate and activate (or clean up) regardless of
+	// -maxApiCalls: a change that exhausts the budget is discarded instead.
+	ctx = withoutBudget(ctx)
+
+	hc := hookContext{Command: commandName, ServiceID: serviceID, ActiveVersion: active, Version: number, Operator: operator(ctx, f)}
+
+	err = f.setVersionComment(ctx, serviceID, number, versionComment(commandName))
+	if err == nil {
+		err = change(number)
+	}
+	if err == nil {
+		err = f.validateVersion(ctx, serviceID, number)
+	}
+	if err == nil && !skipWriteCheck {
+		err = checkDraftWrites(ctx, f, serviceID, active, number)
+	}
+	if err == nil {
+		err = checkPolicy(ctx, f, hc)
+	}
+	if err == nil {
+		hc.Phase = "pre-activate"
+		err = runHooks(ctx, preActivateHooks, hc)
+	}
+	if err != nil {
+		pending.discard("run failed")
+		return 0, err
+	}
+
+	// Once activation has been requested the draft is no longer ours to discard.
+	pending.clear()
+	if err := f.activateVersion(ctx, serviceID, number); err != nil {
+		return 0, activationError{fmt.Errorf("Unable to activate version %d of service %s, which may be live: %s", number, serviceID, err.Error())}
+	}
+
+	// The version is live now, so a failing post-activate hook is only
+	// warned about: callers take any error to mean nothing was activated,
+	// and would discard the credentials the version uses.
+	hc.Phase = "post-activate"
+	if err := runHooks(ctx, postActivateHooks, hc); err != nil {
+		fmt.Fprintf(messages(), "Warning: activated version %d of service %s, but %s\n", number, serviceID, err.Error())
+	}
+	return number, nil
+}
+
+// dryRunDraft prints the calls withDraft would make, with change made to
+// what would be the new version. Nothing is cloned, so nothing can be read
+// back to validate or check.
+func dryRunDraft(ctx context.Context, f *fastlyClient, serviceID string, active int, change func(number int) error) (int, error) {
+	versions, err := f.versions(ctx, serviceID)
+	if err != nil {
+		return 0, err
+	}
+	number := 0
+	for _, v := range versions {
+		if v.Number > number {
+			number = v.Number
+		}
+	}
+	number++
+
+	operator(ctx, f)
+
+	fmt.Fprintf(messages(), "Would clone version %d of service %s as version %d:\n", active, serviceID, number)
+	err = f.do(ctx, http.MethodPut, fmt.Sprintf("/service/%s/version/%d/clone", serviceID, active), nil, nil)
+	if err == nil {
+		err = f.setVersionComment(ctx, serviceID, number, versionComment(commandName))
+	}
+	if err == nil {
+		err = change(number)
+	}
+	if err == nil {
+		err = f.activateVersion(ctx, serviceID, number)
+	}
+	if err == nil {
+		err = errDryRun
+	}
+	return 0, err
+}
+
+// checkDraftWrites checks that every S3 logging configuration a draft adds or
+// changes the credentials or destination of can write to its bucket, so that
+// a broken configuration is never activated. Configurations delivering as an
+// IAM role are skipped, as only Fastly can assume the role.
+func checkDraftWrites(ctx context.Context, f *fastlyClient, serviceID string, active, number int) error {
+	before, err := f.s3Loggings(ctx, serviceID, active)
+	if err != nil {
+		return err
+	}
+	after, err := f.s3Loggings(ctx, serviceID, number)
+	if err != nil {
+		return err
+	}
+
+	unchanged := map[s3Logging]bool{}
+	for _, l := range before {
+		unchanged[s3Destination(l)] = true
+	}
+	for _, l := range after {
+		if unchanged[s3Destination(l)] || l.IAMRole != "" {
+			continue
+		}
+		object, err := checkWriteAccess(ctx, l, awsCredentials{accessKey: l.AccessKey, secretKey: l.SecretKey})
+		if err != nil {
+			return fmt.Errorf("%s: %s", l.Name, err.Error())
+		}
+		fmt.Fprintf(messages(), "Write check passed: %s\n", object)
+	}
+	return nil
+}
+
+// s3Destination is the part of an S3 logging configuration that decides
+// whether it can write.
+func s3Destination(l s3Logging) s3Logging {
+	return s3Logging{
+		Name:        l.Name,
+		BucketName:  l.BucketName,
+		Domain:      l.Domain,
+		Path:        l.Path,
+		AccessKey:   l.AccessKey,
+		SecretKey:   l.SecretKey,
+		ACL:         l.ACL,
+		SSE:         l.SSE,
+		SSEKMSKeyID: l.SSEKMSKeyID,
+	}
+}
+
+// operator identifies who is making a change: whoever -approvedBy names, or
+// the owner of the Fastly token, or the local user.
+func operator(ctx context.Context, f *fastlyClient) string {
+	operatorOnce.Do(func() {
+		if approvedBy != "" {
+			operatorName = approvedBy
+		} else if u, err := f.currentUser(ctx); err == nil && u.Login != "" {
+			operatorName = u.Login
+		} else if name := os.Getenv("USER"); name != "" {
+			operatorName = name
+		} else {
+			operatorName = os.Getenv("USERNAME")
+		}
+	})
+	return operatorName
+}
+
+// versionComment describes a change for the comment of the version it is
+// made in, crediting the operator.
+func versionComment(change string) string {
+	return fmt.Sprintf("%s by %s (fastly-logging-creds)", change, operatorName)
+}
+
+// handleInterrupts discards the pending draft before exiting on Ctrl-C or
+// SIGTERM, so that interrupted runs don't leave unexplained drafts behind.
+func handleInterrupts() {
+	interruptsOnce.Do(func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+
+		go func() {
+			sig := <-c
+			pending.discard(fmt.Sprintf("interrupted (%s)", sig))
+			os.Exit(130)
+		}()
+	})
+}
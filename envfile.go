@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// envFileFlag is -envFile, a .env file of secrets (as NAME=value lines) to use
+// in place of env vars, loaded as soon as it's given.
+type envFileFlag struct{}
+
+var envFileSecrets map[string]string
+
+func (envFileFlag) String() string {
+	return ""
+}
+
+func (envFileFlag) Set(file string) error {
+	secrets, err := loadEnvFile(file)
+	if err != nil {
+		return err
+	}
+	envFileSecrets = secrets
+	return nil
+}
+
+// loadEnvFile reads a .env file, refusing one that other users can read or
+// change, as it holds secrets.
+func loadEnvFile(file string) (map[string]string, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	if perm := info.Mode().Perm(); perm&0007 != 0 && runtime.GOOS != "windows" {
+		return nil, fmt.Errorf("%s is accessible by other users (mode %04o), make it private with chmod 600", file, perm)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	secrets := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimPrefix(line, "export "), "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("%s:%d: expected NAME=value", file, n)
+		}
+
+		value := strings.TrimSpace(parts[1])
+		switch {
+		case len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"':
+			if value, err = strconv.Unquote(value); err != nil {
+				return nil, fmt.Errorf("%s:%d: %s", file, n, err.Error())
+			}
+		case len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'':
+			value = value[1 : len(value)-1]
+		default:
+			// An unquoted value can end in a comment.
+			if i := strings.Index(value, " #"); i >= 0 {
+				value = strings.TrimSpace(value[:i])
+			}
+		}
+		secrets[strings.TrimSpace(parts[0])] = value
+	}
+	return secrets, scanner.Err()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func writeEnvFile(t *testing.T, contents string, mode os.FileMode) string {
+	dir, err := ioutil.TempDir("", "envfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	file := filepath.Join(dir, ".env")
+	if err := ioutil.WriteFile(file, []byte(contents), mode); err != nil {
+		t.Fatal(err)
+	}
+	// WriteFile's mode is subject to the umask.
+	if err := os.Chmod(file, mode); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestLoadEnvFile(t *testing.T) {
+	file := writeEnvFile(t, `# Fastly
+export FASTLY_KEY=abc # the token
+
+AWS_SECRET_KEY = ab#c
+VAULT_TOKEN="a b\tc # d"
+FLC_BUNDLE_KEY='a b\t'
+FLC_EMPTY=
+`, 0600)
+
+	got, err := loadEnvFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"FASTLY_KEY":     "abc",
+		"AWS_SECRET_KEY": "ab#c",
+		"VAULT_TOKEN":    "a b\tc # d",
+		"FLC_BUNDLE_KEY": `a b\t`,
+		"FLC_EMPTY":      "",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("loadEnvFile() = %v, want %v", got, want)
+	}
+
+	for _, contents := range []string{"FASTLY_KEY\n", "=abc\n", `FASTLY_KEY="a\q"` + "\n"} {
+		if _, err := loadEnvFile(writeEnvFile(t, contents, 0600)); err == nil {
+			t.Errorf("loadEnvFile() of %q succeeded, want an error", contents)
+		}
+	}
+}
+
+func TestLoadEnvFileReadableByOthers(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes aren't checked on Windows")
+	}
+	if _, err := loadEnvFile(writeEnvFile(t, "FASTLY_KEY=abc\n", 0644)); err == nil {
+		t.Error("loadEnvFile() of a file with mode 0644 succeeded, want an error")
+	}
+}
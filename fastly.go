@@ -0,0 +1,419 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// https://developer.fastly.com/reference/api/
+const fastlyAPIHost = "api.fastly.com"
+
+// errBudgetExhausted stops a run that has made -maxApiCalls calls.
+var errBudgetExhausted = errors.New("API call budget (-maxApiCalls) exhausted")
+
+// errDryRun stops a -dryRun at the first change that can't be printed and
+// then carried on past, either because its response is needed or because it
+// isn't to the draft version.
+var errDryRun = errors.New("Dry run, no changes made")
+
+// dryRun is -dryRun: Fastly API calls that would change anything are printed
+// instead of made.
+var dryRun bool
+
+var (
+	maxAPICalls int
+	apiCalls    int
+	apiCallsMu  sync.Mutex
+)
+
+type budgetExemptKey struct{}
+
+// withoutBudget exempts calls from -maxApiCalls, for finishing (or cleaning
+// up) work that it would be worse to leave half done.
+func withoutBudget(ctx context.Context) context.Context {
+	return context.WithValue(ctx, budgetExemptKey{}, true)
+}
+
+// spendAPICall counts a call against the -maxApiCalls budget.
+func spendAPICall(ctx context.Context) error {
+	apiCallsMu.Lock()
+	defer apiCallsMu.Unlock()
+
+	if maxAPICalls > 0 && apiCalls >= maxAPICalls && ctx.Value(budgetExemptKey{}) == nil {
+		return errBudgetExhausted
+	}
+	apiCalls++
+	return nil
+}
+
+// budgetSpent tells whether -maxApiCalls calls have been made.
+func budgetSpent() bool {
+	apiCallsMu.Lock()
+	defer apiCallsMu.Unlock()
+	return maxAPICalls > 0 && apiCalls >= maxAPICalls
+}
+
+type fastlyClient struct {
+	key    string
+	client *http.Client
+}
+
+func newFastlyClient(key string) *fastlyClient {
+	return &fastlyClient{key: key, client: httpClient()}
+}
+
+// fastlyAttempts is how many times requests are tried, to ride out rate
+// limiting and transient errors.
+const fastlyAttempts = 3
+
+// errAmbiguous is returned (wrapped) when a request that isn't safe to retry
+// failed in a way that leaves unclear whether Fastly acted on it.
+var errAmbiguous = errors.New("the request may or may not have succeeded")
+
+type fastlyError struct {
+	method, path string
+	statusCode   int
+	body         string
+}
+
+func (e *fastlyError) Error() string {
+	return fmt.Sprintf("%s %s failed: %d, %s", e.method, e.path, e.statusCode, e.body)
+}
+
+// isFastlyStatus reports whether err is a Fastly API error response with the
+// given HTTP status code.
+func isFastlyStatus(err error, statusCode int) bool {
+	e, ok := err.(*fastlyError)
+	return ok && e.statusCode == statusCode
+}
+
+// idempotent tells whether a request can be repeated without changing the
+// outcome. Updates are made with PUT, but cloning a version with PUT creates
+// a new version each time.
+func idempotent(method, path string) bool {
+	switch method {
+	case http.MethodGet, http.MethodDelete:
+		return true
+	case http.MethodPut:
+		return !strings.HasSuffix(path, "/clone")
+	}
+	return false
+}
+
+// do calls the Fastly API and decodes the JSON response into out (if
+// non-nil). params are sent as the query string for GET and DELETE requests
+// and as a form-encoded body otherwise.
+//
+// Rate limited requests are always retried, as Fastly hasn't acted on them.
+// Other failures are only retried for idempotent requests, and otherwise
+// wrap errAmbiguous so the caller can check what happened.
+func (f *fastlyClient) do(ctx context.Context, method, path string, params url.Values, out interface{}) error {
+	if dryRun && method != http.MethodGet {
+		fmt.Fprintf(messages(), "Would call %s %s %s\n", method, path, redactParams(path, params).Encode())
+		if out != nil {
+			return fmt.Errorf("%s %s: %w", method, path, errDryRun)
+		}
+		return nil
+	}
+
+	var statusCode int
+	var respBody []byte
+	var err error
+
+	for attempt := 1; attempt <= fastlyAttempts; attempt++ {
+		if err := spendAPICall(ctx); err != nil {
+			return err
+		}
+
+		start := time.Now()
+		statusCode, respBody, err = f.send(ctx, method, path, params)
+		recordCall(method+" "+endpointTemplate(path), time.Since(start), attempt > 1)
+
+		retryable := statusCode == http.StatusTooManyRequests || (err != nil || statusCode >= 500) && idempotent(method, path)
+		if !retryable || attempt == fastlyAttempts || ctx.Err() != nil {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Duration(attempt) * time.Second):
+		}
+	}
+
+	if err != nil {
+		if !idempotent(method, path) && ctx.Err() == nil {
+			return fmt.Errorf("%s %s failed: %w: %s", method, path, errAmbiguous, err.Error())
+		}
+		return err
+	}
+
+	if statusCode >= 500 && !idempotent(method, path) {
+		return fmt.Errorf("%s %s failed: %w: %d, %s", method, path, errAmbiguous, statusCode, string(respBody))
+	}
+
+	if statusCode != http.StatusOK {
+		return &fastlyError{method: method, path: path, statusCode: statusCode, body: string(respBody)}
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(respBody, out)
+}
+
+// redactParams masks the secrets in the parameters of a call to path, for
+// printing.
+func redactParams(path string, params url.Values) url.Values {
+	typ := loggingTypeOf(path)
+
+	redacted := url.Values{}
+	for field, values := range params {
+		for _, v := range values {
+			if v != "" && isSecretField(typ, field) {
+				v = maskSecret(v)
+			}
+			redacted.Add(field, v)
+		}
+	}
+	return redacted
+}
+
+// loggingTypeOf is the logging type of a logging API path, e.g. s3, or "".
+func loggingTypeOf(path string) string {
+	if parts := strings.Split(path, "/"); len(parts) > 6 && parts[5] == "logging" {
+		return parts[6]
+	}
+	return ""
+}
+
+// send makes a single request to the Fastly API.
+func (f *fastlyClient) send(ctx context.Context, method, path string, params url.Values) (int, []byte, error) {
+	reqURL := url.URL{Scheme: "https", Host: fastlyAPIHost, Path: path}
+
+	var body io.Reader
+	if method == http.MethodGet || method == http.MethodDelete {
+		reqURL.RawQuery = params.Encode()
+	} else if params != nil {
+		body = strings.NewReader(params.Encode())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, reqURL.String(), body)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	req.Header.Add("Fastly-Key", f.key)
+	req.Header.Add("Accept", "application/json")
+	if body != nil {
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	logRequest(method, reqURL, params)
+	start := time.Now()
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		logResponse(method, path, 0, nil, time.Since(start), err)
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	logResponse(method, path, resp.StatusCode, respBody, time.Since(start), err)
+	return resp.StatusCode, respBody, err
+}
+
+// https://developer.fastly.com/reference/api/services/version/
+type version struct {
+	Number  int    `json:"number"`
+	Active  bool   `json:"active"`
+	Locked  bool   `json:"locked"`
+	Comment string `json:"comment"`
+}
+
+func (f *fastlyClient) versions(ctx context.Context, serviceID string) ([]version, error) {
+	var versions []version
+	err := f.do(ctx, http.MethodGet, fmt.Sprintf("/service/%s/version", serviceID), nil, &versions)
+	return versions, err
+}
+
+func (f *fastlyClient) activeVersion(ctx context.Context, serviceID string) (int, error) {
+	versions, err := f.versions(ctx, serviceID)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, v := range versions {
+		if v.Active {
+			return v.Number, nil
+		}
+	}
+
+	return 0, fmt.Errorf("Service %s has no active version", serviceID)
+}
+
+// cloneVersion clones a version of a service. If it is unclear whether the
+// clone was made, the versions are checked for it rather than cloning again.
+func (f *fastlyClient) cloneVersion(ctx context.Context, serviceID string, number int) (int, error) {
+	before, err := f.versions(ctx, serviceID)
+	if err != nil {
+		return 0, err
+	}
+
+	var cloned version
+	err = f.do(ctx, http.MethodPut, fmt.Sprintf("/service/%s/version/%d/clone", serviceID, number), nil, &cloned)
+	if errors.Is(err, errAmbiguous) {
+		return f.findClone(ctx, serviceID, number, before, err)
+	}
+	return cloned.Number, err
+}
+
+// findClone looks for a version made by a clone of version source that
+// failed with err. It must be the one version created since the versions
+// before it, a draft with the comment cloned from source (or ours, or
+// none): anything else may be someone else's draft, so is never adopted.
+func (f *fastlyClient) findClone(ctx context.Context, serviceID string, source int, before []version, err error) (int, error) {
+	latest, sourceComment := 0, ""
+	for _, v := range before {
+		if v.Number > latest {
+			latest = v.Number
+		}
+		if v.Number == source {
+			sourceComment = v.Comment
+		}
+	}
+
+	after, verr := f.versions(ctx, serviceID)
+	if verr != nil {
+		return 0, err
+	}
+
+	var created []version
+	for _, v := range after {
+		if v.Number > latest {
+			created = append(created, v)
+		}
+	}
+
+	switch len(created) {
+	case 0:
+		return 0, fmt.Errorf("%s (no version was created)", err.Error())
+	case 1:
+		v := created[0]
+		ours := v.Comment == "" || v.Comment == sourceComment || v.Comment == versionComment(commandName)
+		if v.Active || v.Locked || !ours {
+			return 0, fmt.Errorf("%s (version %d was created since, but may not be ours, so not using it)", err.Error(), v.Number)
+		}
+		fmt.Fprintf(messages(), "Clone of service %s reported an error but created version %d; using it.\n", serviceID, v.Number)
+		return v.Number, nil
+	}
+	return 0, fmt.Errorf("%s (%d versions were created since, so unable to tell which is ours)", err.Error(), len(created))
+}
+
+func (f *fastlyClient) validateVersion(ctx context.Context, serviceID string, number int) error {
+	var result struct {
+		Status string   `json:"status"`
+		Msg    string   `json:"msg"`
+		Errors []string `json:"errors"`
+	}
+
+	err := f.do(ctx, http.MethodGet, fmt.Sprintf("/service/%s/version/%d/validate", serviceID, number), nil, &result)
+	if err != nil {
+		return err
+	}
+
+	if result.Status != "ok" {
+		return fmt.Errorf("Version %d failed validation: %s %s", number, result.Msg, strings.Join(result.Errors, "; "))
+	}
+	return nil
+}
+
+func (f *fastlyClient) activateVersion(ctx context.Context, serviceID string, number int) error {
+	return f.do(ctx, http.MethodPut, fmt.Sprintf("/service/%s/version/%d/activate", serviceID, number), nil, nil)
+}
+
+func (f *fastlyClient) setVersionComment(ctx context.Context, serviceID string, number int, comment string) error {
+	params := url.Values{"comment": {comment}}
+	return f.do(ctx, http.MethodPut, fmt.Sprintf("/service/%s/version/%d", serviceID, number), params, nil)
+}
+
+func (f *fastlyClient) lockVersion(ctx context.Context, serviceID string, number int) error {
+	return f.do(ctx, http.MethodPut, fmt.Sprintf("/service/%s/version/%d/lock", serviceID, number), nil, nil)
+}
+
+// https://developer.fastly.com/reference/api/services/service/
+type service struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Version int    `json:"version"` // the active version
+}
+
+func (f *fastlyClient) service(ctx context.Context, serviceID string) (service, error) {
+	var s service
+	err := f.do(ctx, http.MethodGet, fmt.Sprintf("/service/%s", serviceID), nil, &s)
+	return s, err
+}
+
+// https://developer.fastly.com/reference/api/auth-tokens/user/
+type token struct {
+	ID        string `json:"id"`
+	UserID    string `json:"user_id"`
+	Scope     string `json:"scope"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+func (f *fastlyClient) tokenSelf(ctx context.Context) (token, error) {
+	var t token
+	err := f.do(ctx, http.MethodGet, "/tokens/self", nil, &t)
+	return t, err
+}
+
+// https://developer.fastly.com/reference/api/auth-tokens/user/#revoke-token-current
+func (f *fastlyClient) revokeTokenSelf(ctx context.Context) error {
+	return f.do(ctx, http.MethodDelete, "/tokens/self", nil, nil)
+}
+
+// https://developer.fastly.com/reference/api/account/user/
+type user struct {
+	ID    string `json:"id"`
+	Login string `json:"login"`
+	Name  string `json:"name"`
+}
+
+func (f *fastlyClient) currentUser(ctx context.Context) (user, error) {
+	var u user
+	err := f.do(ctx, http.MethodGet, "/current_user", nil, &u)
+	return u, err
+}
+
+// ping checks the Fastly API is reachable, using an endpoint that doesn't
+// require authentication.
+func (f *fastlyClient) ping(ctx context.Context) error {
+	return f.do(ctx, http.MethodGet, "/public-ip-list", nil, nil)
+}
+
+// services lists every service on the account.
+func (f *fastlyClient) services(ctx context.Context) ([]service, error) {
+	var all []service
+	for page := 1; ; page++ {
+		var services []service
+		params := url.Values{"page": {fmt.Sprint(page)}, "per_page": {"100"}}
+		if err := f.do(ctx, http.MethodGet, "/service", params, &services); err != nil {
+			return nil, err
+		}
+
+		all = append(all, services...)
+		if len(services) < 100 {
+			return all, nil
+		}
+	}
+}
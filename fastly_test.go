@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// fakeFastly is a client whose requests to the Fastly API are served by
+// handler.
+func fakeFastly(t *testing.T, handler http.HandlerFunc) *fastlyClient {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &fastlyClient{key: "test", client: &http.Client{Transport: testServerTransport(u.Host)}}
+}
+
+// testServerTransport sends every request to a test server.
+type testServerTransport string
+
+func (host testServerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host = "http", string(host)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// serveJSON serves each path's value as JSON, and 404s for anything else.
+func serveJSON(t *testing.T, responses map[string]interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		v, ok := responses[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(v); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestIdempotent(t *testing.T) {
+	for _, path := range []string{"/service/abc/version", "/service/abc/version/2/logging/s3/logs"} {
+		for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+			if !idempotent(method, path) {
+				t.Errorf("%s %s isn't retried", method, path)
+			}
+		}
+	}
+	if !idempotent(http.MethodPut, "/service/abc/version/2/activate") {
+		t.Error("activating a version isn't retried")
+	}
+
+	// Repeating these would create another version or logging endpoint.
+	if idempotent(http.MethodPut, "/service/abc/version/1/clone") {
+		t.Error("cloning a version is retried")
+	}
+	if idempotent(http.MethodPost, "/service/abc/version/2/logging/s3") {
+		t.Error("creating a logging endpoint is retried")
+	}
+}
+
+func TestFindClone(t *testing.T) {
+	before := []version{
+		{Number: 1, Locked: true},
+		{Number: 2, Active: true, Locked: true, Comment: "Rotated"},
+	}
+	cloneErr := errors.New("PUT /service/abc/version/2/clone failed: " + errAmbiguous.Error())
+	findClone := func(created ...version) (int, error) {
+		versions := append(append([]version{}, before...), created...)
+		f := fakeFastly(t, serveJSON(t, map[string]interface{}{"/service/abc/version": versions}))
+		return f.findClone(context.Background(), "abc", 2, before, cloneErr)
+	}
+
+	for _, comment := range []string{"Rotated", "", versionComment(commandName)} {
+		if n, err := findClone(version{Number: 3, Comment: comment}); err != nil || n != 3 {
+			t.Errorf("findClone() of a draft commented %q = %d, %v, want 3", comment, n, err)
+		}
+	}
+
+	for name, created := range map[string][]version{
+		"no new version":            nil,
+		"someone else's draft":      {{Number: 3, Comment: "Testing a new backend"}},
+		"a locked version":          {{Number: 3, Locked: true, Comment: "Rotated"}},
+		"an active version":         {{Number: 3, Active: true, Comment: "Rotated"}},
+		"two drafts":                {{Number: 3, Comment: "Rotated"}, {Number: 4, Comment: "Rotated"}},
+		"a draft and an activation": {{Number: 3, Comment: "Rotated"}, {Number: 4, Active: true, Locked: true}},
+	} {
+		if n, err := findClone(created...); err == nil {
+			t.Errorf("findClone() with %s = %d, want an error", name, n)
+		}
+	}
+}
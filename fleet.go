@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// fleetManifest lists the S3 logging configurations of a fleet of services,
+// for rotate-creds -manifest to rotate in one run. It is JSON, or YAML with a
+// .yaml or .yml extension:
+//
+//	{"services": [
+//	  {"service_name": "frontend-prod", "logging_name": "s3-logs"},
+//	  {"service_id": "SU1Z0isxPaozGVKXdv0eY", "logging_name": "s3-logs", "bucket_name": "logs-2024", "path": "/fastly/"}
+//	]}
+type fleetManifest struct {
+	Services []fleetEntry `json:"services"`
+}
+
+// fleetEntry is one S3 logging configuration of a fleet manifest. Services
+// can be given by name, which is looked up. BucketName and Path, if given,
+// are changed along with the credentials.
+type fleetEntry struct {
+	ServiceID   string `json:"service_id"`
+	ServiceName string `json:"service_name"`
+	LoggingName string `json:"logging_name"`
+	BucketName  string `json:"bucket_name"`
+	Path        string `json:"path"`
+}
+
+// loadFleet reads a fleet manifest, looking up the IDs of services given by
+// name.
+func loadFleet(ctx context.Context, path string) ([]fleetEntry, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if isYAMLFile(path) {
+		if data, err = yamlToJSON(data); err != nil {
+			return nil, fmt.Errorf("Invalid manifest %s: %s", path, err.Error())
+		}
+	}
+
+	var m fleetManifest
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&m); err != nil {
+		return nil, fmt.Errorf("Invalid manifest %s: %s", path, err.Error())
+	}
+	if len(m.Services) == 0 {
+		return nil, fmt.Errorf("Manifest %s lists no services", path)
+	}
+
+	var all []accountService
+	for i, e := range m.Services {
+		switch {
+		case e.LoggingName == "":
+			return nil, fmt.Errorf("Manifest %s: entry %d has no logging_name", path, i+1)
+		case e.ServiceID != "" && e.ServiceName != "":
+			return nil, fmt.Errorf("Manifest %s: entry %d has both service_id and service_name", path, i+1)
+		case e.ServiceID != "":
+			continue
+		case e.ServiceName == "":
+			return nil, fmt.Errorf("Manifest %s: entry %d has no service_id or service_name", path, i+1)
+		}
+
+		// Every service is listed once, however many are named.
+		if all == nil {
+			all = servicesFor(ctx, "")
+		}
+		id, err := serviceIDNamed(all, e.ServiceName)
+		if err != nil {
+			return nil, fmt.Errorf("Manifest %s: %s", path, err.Error())
+		}
+		m.Services[i].ServiceID = id
+	}
+	return m.Services, nil
+}
+
+// rotateFleet puts a new key pair in place on every entry of a fleet
+// manifest, going on past failures. Each outcome is passed to record as it
+// happens.
+func rotateFleet(ctx context.Context, fleet []fleetEntry, accessKey, secretKey string, record func(outcome)) []outcome {
+	var outcomes []outcome
+	for _, e := range fleet {
+		o := rotateServiceTo(ctx, fastlyFor(e.ServiceID), e.ServiceID, e.LoggingName, accessKey, secretKey, e.BucketName, e.Path)
+		record(o)
+		if len(fleet) > 1 {
+			fmt.Fprintf(messages(), "%s/%s: %s\n", e.ServiceID, e.LoggingName, o.Detail)
+		}
+		outcomes = append(outcomes, o)
+	}
+	return outcomes
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// gcpServiceAccountKey is the JSON key file format for a service account.
+type gcpServiceAccountKey struct {
+	PrivateKeyID string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	ClientEmail  string `json:"client_email"`
+	TokenURI     string `json:"token_uri"`
+}
+
+type gcpClient struct {
+	token  string
+	client *http.Client
+}
+
+// newGCPClient authenticates with GOOGLE_OAUTH_ACCESS_TOKEN if set (e.g. from
+// `gcloud auth print-access-token`), or else the service account key file
+// named by GOOGLE_APPLICATION_CREDENTIALS.
+func newGCPClient(ctx context.Context) (*gcpClient, error) {
+	g := &gcpClient{client: httpClient()}
+
+	if token := secret("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		g.token = token
+		return g, nil
+	}
+
+	keyFile := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if keyFile == "" {
+		return nil, errors.New("Missing GCP credentials: set GOOGLE_OAUTH_ACCESS_TOKEN or GOOGLE_APPLICATION_CREDENTIALS")
+	}
+
+	b, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var key gcpServiceAccountKey
+	if err := json.Unmarshal(b, &key); err != nil {
+		return nil, err
+	}
+
+	g.token, err = g.exchangeJWT(ctx, key)
+	return g, err
+}
+
+// https://developers.google.com/identity/protocols/oauth2/service-account#httprest
+func (g *gcpClient) exchangeJWT(ctx context.Context, key gcpServiceAccountKey) (string, error) {
+	if key.TokenURI == "" {
+		key.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+
+	signer, err := parseRSAPrivateKey(key.PrivateKey)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": key.PrivateKeyID})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   key.ClientEmail,
+		"scope": "https://www.googleapis.com/auth/cloud-platform",
+		"aud":   key.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, signer, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {unsigned + "." + base64.RawURLEncoding.EncodeToString(sig)},
+	}
+
+	var resp struct {
+		AccessToken string `json:"access_token"`
+	}
+	err = g.do(ctx, http.MethodPost, key.TokenURI, strings.NewReader(form.Encode()), "application/x-www-form-urlencoded", &resp)
+	return resp.AccessToken, err
+}
+
+func parseRSAPrivateKey(pemData string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(pemData))
+	if block == nil {
+		return nil, errors.New("Invalid private key: no PEM data found")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("Invalid private key: not an RSA key")
+	}
+	return key, nil
+}
+
+func (g *gcpClient) do(ctx context.Context, method, reqURL string, body io.Reader, contentType string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, body)
+	if err != nil {
+		return err
+	}
+
+	if g.token != "" {
+		req.Header.Add("Authorization", "Bearer "+g.token)
+	}
+	if contentType != "" {
+		req.Header.Add("Content-Type", contentType)
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s %s failed: %d, %s", method, reqURL, resp.StatusCode, string(respBody))
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(respBody, out)
+}
+
+// https://cloud.google.com/iam/docs/reference/rest/v1/projects.serviceAccounts.keys
+type gcpKey struct {
+	Name           string `json:"name"`
+	PrivateKeyData string `json:"privateKeyData"`
+	PublicKeyData  string `json:"publicKeyData"`
+	ValidAfterTime string `json:"validAfterTime"`
+	KeyType        string `json:"keyType"`
+	Disabled       bool   `json:"disabled"`
+}
+
+func gcpKeysURL(email string) string {
+	return fmt.Sprintf("https://iam.googleapis.com/v1/projects/-/serviceAccounts/%s/keys", url.PathEscape(email))
+}
+
+// createServiceAccountKey mints a new key, returning its resource name and
+// decoded JSON key file.
+func (g *gcpClient) createServiceAccountKey(ctx context.Context, email string) (string, gcpServiceAccountKey, error) {
+	var created gcpKey
+	var key gcpServiceAccountKey
+
+	err := g.do(ctx, http.MethodPost, gcpKeysURL(email), bytes.NewReader([]byte("{}")), "application/json", &created)
+	if err != nil {
+		return "", key, err
+	}
+
+	keyFile, err := base64.StdEncoding.DecodeString(created.PrivateKeyData)
+	if err != nil {
+		return "", key, err
+	}
+	return created.Name, key, json.Unmarshal(keyFile, &key)
+}
+
+// keyForPrivateKey finds the resource name of the user-managed key matching
+// privateKey, by comparing public keys.
+func (g *gcpClient) keyForPrivateKey(ctx context.Context, email, privateKey string) (string, error) {
+	signer, err := parseRSAPrivateKey(privateKey)
+	if err != nil {
+		return "", err
+	}
+
+	var list struct {
+		Keys []gcpKey `json:"keys"`
+	}
+	if err := g.do(ctx, http.MethodGet, gcpKeysURL(email)+"?keyTypes=USER_MANAGED", nil, "", &list); err != nil {
+		return "", err
+	}
+
+	for _, k := range list.Keys {
+		var full gcpKey
+		if err := g.do(ctx, http.MethodGet, "https://iam.googleapis.com/v1/"+k.Name+"?publicKeyType=TYPE_X509_PEM_FILE", nil, "", &full); err != nil {
+			return "", err
+		}
+
+		certPEM, err := base64.StdEncoding.DecodeString(full.PublicKeyData)
+		if err != nil {
+			continue
+		}
+		block, _ := pem.Decode(certPEM)
+		if block == nil {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			continue
+		}
+		if pub, ok := cert.PublicKey.(*rsa.PublicKey); ok && pub.N.Cmp(signer.N) == 0 {
+			return k.Name, nil
+		}
+	}
+
+	return "", fmt.Errorf("No user-managed key of %s matches the configured private key", email)
+}
+
+func (g *gcpClient) deleteServiceAccountKey(ctx context.Context, name string) error {
+	return g.do(ctx, http.MethodDelete, "https://iam.googleapis.com/v1/"+name, nil, "", nil)
+}
+
+// https://cloud.google.com/storage/docs/json_api/v1/objects/list
+type gcsObject struct {
+	Name        string    `json:"name"`
+	Size        string    `json:"size"`
+	TimeCreated time.Time `json:"timeCreated"`
+}
+
+// listObjects lists the objects under prefix, giving up after maxPages
+// pages (unless it is 0), as listObjects does for S3.
+func (g *gcpClient) listObjects(ctx context.Context, bucket, prefix string, maxPages int) ([]gcsObject, error) {
+	var objects []gcsObject
+	pageToken := ""
+
+	for pages := 1; ; pages++ {
+		params := url.Values{"prefix": {prefix}}
+		if pageToken != "" {
+			params.Set("pageToken", pageToken)
+		}
+
+		var page struct {
+			Items         []gcsObject `json:"items"`
+			NextPageToken string      `json:"nextPageToken"`
+		}
+		reqURL := fmt.Sprintf("https://storage.googleapis.com/storage/v1/b/%s/o?%s", url.PathEscape(bucket), params.Encode())
+		if err := g.do(ctx, http.MethodGet, reqURL, nil, "", &page); err != nil {
+			return nil, err
+		}
+
+		objects = append(objects, page.Items...)
+		if page.NextPageToken == "" {
+			return objects, nil
+		}
+		if maxPages > 0 && pages >= maxPages {
+			return nil, fmt.Errorf("More than %d pages of objects under gs://%s/%s", maxPages, bucket, prefix)
+		}
+		pageToken = page.NextPageToken
+	}
+}
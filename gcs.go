@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// https://developer.fastly.com/reference/api/logging/gcs/
+type gcsLogging struct {
+	Name       string  `json:"name"`
+	BucketName string  `json:"bucket_name"`
+	Path       string  `json:"path"`
+	User       string  `json:"user"`
+	SecretKey  string  `json:"secret_key"`
+	ProjectID  string  `json:"project_id"`
+	Period     flexInt `json:"period"`
+}
+
+func (f *fastlyClient) gcsLogging(ctx context.Context, serviceID string, version int, name string) (gcsLogging, error) {
+	var l gcsLogging
+	err := f.do(ctx, http.MethodGet, fmt.Sprintf("/service/%s/version/%d/logging/gcs/%s", serviceID, version, name), nil, &l)
+	return l, err
+}
+
+func (f *fastlyClient) updateGCSCreds(ctx context.Context, serviceID string, version int, name, user, secretKey string) error {
+	form := url.Values{"user": {user}, "secret_key": {secretKey}}
+	return f.updateLogging(ctx, serviceID, version, "gcs", name, form)
+}
+
+// rotateGCSKey is the GCS analogue of an IAM key rotation: mint a new
+// service account key, switch Fastly over to it, wait until logs arrive, and
+// only then delete the old key.
+func rotateGCSKey(args []string) {
+	fs := flag.NewFlagSet("rotate-gcs-key", flag.ExitOnError)
+	serviceID := fs.String("serviceID", "", "A Fastly Service ID.")
+	loggingName := fs.String("loggingName", "", "Name of your service GCS logging configuration in Fastly.")
+	keepOldKey := fs.Bool("keepOldKey", false, "Don't delete the old service account key after verifying delivery.")
+	verifyTimeout := fs.Duration("verifyTimeout", 0, "How long to wait for logs to be delivered with the new key (default: two logging periods plus 10m).")
+	draftFlags(fs)
+	commonFlags(fs)
+
+	fs.Usage = commandUsage(fs, "Note, FASTLY_KEY and GCP credentials (GOOGLE_OAUTH_ACCESS_TOKEN or GOOGLE_APPLICATION_CREDENTIALS) able to manage the logging service account's keys and list the bucket must be provided as env vars.")
+	parseFlags(fs, args)
+	refuseDryRun("rotate-gcs-key")
+
+	checkArg("serviceID", *serviceID)
+	checkArg("loggingName", *loggingName)
+
+	f := fastlyFor(*serviceID)
+	ctx := context.Background()
+
+	g, err := newGCPClient(ctx)
+	check(err)
+
+	active, err := f.activeVersion(ctx, *serviceID)
+	check(err)
+
+	current, err := f.gcsLogging(ctx, *serviceID, active, *loggingName)
+	check(err)
+	// Refuse before minting a key if delivery with it can't be verified.
+	if deliveryPrefix(current.Path, time.Now()) == "" {
+		check(fmt.Errorf("The path of %s (%q) has no directory to list log files under, and the whole bucket won't be listed every poll", current.Name, current.Path))
+	}
+
+	oldKey, err := g.keyForPrivateKey(ctx, current.User, current.SecretKey)
+	check(err)
+
+	newKey, keyFile, err := g.createServiceAccountKey(ctx, current.User)
+	check(err)
+	fmt.Printf("Created key %s.\n", newKey)
+
+	number, err := withDraft(ctx, f, *serviceID, func(number int) error {
+		return f.updateGCSCreds(ctx, *serviceID, number, *loggingName, keyFile.ClientEmail, keyFile.PrivateKey)
+	})
+	if err != nil {
+		// Unless the draft got as far as activation, nothing uses the new
+		// key, so don't leave it lying around.
+		if !maybeActivated(err) {
+			g.deleteServiceAccountKey(ctx, newKey)
+		}
+		check(err)
+	}
+	activatedAt := time.Now()
+	fmt.Printf("Activated version %d of service %s.\n", number, *serviceID)
+	recordRotation("gcs", *serviceID, *loggingName, active, number, path.Base(oldKey), keyFile.PrivateKeyID)
+
+	timeout := *verifyTimeout
+	if timeout == 0 {
+		timeout = keyDeliveryTimeout(int(current.Period))
+	}
+	// As with waitForS3Delivery, files from within a logging period of
+	// activation may have been written with the old key.
+	since := activatedAt.Add(loggingPeriod(int(current.Period)))
+
+	fmt.Printf("Waiting up to %s for logs to be delivered to gs://%s...\n", timeout, current.BucketName)
+	err = waitFor(ctx, timeout, time.Minute, func() (bool, error) {
+		objects, err := g.listObjects(ctx, current.BucketName, deliveryPrefix(current.Path, time.Now()), maxPollPages)
+		if err != nil {
+			return false, err
+		}
+		for _, o := range objects {
+			if o.TimeCreated.After(since) {
+				fmt.Printf("Delivery verified: gs://%s/%s\n", current.BucketName, o.Name)
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		check(fmt.Errorf("Unable to verify delivery, leaving old key %s in place: %s", oldKey, err.Error()))
+	}
+
+	if *keepOldKey {
+		fmt.Printf("Leaving old key %s in place.\n", oldKey)
+		return
+	}
+
+	check(g.deleteServiceAccountKey(ctx, oldKey))
+	fmt.Printf("Deleted old key %s.\n", oldKey)
+}
+
+// gcpKeyProvider is a logging type that authenticates with a GCP service
+// account key (GCS and BigQuery). Fastly wants the key's private_key as it
+// is, with real newlines, but keys copied out of the JSON key file (or from a
+// secret store holding it) often still have them JSON-escaped, which Fastly
+// accepts but then fails to authenticate with.
+type gcpKeyProvider struct {
+	restProvider
+}
+
+func (p gcpKeyProvider) rotateCreds(fields url.Values) error {
+	if err := p.restProvider.rotateCreds(fields); err != nil {
+		return err
+	}
+	key := fields.Get("secret_key")
+	if strings.HasPrefix(strings.TrimSpace(key), "{") {
+		// The whole key file, e.g. as kept in Secret Manager.
+		var keyFile gcpServiceAccountKey
+		if err := json.Unmarshal([]byte(key), &keyFile); err != nil || keyFile.PrivateKey == "" {
+			return fmt.Errorf("The new %s secret key is JSON, but not a service account key file", p.name)
+		}
+		key = keyFile.PrivateKey
+	}
+	fields.Set("secret_key", unescapePrivateKey(key))
+	return nil
+}
+
+// unescapePrivateKey turns the \n escapes of a PEM private key copied from
+// JSON back into newlines.
+func unescapePrivateKey(key string) string {
+	if strings.Contains(key, "\n") || !strings.Contains(key, `\n`) {
+		return key
+	}
+	return strings.Replace(key, `\n`, "\n", -1)
+}
+
+// readGCPKeyFile reads a service account JSON key file, for the user and
+// secret_key of GCS or BigQuery logging.
+func readGCPKeyFile(file string) (gcpServiceAccountKey, error) {
+	var key gcpServiceAccountKey
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return key, err
+	}
+	if err := json.Unmarshal(data, &key); err != nil {
+		return key, fmt.Errorf("Invalid service account key file %s: %s", file, err.Error())
+	}
+	if key.ClientEmail == "" || key.PrivateKey == "" {
+		return key, fmt.Errorf("%s isn't a service account key file: it has no client_email or private_key", file)
+	}
+	return key, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// healthcheck is a smoke test for new environments: it checks each thing a
+// rotation depends on in turn and reports on all of them.
+func healthcheck(args []string) {
+	fs := flag.NewFlagSet("healthcheck", flag.ExitOnError)
+	serviceID := fs.String("serviceID", "", "A Fastly Service ID to check access to (optional).")
+	awsAccessKey := fs.String("awsAccessKey", "", "AWS Access Key to check with AWS (optional).")
+	commonFlags(fs)
+
+	fs.Usage = commandUsage(fs, "Note, FASTLY_KEY (and AWS_SECRET_KEY with -awsAccessKey) must be provided as env vars.")
+	parseFlags(fs, args)
+
+	awsSecretKey := secret("AWS_SECRET_KEY")
+
+	if *awsAccessKey != "" {
+		checkArg("AWS_SECRET_KEY", awsSecretKey)
+	}
+
+	f := fastlyFor(*serviceID)
+	ctx := context.Background()
+	failed := false
+
+	report := func(name string, detail string, err error) {
+		if err != nil {
+			failed = true
+			fmt.Printf("FAIL  %s: %s\n", name, err.Error())
+			return
+		}
+		fmt.Printf("ok    %s %s\n", name, detail)
+	}
+
+	report("Fastly API reachable", "", f.ping(ctx))
+
+	t, err := f.tokenSelf(ctx)
+	report("Fastly authentication", fmt.Sprintf("(token %s, scope %s)", t.ID, t.Scope), err)
+
+	if *serviceID != "" {
+		s, err := f.service(ctx, *serviceID)
+		report("Fastly service visible", fmt.Sprintf("(%s)", s.Name), err)
+	}
+
+	if *awsAccessKey != "" {
+		a := newAWSClient(awsCredentials{accessKey: *awsAccessKey, secretKey: awsSecretKey}, os.Getenv("AWS_REGION"))
+		arn, err := a.callerIdentity(ctx)
+		report("AWS credentials", fmt.Sprintf("(%s)", arn), err)
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// history prints the rotations recorded in the state file, for audits.
+func history(args []string) {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	serviceID := fs.String("serviceID", "", "Only rotations of this service.")
+	loggingName := fs.String("loggingName", "", "Only rotations of logging configurations with this name.")
+	typ := fs.String("type", "", "Only rotations of this type of logging configuration.")
+	key := fs.String("key", "", "Only rotations to or from this access key ID (or fingerprint).")
+	operator := fs.String("operator", "", "Only rotations by this operator.")
+	since := fs.Duration("since", 0, "Only rotations within this long, e.g. 2160h for the last 90 days (default: all).")
+	fs.BoolVar(&jsonLines, "jsonLines", false, "Print each rotation as a line of JSON.")
+	outputFlag(fs, "table")
+	commonFlags(fs)
+
+	fs.Usage = commandUsage(fs, "Note, the state file is FLC_STATE_FILE (a path, or s3://bucket/key), and FLC_STATE_KEY is required if it is encrypted.")
+	parseFlags(fs, args)
+
+	state, err := loadState()
+	check(err)
+
+	var entries []historyEntry
+	for _, e := range state.History {
+		switch {
+		case *serviceID != "" && e.ServiceID != *serviceID,
+			*loggingName != "" && e.LoggingName != *loggingName,
+			*typ != "" && e.Type != *typ,
+			*key != "" && e.OldKey != *key && e.NewKey != *key,
+			*operator != "" && e.Operator != *operator,
+			*since > 0 && time.Since(e.RotatedAt) > *since:
+			continue
+		}
+		entries = append(entries, e)
+	}
+
+	if jsonLines {
+		for _, e := range entries {
+			emitJSONLine(e)
+		}
+		return
+	}
+	if entries == nil {
+		entries = []historyEntry{}
+	}
+	if printStructured(entries) {
+		return
+	}
+	if len(entries) == 0 {
+		fmt.Println("No rotations recorded.")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ROTATED\tSERVICE\tENDPOINT\tVERSION\tOLD KEY\tNEW KEY\tOPERATOR\tCOMMAND\t")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s/%s\t%d -> %d\t%s\t%s\t%s\t%s\t\n", e.RotatedAt.Format(time.RFC3339), e.ServiceID, e.Type, e.LoggingName, e.FromVersion, e.ToVersion, orDash(e.OldKey), e.NewKey, orDash(e.Operator), e.Command)
+	}
+	w.Flush()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// listFlag collects the values of a repeatable flag.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+var preActivateHooks, postActivateHooks listFlag
+
+// hookContext is passed to hooks as JSON: on stdin for commands, or as the
+// request body for webhooks.
+type hookContext struct {
+	Phase         string `json:"phase"`
+	Command       string `json:"command"`
+	ServiceID     string `json:"service_id"`
+	ActiveVersion int    `json:"active_version"`
+	Version       int    `json:"version"`
+	Operator      string `json:"operator"`
+}
+
+// runHooks runs each hook in turn, stopping at the first failure.
+func runHooks(ctx context.Context, hooks listFlag, hc hookContext) error {
+	payload, err := json.Marshal(hc)
+	if err != nil {
+		return err
+	}
+
+	for _, hook := range hooks {
+		if strings.HasPrefix(hook, "http://") || strings.HasPrefix(hook, "https://") {
+			err = runWebhook(ctx, hook, payload)
+		} else {
+			err = runHookCmd(ctx, hook, payload)
+		}
+		if err != nil {
+			return fmt.Errorf("%s hook '%s' failed: %s", hc.Phase, hook, err.Error())
+		}
+	}
+	return nil
+}
+
+func runHookCmd(ctx context.Context, command string, payload []byte) error {
+	cmd := shellCommand(ctx, command)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = os.Stderr // keep our stdout for our own output
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+func runWebhook(ctx context.Context, hookURL string, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hookURL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Content-Type", "application/json")
+
+	resp, err := httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%d, %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+// shellCommand runs command with the platform's shell.
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// https://docs.aws.amazon.com/IAM/latest/APIReference/
+const (
+	iamHost    = "iam.amazonaws.com"
+	iamVersion = "2010-05-08"
+)
+
+func (a *awsClient) iam(ctx context.Context, action string, params url.Values, out interface{}) error {
+	return a.queryAPI(ctx, "iam", "us-east-1", iamHost, action, iamVersion, params, out)
+}
+
+type accessKeyLastUsed struct {
+	UserName     string    `xml:"GetAccessKeyLastUsedResult>UserName"`
+	LastUsedDate time.Time `xml:"GetAccessKeyLastUsedResult>AccessKeyLastUsed>LastUsedDate"`
+	ServiceName  string    `xml:"GetAccessKeyLastUsedResult>AccessKeyLastUsed>ServiceName"`
+}
+
+// accessKeyLastUsed also identifies the IAM user that owns an access key.
+func (a *awsClient) accessKeyLastUsed(ctx context.Context, accessKeyID string) (accessKeyLastUsed, error) {
+	var resp accessKeyLastUsed
+	err := a.iam(ctx, "GetAccessKeyLastUsed", url.Values{"AccessKeyId": {accessKeyID}}, &resp)
+	return resp, err
+}
+
+type accessKeyMetadata struct {
+	AccessKeyID string    `xml:"AccessKeyId"`
+	Status      string    `xml:"Status"`
+	CreateDate  time.Time `xml:"CreateDate"`
+}
+
+func (a *awsClient) listAccessKeys(ctx context.Context, userName string) ([]accessKeyMetadata, error) {
+	var resp struct {
+		Keys []accessKeyMetadata `xml:"ListAccessKeysResult>AccessKeyMetadata>member"`
+	}
+	err := a.iam(ctx, "ListAccessKeys", url.Values{"UserName": {userName}}, &resp)
+	return resp.Keys, err
+}
+
+// accessKeyCreated looks up when an access key was created.
+func (a *awsClient) accessKeyCreated(ctx context.Context, accessKeyID string) (time.Time, error) {
+	lastUsed, err := a.accessKeyLastUsed(ctx, accessKeyID)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	keys, err := a.listAccessKeys(ctx, lastUsed.UserName)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	for _, k := range keys {
+		if k.AccessKeyID == accessKeyID {
+			return k.CreateDate, nil
+		}
+	}
+	return time.Time{}, &awsError{op: "iam:ListAccessKeys", statusCode: 404, body: "access key " + accessKeyID + " not found for user " + lastUsed.UserName}
+}
+
+// createAccessKey mints a new key pair for an IAM user. A user can have at
+// most two.
+func (a *awsClient) createAccessKey(ctx context.Context, userName string) (awsCredentials, error) {
+	var resp struct {
+		AccessKeyID     string `xml:"CreateAccessKeyResult>AccessKey>AccessKeyId"`
+		SecretAccessKey string `xml:"CreateAccessKeyResult>AccessKey>SecretAccessKey"`
+	}
+	err := a.iam(ctx, "CreateAccessKey", url.Values{"UserName": {userName}}, &resp)
+	return awsCredentials{accessKey: resp.AccessKeyID, secretKey: resp.SecretAccessKey}, err
+}
+
+// waitForAccessKey waits until a new access key works, as IAM takes a few
+// seconds to make one available.
+func waitForAccessKey(ctx context.Context, creds awsCredentials) error {
+	return waitFor(ctx, 2*time.Minute, 5*time.Second, func() (bool, error) {
+		_, err := newAWSClient(creds, "").callerIdentity(ctx)
+		return err == nil, nil
+	})
+}
+
+// updateAccessKey sets an access key Active or Inactive.
+func (a *awsClient) updateAccessKey(ctx context.Context, userName, accessKeyID, status string) error {
+	return a.iam(ctx, "UpdateAccessKey", url.Values{"UserName": {userName}, "AccessKeyId": {accessKeyID}, "Status": {status}}, nil)
+}
+
+// checkOldKeyUnused refuses to retire an old access key that was used after
+// the new key took over at since, according to IAM (and CloudTrail, with
+// -cloudTrailCheck), which means something besides Fastly still depends on
+// it.
+func (a *awsClient) checkOldKeyUnused(ctx context.Context, oldKey string, since time.Time) (accessKeyLastUsed, error) {
+	used, err := a.accessKeyLastUsed(ctx, oldKey)
+	if err != nil {
+		return used, err
+	}
+	if used.LastUsedDate.After(since) {
+		return used, fmt.Errorf("old key %s was last used (by %s) at %s, after the new key took over, so something else may still depend on it", oldKey, used.ServiceName, used.LastUsedDate.Format(time.RFC3339))
+	}
+
+	if cloudTrailCheck {
+		r, err := keyUsage(ctx, oldKey, since)
+		if err != nil {
+			return used, err
+		}
+		if len(r.Events) > 0 {
+			e := r.Events[len(r.Events)-1]
+			return used, fmt.Errorf("CloudTrail has %d use(s) of old key %s after the new key took over, the last %s:%s from %s at %s, so something else may still depend on it", len(r.Events), oldKey, e.EventSource, e.EventName, e.SourceIP, e.Time.Format(time.RFC3339))
+		}
+		fmt.Fprintf(messages(), "CloudTrail has no use of old key %s since %s.\n", oldKey, since.Format(time.RFC3339))
+	}
+	return used, nil
+}
+
+func (a *awsClient) deleteAccessKey(ctx context.Context, userName, accessKeyID string) error {
+	return a.iam(ctx, "DeleteAccessKey", url.Values{"UserName": {userName}, "AccessKeyId": {accessKeyID}}, nil)
+}
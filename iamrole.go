@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"regexp"
+)
+
+var iamRoleARN = regexp.MustCompile(`^arn:aws[a-z-]*:iam::\d{12}:role/.+`)
+
+// iamRoleTypes are the logging types Fastly can deliver to by assuming an
+// IAM role, in place of access keys.
+var iamRoleTypes = []string{"s3", "kinesis"}
+
+func checkIAMRole(typ, role string) error {
+	if !contains(iamRoleTypes, typ) {
+		return fmt.Errorf("%s logging can't assume an IAM role, only s3 and kinesis can", typ)
+	}
+	if !iamRoleARN.MatchString(role) {
+		return fmt.Errorf("Invalid IAM role %q, expected arn:aws:iam::<account>:role/<name>", role)
+	}
+	return nil
+}
+
+// migrateToIAMRole switches a key-based S3 (or Kinesis) logging configuration
+// to delivery by Fastly assuming an IAM role, so there are no keys left to
+// rotate. The role must trust Fastly's AWS account, with the Fastly customer
+// ID as the external ID.
+//
+// https://docs.fastly.com/en/guides/creating-an-aws-iam-role-for-fastly-logging
+func migrateToIAMRole(args []string) {
+	fs := flag.NewFlagSet("migrate-to-iam-role", flag.ExitOnError)
+	serviceID := fs.String("serviceID", "", "A Fastly Service ID.")
+	loggingName := fs.String("loggingName", "", "Name of your service logging configuration in Fastly.")
+	typ := fs.String("type", "s3", "Type of the logging configuration: s3 or kinesis.")
+	role := fs.String("iamRoleArn", "", "ARN of the IAM role for Fastly to assume to deliver logs.")
+	draftFlags(fs)
+	notifyFlags(fs)
+	commonFlags(fs)
+
+	fs.Usage = commandUsage(fs, "Note, FASTLY_KEY must be provided as an env var.")
+	parseFlags(fs, args)
+
+	checkArg("serviceID", *serviceID)
+	checkArg("loggingName", *loggingName)
+	checkArg("iamRoleArn", *role)
+	check(checkIAMRole(*typ, *role))
+
+	f := fastlyFor(*serviceID)
+	ctx := context.Background()
+
+	active, err := f.activeVersion(ctx, *serviceID)
+	check(err)
+	loggings, err := f.loggings(ctx, *serviceID, active, *typ)
+	check(err)
+	current, ok := byName(loggings)[*loggingName]
+	if !ok {
+		check(fmt.Errorf("Service %s has no %s logging configuration named %q", *serviceID, *typ, *loggingName))
+	}
+
+	oldKey := fmt.Sprint(current["access_key"])
+	if current["iam_role"] == *role && (current["access_key"] == nil || oldKey == "") {
+		fmt.Fprintf(messages(), "%s/%s already uses IAM role %s.\n", *typ, *loggingName, *role)
+		return
+	}
+
+	fmt.Fprintf(messages(), "%s/%s: access key %s -> IAM role %s\n", *typ, *loggingName, oldKey, *role)
+
+	fields := url.Values{"iam_role": {*role}, "access_key": {""}, "secret_key": {""}}
+	number, err := withDraft(ctx, f, *serviceID, func(number int) error {
+		return f.updateLogging(ctx, *serviceID, number, *typ, *loggingName, fields)
+	})
+	if errors.Is(err, errDryRun) {
+		// The calls it would make have been printed.
+		check(err)
+	}
+	if err != nil {
+		notify(outcome{ServiceID: *serviceID, Status: statusFailed, Detail: err.Error()})
+		flushNotifications()
+		check(err)
+	}
+	recordRotation(*typ, *serviceID, *loggingName, active, number, oldKey, *role)
+
+	msg := fmt.Sprintf("Activated version %d of service %s, with %s delivering as IAM role %s.", number, *serviceID, *loggingName, *role)
+	notify(outcome{ServiceID: *serviceID, Status: statusRotated, Detail: msg})
+	flushNotifications()
+	fmt.Fprintln(messages(), msg)
+	if oldKey != "" {
+		fmt.Fprintf(messages(), "Access key %s is no longer used by %s, and can be deleted once nothing else uses it (see cleanup-keys).\n", oldKey, *loggingName)
+	}
+}
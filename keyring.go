@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Secrets can be kept in the OS keyring: the macOS keychain (with the
+// security CLI), the Secret Service on Linux (with secret-tool), or the
+// Windows Credential Manager.
+const keyringService = "fastly-logging-creds"
+
+var errNoKeyring = errors.New("No supported keyring: needs the macOS keychain, secret-tool or the Windows Credential Manager")
+
+func keyringSet(name, value string) error {
+	switch {
+	case runtime.GOOS == "windows":
+		return credSet(name, value)
+	case runtime.GOOS == "darwin":
+		// The password is given on stdin (to security's interactive mode),
+		// as arguments can be seen by anyone with ps.
+		cmd := exec.Command("security", "-i")
+		cmd.Stdin = strings.NewReader(securityCommand("add-generic-password", "-U", "-s", keyringService, "-a", name, "-w", value))
+		cmd.Stderr = os.Stderr
+		return cmd.Run()
+	case hasCommand("secret-tool"):
+		cmd := exec.Command("secret-tool", "store", "--label", keyringService+" "+name, "service", keyringService, "account", name)
+		cmd.Stdin = strings.NewReader(value)
+		cmd.Stderr = os.Stderr
+		return cmd.Run()
+	}
+	return errNoKeyring
+}
+
+// securityCommand is a line for security -i, with each argument quoted.
+func securityCommand(args ...string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+	}
+	return strings.Join(quoted, " ") + "\n"
+}
+
+func keyringGet(name string) (string, error) {
+	var cmd *exec.Cmd
+	switch {
+	case runtime.GOOS == "windows":
+		return credGet(name)
+	case runtime.GOOS == "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", keyringService, "-a", name, "-w")
+	case hasCommand("secret-tool"):
+		cmd = exec.Command("secret-tool", "lookup", "service", keyringService, "account", name)
+	default:
+		return "", errNoKeyring
+	}
+
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		return "", err
+	}
+	return strings.TrimRight(stdout.String(), "\r\n"), nil
+}
+
+func keyringDelete(name string) error {
+	switch {
+	case runtime.GOOS == "windows":
+		return credDelete(name)
+	case runtime.GOOS == "darwin":
+		return exec.Command("security", "delete-generic-password", "-s", keyringService, "-a", name).Run()
+	case hasCommand("secret-tool"):
+		return exec.Command("secret-tool", "clear", "service", keyringService, "account", name).Run()
+	}
+	return errNoKeyring
+}
+
+func hasCommand(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
+}
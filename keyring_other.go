@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+// The Windows Credential Manager is only available on Windows.
+
+func credSet(name, value string) error {
+	return errNoKeyring
+}
+
+func credGet(name string) (string, error) {
+	return "", errNoKeyring
+}
+
+func credDelete(name string) error {
+	return errNoKeyring
+}
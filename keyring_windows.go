@@ -0,0 +1,81 @@
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// The Windows Credential Manager, through advapi32.
+//
+// https://learn.microsoft.com/en-us/windows/win32/api/wincred/
+var (
+	advapi32       = syscall.NewLazyDLL("advapi32.dll")
+	procCredWrite  = advapi32.NewProc("CredWriteW")
+	procCredRead   = advapi32.NewProc("CredReadW")
+	procCredDelete = advapi32.NewProc("CredDeleteW")
+	procCredFree   = advapi32.NewProc("CredFree")
+)
+
+const (
+	credTypeGeneric         = 1
+	credPersistLocalMachine = 2
+)
+
+// https://learn.microsoft.com/en-us/windows/win32/api/wincred/ns-wincred-credentialw
+type winCredential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+func credTarget(name string) *uint16 {
+	target, _ := syscall.UTF16PtrFromString(keyringService + ":" + name)
+	return target
+}
+
+func credSet(name, value string) error {
+	blob := []byte(value)
+	cred := winCredential{
+		Type:               credTypeGeneric,
+		TargetName:         credTarget(name),
+		CredentialBlobSize: uint32(len(blob)),
+		Persist:            credPersistLocalMachine,
+	}
+	if len(blob) > 0 {
+		cred.CredentialBlob = &blob[0]
+	}
+	if ok, _, err := procCredWrite.Call(uintptr(unsafe.Pointer(&cred)), 0); ok == 0 {
+		return err
+	}
+	return nil
+}
+
+func credGet(name string) (string, error) {
+	var cred *winCredential
+	if ok, _, err := procCredRead.Call(uintptr(unsafe.Pointer(credTarget(name))), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred))); ok == 0 {
+		return "", err
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+
+	if cred.CredentialBlobSize == 0 {
+		return "", nil
+	}
+	blob := (*[1 << 20]byte)(unsafe.Pointer(cred.CredentialBlob))[:cred.CredentialBlobSize:cred.CredentialBlobSize]
+	return string(blob), nil
+}
+
+func credDelete(name string) error {
+	if ok, _, err := procCredDelete.Call(uintptr(unsafe.Pointer(credTarget(name))), credTypeGeneric, 0); ok == 0 {
+		return err
+	}
+	return nil
+}
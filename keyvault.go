@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// keyVaultScope is the OAuth scope for Azure Key Vault's data plane.
+const keyVaultScope = "https://vault.azure.net/.default"
+
+// keyVaultSecret fetches a secret from Azure Key Vault, given as
+// vault/secret (or vault/secret/version), with the latest version by default.
+//
+// https://learn.microsoft.com/en-us/rest/api/keyvault/secrets/get-secret/get-secret
+func keyVaultSecret(ctx context.Context, id string) (string, error) {
+	parts := strings.SplitN(id, "/", 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return "", fmt.Errorf("Invalid Key Vault secret %q, expected vault/secret", id)
+	}
+	version := ""
+	if len(parts) == 3 {
+		version = parts[2]
+	}
+
+	token, err := azureToken(ctx, keyVaultScope)
+	if err != nil {
+		return "", err
+	}
+
+	reqURL := fmt.Sprintf("https://%s.vault.azure.net/secrets/%s/%s?api-version=7.4", parts[0], url.PathEscape(parts[1]), url.PathEscape(version))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	var resp struct {
+		Value string `json:"value"`
+	}
+	err = azureDo(req, &resp)
+	return resp.Value, err
+}
+
+// azureToken authenticates to Azure AD with AZURE_ACCESS_TOKEN if set (e.g.
+// from `az account get-access-token --resource https://vault.azure.net`), or
+// else as the service principal AZURE_CLIENT_ID of AZURE_TENANT_ID, with
+// AZURE_CLIENT_SECRET.
+//
+// https://learn.microsoft.com/en-us/entra/identity-platform/v2-oauth2-client-creds-grant-flow
+func azureToken(ctx context.Context, scope string) (string, error) {
+	if token := secret("AZURE_ACCESS_TOKEN"); token != "" {
+		return token, nil
+	}
+
+	tenantID, clientID := os.Getenv("AZURE_TENANT_ID"), os.Getenv("AZURE_CLIENT_ID")
+	clientSecret := secret("AZURE_CLIENT_SECRET")
+	if tenantID == "" || clientID == "" || clientSecret == "" {
+		return "", errors.New("Missing Azure credentials: set AZURE_ACCESS_TOKEN, or AZURE_TENANT_ID, AZURE_CLIENT_ID and AZURE_CLIENT_SECRET")
+	}
+
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {clientID},
+		"client_secret": {clientSecret},
+		"scope":         {scope},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://login.microsoftonline.com/"+url.PathEscape(tenantID)+"/oauth2/v2.0/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var resp struct {
+		AccessToken string `json:"access_token"`
+	}
+	err = azureDo(req, &resp)
+	return resp.AccessToken, err
+}
+
+func azureDo(req *http.Request, out interface{}) error {
+	resp, err := httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s %s failed: %d, %s", req.Method, req.URL.Host+req.URL.Path, resp.StatusCode, string(body))
+	}
+	return json.Unmarshal(body, out)
+}
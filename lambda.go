@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lambdaBuild is set when built with -tags lambda, to run as a Lambda
+// function without FLC_LAMBDA.
+var lambdaBuild bool
+
+// lambdaMode tells whether to serve Lambda invocations: when run with no
+// arguments (as a custom runtime's bootstrap is), in a lambda build or with
+// FLC_LAMBDA set.
+func lambdaMode() bool {
+	return len(os.Args) == 1 && (lambdaBuild || os.Getenv("FLC_LAMBDA") != "")
+}
+
+// lambdaEvent is the input of an invocation, e.g. the constant input of an
+// EventBridge schedule: {"command": "rotate-creds", "args": ["-serviceID", ...]},
+// or a step of a Secrets Manager rotation, run with rotate-secret.
+type lambdaEvent struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+
+	SecretID           string `json:"SecretId"`
+	ClientRequestToken string `json:"ClientRequestToken"`
+	Step               string `json:"Step"`
+}
+
+type lambdaResult struct {
+	Command  string `json:"command"`
+	ExitCode int    `json:"exit_code"`
+	Output   string `json:"output"`
+}
+
+// maxLambdaOutput is how much of a command's output is returned, from the
+// end, well within Lambda's 6MB response limit.
+const maxLambdaOutput = 256 * 1024
+
+// serveLambda serves invocations from the Lambda Runtime API until the
+// function is shut down. Each runs its command in a child process, so that
+// one invocation's flags and failures can't affect the next. Only /tmp is
+// writable on Lambda, so FLC_STATE_FILE should be an s3:// state file.
+//
+// https://docs.aws.amazon.com/lambda/latest/dg/runtimes-api.html
+func serveLambda() {
+	api := os.Getenv("AWS_LAMBDA_RUNTIME_API")
+	checkArg("AWS_LAMBDA_RUNTIME_API", api)
+	base := "http://" + api + "/2018-06-01/runtime"
+	// Long polling for the next invocation mustn't time out.
+	client := &http.Client{}
+
+	self, err := os.Executable()
+	if err != nil {
+		lambdaPost(client, base+"/init/error", lambdaError(err))
+		check(err)
+	}
+
+	for {
+		resp, err := client.Get(base + "/invocation/next")
+		body, err := readResponse(resp, err)
+		check(err)
+		id := resp.Header.Get("Lambda-Runtime-Aws-Request-Id")
+
+		ctx, cancel := context.Background(), func() {}
+		if ms, err := strconv.ParseInt(resp.Header.Get("Lambda-Runtime-Deadline-Ms"), 10, 64); err == nil {
+			ctx, cancel = context.WithDeadline(ctx, time.Unix(0, ms*int64(time.Millisecond)))
+		}
+		result, err := runLambdaEvent(ctx, self, body)
+		cancel()
+
+		if err != nil {
+			lambdaPost(client, base+"/invocation/"+id+"/error", lambdaError(err))
+			continue
+		}
+		lambdaPost(client, base+"/invocation/"+id+"/response", result)
+	}
+}
+
+// runLambdaEvent runs the event's command, failing the invocation unless it
+// succeeds.
+func runLambdaEvent(ctx context.Context, self string, body []byte) (lambdaResult, error) {
+	var e lambdaEvent
+	if err := json.Unmarshal(body, &e); err != nil {
+		return lambdaResult{}, fmt.Errorf("Invalid event, expected {\"command\": ..., \"args\": [...]}: %s", err.Error())
+	}
+	if e.Step != "" {
+		e.Command, e.Args = "rotate-secret", []string{"-secretId", e.SecretID, "-token", e.ClientRequestToken, "-step", e.Step}
+	} else if e.Command == "" {
+		e.Command = "rotate-creds"
+	}
+	if e.Command == "daemon" || e.Command == "login" {
+		return lambdaResult{}, fmt.Errorf("%s can't be run as a Lambda function", e.Command)
+	}
+
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, self, append([]string{e.Command}, e.Args...)...)
+	cmd.Stdout, cmd.Stderr = &out, &out
+	err := cmd.Run()
+	fmt.Print(out.String()) // to CloudWatch Logs
+
+	output := out.String()
+	if len(output) > maxLambdaOutput {
+		output = output[len(output)-maxLambdaOutput:]
+	}
+	result := lambdaResult{Command: e.Command, ExitCode: cmd.ProcessState.ExitCode(), Output: output}
+	if err != nil {
+		lines := strings.Split(strings.TrimSpace(output), "\n")
+		return result, fmt.Errorf("%s failed (%s): %s", e.Command, err.Error(), lines[len(lines)-1])
+	}
+	return result, nil
+}
+
+func lambdaError(err error) map[string]string {
+	return map[string]string{"errorMessage": err.Error(), "errorType": "CommandFailed"}
+}
+
+// lambdaPost reports to the Runtime API. If it can't be reached, the
+// function is broken, so give up and let Lambda replace it.
+func lambdaPost(client *http.Client, url string, v interface{}) {
+	body, err := json.Marshal(v)
+	check(err)
+	_, err = readResponse(client.Post(url, "application/json", bytes.NewReader(body)))
+	check(err)
+}
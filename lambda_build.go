@@ -0,0 +1,8 @@
+//go:build lambda
+// +build lambda
+
+package main
+
+func init() {
+	lambdaBuild = true
+}
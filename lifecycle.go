@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_PutBucketLifecycleConfiguration.html
+type lifecycleRule struct {
+	XMLName    xml.Name             `xml:"Rule"`
+	ID         string               `xml:"ID"`
+	Prefix     string               `xml:"Filter>Prefix"`
+	Status     string               `xml:"Status"`
+	Transition *lifecycleTransition `xml:"Transition,omitempty"`
+	Expiration *lifecycleExpiration `xml:"Expiration,omitempty"`
+}
+
+type lifecycleTransition struct {
+	Days         int    `xml:"Days"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type lifecycleExpiration struct {
+	Days int `xml:"Days"`
+}
+
+// rawLifecycleRule carries other people's rules through untouched.
+type rawLifecycleRule struct {
+	XMLName xml.Name `xml:"Rule"`
+	Inner   string   `xml:",innerxml"`
+}
+
+// lifecycle sets up log retention on the prefix a logging configuration
+// writes to, alongside (and without disturbing) any other lifecycle rules on
+// the bucket.
+func lifecycle(args []string) {
+	fs := flag.NewFlagSet("lifecycle", flag.ExitOnError)
+	serviceID := fs.String("serviceID", "", "A Fastly Service ID.")
+	loggingName := fs.String("loggingName", "", "Name of your service logging configuration in Fastly.")
+	expireDays := fs.Int("expireDays", 0, "Delete log files this many days after delivery (0 to keep forever).")
+	transitionDays := fs.Int("transitionDays", 0, "Move log files to -storageClass this many days after delivery (0 to disable).")
+	storageClass := fs.String("storageClass", "GLACIER", "Storage class to transition log files to.")
+	prefix := fs.String("prefix", "", "Prefix of the log files to set the rule on (default the logging path up to its first % escape).")
+	commonFlags(fs)
+
+	fs.Usage = commandUsage(fs, "Note, FASTLY_KEY and AWS credentials able to manage the bucket's lifecycle configuration must be provided as env vars.")
+	parseFlags(fs, args)
+	refuseDryRun("lifecycle")
+
+	creds := awsEnvCredentials()
+
+	checkArg("serviceID", *serviceID)
+	checkArg("loggingName", *loggingName)
+	checkArg("AWS_ACCESS_KEY_ID", creds.accessKey)
+	if *expireDays == 0 && *transitionDays == 0 {
+		checkArg("expireDays or transitionDays", "")
+	}
+
+	f := fastlyFor(*serviceID)
+	ctx := context.Background()
+
+	active, err := f.activeVersion(ctx, *serviceID)
+	check(err)
+
+	l, err := f.s3Logging(ctx, *serviceID, active, *loggingName)
+	check(err)
+
+	if *prefix == "" {
+		*prefix = lifecyclePrefix(l.Path)
+	}
+	*prefix = strings.TrimPrefix(*prefix, "/")
+	if *prefix == "" {
+		// A rule without a prefix applies to every object in the bucket.
+		check(fmt.Errorf("The path of %s (%q) has no fixed directory to limit the rule to, and it won't be set on the whole bucket: give the -prefix of the log files", *loggingName, l.Path))
+	}
+
+	rule := lifecycleRule{
+		ID:     fmt.Sprintf("fastly-logging-creds-%s-%s", *serviceID, *loggingName),
+		Prefix: *prefix,
+		Status: "Enabled",
+	}
+	if *transitionDays > 0 {
+		rule.Transition = &lifecycleTransition{Days: *transitionDays, StorageClass: *storageClass}
+	}
+	if *expireDays > 0 {
+		rule.Expiration = &lifecycleExpiration{Days: *expireDays}
+	}
+
+	_, region, err := headBucket(ctx, l.Domain, l.BucketName)
+	check(err)
+
+	check(newAWSClient(creds, region).putLifecycleRule(ctx, l.Domain, l.BucketName, rule))
+	fmt.Printf("Set lifecycle rule %s on s3://%s/%s\n", rule.ID, l.BucketName, rule.Prefix)
+}
+
+// lifecyclePrefix is the fixed part of a logging path, up to the first
+// strftime escape. It is blank for a path with no directory before one.
+func lifecyclePrefix(path string) string {
+	prefix := strings.TrimPrefix(path, "/")
+	if i := strings.Index(prefix, "%"); i >= 0 {
+		prefix = prefix[:strings.LastIndex(prefix[:i], "/")+1]
+	}
+	return prefix
+}
+
+// putLifecycleRule adds or replaces rule (by ID) in the bucket's lifecycle
+// configuration, which S3 only allows to be written as a whole.
+func (a *awsClient) putLifecycleRule(ctx context.Context, domain, bucket string, rule lifecycleRule) error {
+	req := awsRequest{
+		service: "s3",
+		method:  http.MethodGet,
+		host:    s3Host(domain, a.region),
+		path:    "/" + bucket,
+		query:   url.Values{"lifecycle": {""}},
+	}
+
+	var existing struct {
+		Rules []struct {
+			ID    string `xml:"ID"`
+			Inner string `xml:",innerxml"`
+		} `xml:"Rule"`
+	}
+	body, _, err := a.do(ctx, "s3:GetBucketLifecycleConfiguration", req)
+	if err != nil && !isAWSStatus(err, http.StatusNotFound) {
+		return err
+	}
+	if err == nil {
+		if err := xml.Unmarshal(body, &existing); err != nil {
+			return err
+		}
+	}
+
+	config := struct {
+		XMLName xml.Name      `xml:"http://s3.amazonaws.com/doc/2006-03-01/ LifecycleConfiguration"`
+		Rules   []interface{} `xml:"Rule"`
+	}{}
+	for _, r := range existing.Rules {
+		if r.ID != rule.ID {
+			config.Rules = append(config.Rules, rawLifecycleRule{Inner: r.Inner})
+		}
+	}
+	config.Rules = append(config.Rules, rule)
+
+	req.method = http.MethodPut
+	req.body, err = xml.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	sum := md5.Sum(req.body)
+	req.header = http.Header{
+		"Content-Type": {"application/xml"},
+		"Content-Md5":  {base64.StdEncoding.EncodeToString(sum[:])},
+	}
+
+	_, _, err = a.do(ctx, "s3:PutBucketLifecycleConfiguration", req)
+	return err
+}
@@ -0,0 +1,18 @@
+package main
+
+import "testing"
+
+func TestLifecyclePrefix(t *testing.T) {
+	for path, want := range map[string]string{
+		"/fastly/logs/":       "fastly/logs/",
+		"/fastly/%Y/%m/":      "fastly/",
+		"/fastly/logs-%Y/%m/": "fastly/",
+		// Blank prefixes, which lifecycle refuses to set a rule on.
+		"/%Y/%m/": "",
+		"":        "",
+	} {
+		if got := lifecyclePrefix(path); got != want {
+			t.Errorf("lifecyclePrefix(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// flexInt decodes numbers that the Fastly API sometimes returns as strings.
+type flexInt int
+
+func (n *flexInt) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		var i int
+		if err := json.Unmarshal(b, &i); err != nil {
+			return err
+		}
+		*n = flexInt(i)
+		return nil
+	}
+
+	if s == "" {
+		*n = 0
+		return nil
+	}
+	i, err := strconv.Atoi(s)
+	*n = flexInt(i)
+	return err
+}
+
+// https://developer.fastly.com/reference/api/logging/s3/
+type s3Logging struct {
+	Name             string  `json:"name"`
+	BucketName       string  `json:"bucket_name"`
+	Domain           string  `json:"domain"`
+	Path             string  `json:"path"`
+	AccessKey        string  `json:"access_key"`
+	SecretKey        string  `json:"secret_key"`
+	IAMRole          string  `json:"iam_role"`
+	Period           flexInt `json:"period"`
+	GzipLevel        flexInt `json:"gzip_level"`
+	CompressionCodec string  `json:"compression_codec"`
+	Format           string  `json:"format"`
+	FormatVersion    flexInt `json:"format_version"`
+	MessageType      string  `json:"message_type"`
+	ACL              string  `json:"acl"`
+	SSE              string  `json:"server_side_encryption"`
+	SSEKMSKeyID      string  `json:"server_side_encryption_kms_key_id"`
+	UpdatedAt        string  `json:"updated_at"`
+}
+
+func (f *fastlyClient) s3Loggings(ctx context.Context, serviceID string, version int) ([]s3Logging, error) {
+	var loggings []s3Logging
+	err := f.do(ctx, http.MethodGet, fmt.Sprintf("/service/%s/version/%d/logging/s3", serviceID, version), nil, &loggings)
+	return loggings, err
+}
+
+func (f *fastlyClient) s3Logging(ctx context.Context, serviceID string, version int, name string) (s3Logging, error) {
+	var l s3Logging
+	err := f.do(ctx, http.MethodGet, fmt.Sprintf("/service/%s/version/%d/logging/s3/%s", serviceID, version, name), nil, &l)
+	return l, err
+}
+
+// loggingConfig fetches every logging configuration of a version, by type.
+// It is canonical, so that two can be diffed meaningfully: configurations are
+// sorted by name, fields that vary by version are dropped, and credentials
+// are masked with a stable fingerprint.
+func (f *fastlyClient) loggingConfig(ctx context.Context, serviceID string, version int) (map[string][]map[string]interface{}, error) {
+	config := map[string][]map[string]interface{}{}
+	for _, t := range loggingTypes {
+		loggings, err := f.listableLoggings(ctx, serviceID, version, t)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range loggings {
+			redactSecrets(t, l)
+			for _, field := range volatileFields {
+				delete(l, field)
+			}
+		}
+		sort.Slice(loggings, func(i, j int) bool {
+			return fmt.Sprint(loggings[i]["name"]) < fmt.Sprint(loggings[j]["name"])
+		})
+		config[t] = loggings
+	}
+	return config, nil
+}
+
+// volatileFields differ between versions (and services) with identical
+// logging configuration.
+var volatileFields = []string{"service_id", "version", "created_at", "updated_at", "deleted_at"}
+
+func redactSecrets(typ string, l map[string]interface{}) {
+	for field, value := range l {
+		if v, ok := value.(string); ok && v != "" && isSecretField(typ, field) {
+			l[field] = maskSecret(v)
+		}
+	}
+}
+
+// maskSecret hides a secret, leaving enough to tell whether two are the same:
+// its last four characters and a fingerprint.
+func maskSecret(s string) string {
+	last4 := ""
+	if len(s) > 8 {
+		last4 = s[len(s)-4:]
+	}
+	return fmt.Sprintf("****%s (%s)", last4, fingerprint(s))
+}
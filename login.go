@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// https://developer.fastly.com/reference/api/auth-tokens/user/#create-token
+const newTokenURL = "https://manage.fastly.com/account/personal/tokens/new"
+
+// login obtains a short-lived Fastly API token for the operator and stores it
+// in the OS keyring as FASTLY_KEY, so that personal tokens don't need to be
+// long-lived or kept in env vars.
+func login(args []string) {
+	fs := flag.NewFlagSet("login", flag.ExitOnError)
+	sso := fs.Bool("sso", false, "Create the token in the browser (for accounts that sign in with SSO), then paste it here.")
+	username := fs.String("username", "", "Fastly login (email address), when not using -sso.")
+	expiresIn := fs.Duration("expiresIn", 12*time.Hour, "How long the token is valid for.")
+	scope := fs.String("scope", "global", "Scope of the token.")
+	commonFlags(fs)
+
+	fs.Usage = commandUsage(fs, "The token is stored in the macOS keychain, with secret-tool on Linux, or in the Windows Credential Manager, and used whenever FASTLY_KEY isn't set.")
+	parseFlags(fs, args)
+	refuseDryRun("login")
+
+	in := bufio.NewReader(os.Stdin)
+	ctx := context.Background()
+
+	var token string
+	if *sso {
+		fmt.Fprintf(os.Stderr, "Create a token with the %s scope, expiring in %s, at:\n\n  %s\n\n", *scope, *expiresIn, newTokenURL)
+		openBrowser(newTokenURL)
+		token = prompt(in, "Paste the token: ", true)
+	} else {
+		if *username == "" {
+			*username = prompt(in, "Fastly login: ", false)
+		}
+		password := prompt(in, "Password: ", true)
+		otp := prompt(in, "2FA code (blank if not enabled): ", false)
+
+		var err error
+		token, err = createUserToken(ctx, *username, password, otp, url.Values{
+			"name":       {tokenName("login")},
+			"scope":      {*scope},
+			"expires_at": {time.Now().Add(*expiresIn).UTC().Format(time.RFC3339)},
+		})
+		check(err)
+	}
+	checkArg("token", token)
+
+	t, err := newFastlyClient(token).tokenSelf(ctx)
+	check(err)
+	if *sso {
+		// The token was made by hand, so hold it to -expiresIn: login is
+		// for short-lived tokens.
+		expires, err := time.Parse(time.RFC3339, t.ExpiresAt)
+		if t.ExpiresAt == "" || err != nil {
+			check(fmt.Errorf("Token %s doesn't expire: create one expiring in %s or less", t.ID, *expiresIn))
+		}
+		if expires.After(time.Now().Add(*expiresIn)) {
+			check(fmt.Errorf("Token %s expires at %s, later than -expiresIn %s from now: create one expiring sooner", t.ID, t.ExpiresAt, *expiresIn))
+		}
+	}
+
+	check(keyringSet("FASTLY_KEY", token))
+	expiry := "never"
+	if t.ExpiresAt != "" {
+		expiry = t.ExpiresAt
+	}
+	fmt.Fprintf(messages(), "Stored token %s (scope %s, expires %s) in the keyring as FASTLY_KEY.\n", t.ID, t.Scope, expiry)
+}
+
+// logout revokes the token stored by login, and removes it from the keyring.
+func logout(args []string) {
+	fs := flag.NewFlagSet("logout", flag.ExitOnError)
+	keepToken := fs.Bool("keepToken", false, "Only remove the token from the keyring, without revoking it.")
+	commonFlags(fs)
+
+	fs.Usage = commandUsage(fs, "Tokens in FASTLY_KEY or elsewhere are left alone.")
+	parseFlags(fs, args)
+	refuseDryRun("logout")
+
+	token, err := keyringGet("FASTLY_KEY")
+	if err != nil || token == "" {
+		check(errors.New("Not logged in: there's no FASTLY_KEY in the keyring"))
+	}
+
+	// A token that has already expired or been revoked needn't be revoked.
+	f := newFastlyClient(token)
+	ctx := context.Background()
+	if _, err := f.tokenSelf(ctx); err == nil && !*keepToken {
+		if err := f.revokeTokenSelf(ctx); err != nil {
+			check(fmt.Errorf("Unable to revoke the token, so leaving it in the keyring (use -keepToken to remove it anyway): %s", err.Error()))
+		}
+		fmt.Fprintln(messages(), "Revoked the token.")
+	}
+
+	check(keyringDelete("FASTLY_KEY"))
+	fmt.Fprintln(messages(), "Removed FASTLY_KEY from the keyring.")
+}
+
+// createUserToken creates a token with a user's credentials, which (unlike
+// most of the API) are given in place of an existing token.
+func createUserToken(ctx context.Context, username, password, otp string, params url.Values) (string, error) {
+	params.Set("username", username)
+	params.Set("password", password)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://"+fastlyAPIHost+"/tokens", strings.NewReader(params.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if otp != "" {
+		req.Header.Set("Fastly-OTP", otp)
+	}
+
+	resp, err := httpClient().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Unable to create token: %d, %s", resp.StatusCode, string(body))
+	}
+
+	var created struct {
+		AccessToken string `json:"access_token"`
+	}
+	return created.AccessToken, json.Unmarshal(body, &created)
+}
+
+// tokenName names tokens created by this tool after their purpose and host.
+func tokenName(purpose string) string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("fastly-logging-creds %s (%s)", purpose, host)
+}
+
+// prompt reads a line from the terminal, without echoing it if secret.
+func prompt(in *bufio.Reader, question string, secret bool) string {
+	fmt.Fprint(os.Stderr, question)
+	if secret && stty("-echo") == nil {
+		defer func() {
+			stty("echo")
+			fmt.Fprintln(os.Stderr)
+		}()
+	}
+	line, _ := in.ReadString('\n')
+	return strings.TrimSpace(line)
+}
+
+func stty(arg string) error {
+	cmd := exec.Command("stty", arg)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
+
+func openBrowser(u string) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", u)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", u)
+	default:
+		cmd = exec.Command("xdg-open", u)
+	}
+	cmd.Start()
+}
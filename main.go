@@ -1,66 +1,175 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
-	"net/http"
-	"net/url"
 	"os"
 	"strings"
 )
 
-// https://developer.fastly.com/reference/api/logging/s3/
-func main() {
-
-	serviceID := flag.String("serviceID", "", "A Fastly Service ID.")
-	loggingName := flag.String("loggingName", "", "Name of your service logging configuration in Fastly.")
-	awsAccessKey := flag.String("awsAccessKey", "", "AWS Access Key for S3 write access to the target bucket.")
-
-	flag.Usage = usage // customise help/error messages
-	flag.Parse()
+type command struct {
+	name    string
+	summary string
+	run     func(args []string)
+}
 
-	awsSecretKey := os.Getenv("AWS_SECRET_KEY")
-	fastlyKey := os.Getenv("FASTLY_KEY")
+// commandName is the command being run.
+var commandName string
 
-	checkArg("serviceID", *serviceID)
-	checkArg("loggingName", *loggingName)
-	checkArg("awsAccessKey", *awsAccessKey)
-	checkArg("AWS_SECRET_KEY", awsSecretKey)
-	checkArg("FASTLY_KEY", fastlyKey)
+var commands = []command{
+	{"rotate-creds", "Update the AWS credentials of an S3 logging configuration (default).", rotateCreds},
+	{"rotate-key", "Rotate every S3 logging configuration using an access key, across all services.", rotateKey},
+	{"plan", "Write a signed bundle of logging changes to a service, for review.", plan},
+	{"apply", "Apply an approved change bundle created by plan, in a single new version.", apply},
+	{"describe", "Print the logging configuration of a service.", describe},
+	{"search", "Find services by fuzzy matching their names and IDs.", search},
+	{"export", "Print or commit the logging configuration of a service.", export},
+	{"compare", "Diff the logging configuration of two services, e.g. staging and production.", compare},
+	{"promote", "Apply the logging configuration of one service to another, e.g. staging to production.", promote},
+	{"rollback", "Re-activate the version of a service that was active before the current one.", rollback},
+	{"create", "Add a logging configuration of any type to a service.", create},
+	{"delete", "Delete the logging configurations matching a name pattern, across services.", deleteEndpoints},
+	{"login", "Obtain a short-lived Fastly token and store it in the OS keyring.", login},
+	{"logout", "Revoke the Fastly token stored by login and remove it from the OS keyring.", logout},
+	{"create-token", "Create a least-privilege automation token for a scheduled deployment.", createToken},
+	{"healthcheck", "Check connectivity and authentication with Fastly and AWS.", healthcheck},
+	{"version", "Print the version, git commit and build date of this binary.", versionCommand},
+	{"doctor", "Report likely problems with a service's logging configuration.", doctor},
+	{"sla-report", "List logging credentials overdue for rotation across the account.", slaReport},
+	{"audit", "List the access key of every S3 logging configuration, with its IAM creation and last use.", audit},
+	{"history", "Print the rotations recorded in the state file, for audits.", history},
+	{"cleanup-keys", "Delete old access keys left on logging IAM users by past rotations.", cleanupKeys},
+	{"verify-delivery", "Check (or -watch) that an S3 logging configuration is delivering log files.", verifyDelivery},
+	{"check-logs", "Check recently delivered log files decompress and match the configured format.", checkLogs},
+	{"usage-report", "Estimate the log volume and storage cost of S3 logging configurations.", usageReport},
+	{"migrate-format-version", "Upgrade logging configurations from format_version 1 to 2.", migrateFormatVersion},
+	{"migrate-to-iam-role", "Switch S3 or Kinesis logging from access keys to an IAM role for Fastly to assume.", migrateToIAMRole},
+	{"retire-keys", "Retire old access keys left active by rotate-creds -gracePeriod, once the new keys are in use.", retireKeys},
+	{"rotate-secret", "Run a step of a Secrets Manager rotation of an S3 logging access key, as its rotation function.", rotateSecret},
+	{"key-usage", "Report any use of an old access key in CloudTrail since it was rotated away from.", keyUsageCommand},
+	{"daemon", "Run until stopped, making the rotations scheduled in the config file when they fall due.", daemon},
+	{"lifecycle", "Configure S3 retention for the log files of a logging configuration.", lifecycle},
+	{"rotate-gcs-key", "Rotate the service account key of a GCS logging configuration end to end.", rotateGCSKey},
+	{"renew-azure-sas", "Renew the SAS token of an Azure Blob logging configuration.", renewAzureSAS},
+	{"rotate-splunk-token", "Rotate the HEC token of a Splunk logging configuration end to end.", rotateSplunkToken},
+	{"rotate-datadog-key", "Rotate the API key of a Datadog logging configuration end to end.", rotateDatadogKey},
+}
 
-	reqURL := url.URL{
-		Scheme: "https",
-		Host:   "api.fastly.com",
-		Path:   fmt.Sprintf("/service/%s/version/1/logging/s3/%s", *serviceID, *loggingName),
+func main() {
+	if lambdaMode() {
+		serveLambda()
+		return
 	}
 
-	form := url.Values{"access_key": {*awsAccessKey}, "secret_key": {awsSecretKey}}
-	req, err := http.NewRequest(http.MethodPut, reqURL.String(), strings.NewReader(form.Encode()))
-	check(err)
-
-	req.Header.Add("Fastly-Key", fastlyKey)
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Add("Accept", "application/json")
+	name, args := "rotate-creds", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	check(err)
-	defer resp.Body.Close()
+	if name == "help" {
+		if len(args) == 0 {
+			usage()
+			return
+		}
+		// help <command> is <command> -h.
+		name, args = args[0], []string{"-h"}
+	}
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := ioutil.ReadAll(resp.Body)
-		check(fmt.Errorf("Update request failed: %d, %s", resp.StatusCode, string(body)))
+	for _, c := range commands {
+		if c.name == name {
+			commandName = name
+			c.run(args)
+			printStats()
+			return
+		}
 	}
 
+	fmt.Printf("Unknown command '%s'.\n", name)
+	usage()
+	os.Exit(2)
 }
 
 func usage() {
 	fmt.Fprint(flag.CommandLine.Output(), "Usage of fastly-logging-creds:\n")
 	fmt.Fprintln(flag.CommandLine.Output())
-	flag.PrintDefaults()
+	fmt.Fprint(flag.CommandLine.Output(), "  fastly-logging-creds [command] [flags]\n")
+	fmt.Fprintln(flag.CommandLine.Output())
+	width := 0
+	for _, c := range commands {
+		if len(c.name) > width {
+			width = len(c.name)
+		}
+	}
+	for _, c := range commands {
+		fmt.Fprintf(flag.CommandLine.Output(), "  %-*s %s\n", width, c.name, c.summary)
+	}
 	fmt.Fprintln(flag.CommandLine.Output())
-	fmt.Fprint(flag.CommandLine.Output(), "Note, AWS_SECRET_KEY and FASTLY_KEY must be provided as env vars.\n")
+	fmt.Fprint(flag.CommandLine.Output(), "Run 'fastly-logging-creds help <command>' (or '<command> -h') for the flags of a command.\n")
+}
+
+// parseFlags parses a command's flags, taking any that aren't given from
+// their FLC_ env var: -serviceID from FLC_SERVICE_ID, and so on. Flags take
+// precedence over env vars, which take precedence over the config file.
+// Commands with -serviceID also get -serviceName, which is looked up to give
+// it.
+func parseFlags(fs *flag.FlagSet, args []string) {
+	serviceName := serviceNameFlag(fs)
+	fs.Parse(args)
+
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if given[f.Name] {
+			return
+		}
+		if value, ok := os.LookupEnv(flagEnvVar(f.Name)); ok {
+			if err := fs.Set(f.Name, value); err != nil {
+				check(fmt.Errorf("Invalid value for %s: %s", flagEnvVar(f.Name), err.Error()))
+			}
+			given[f.Name] = true
+		}
+	})
+
+	setConfigFlags(fs, given)
+	check(loadSOPSFile())
+	setServiceID(fs, serviceName, given["serviceID"])
+}
+
+// flagEnvVar is the env var for a flag, e.g. FLC_SERVICE_ID for serviceID.
+func flagEnvVar(name string) string {
+	var b strings.Builder
+	b.WriteString("FLC_")
+	for i, c := range name {
+		upper := c >= 'A' && c <= 'Z'
+		if i > 0 && upper {
+			prev := rune(name[i-1])
+			nextLower := i+1 < len(name) && name[i+1] >= 'a' && name[i+1] <= 'z'
+			if prev >= 'a' && prev <= 'z' || prev >= '0' && prev <= '9' || nextLower {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteString(strings.ToUpper(string(c)))
+	}
+	return b.String()
+}
+
+// commandUsage customises the help/error messages of a command's flags.
+func commandUsage(fs *flag.FlagSet, note string) func() {
+	return func() {
+		fmt.Fprintf(fs.Output(), "Usage of fastly-logging-creds %s:\n", fs.Name())
+		fmt.Fprintln(fs.Output())
+		fs.PrintDefaults()
+		fmt.Fprintln(fs.Output())
+		fmt.Fprintln(fs.Output(), "Any flag not given is taken from its FLC_ env var if set, e.g. -serviceID from FLC_SERVICE_ID.")
+		if note != "" {
+			fmt.Fprintln(fs.Output())
+			fmt.Fprintln(fs.Output(), note)
+		}
+	}
 }
 
 func checkArg(name, value string) {
@@ -70,9 +179,32 @@ func checkArg(name, value string) {
 	}
 }
 
+// refuseDryRun stops a -dryRun of something that changes more than Fastly,
+// which -dryRun can't hold back.
+func refuseDryRun(what string) {
+	if dryRun {
+		check(fmt.Errorf("-dryRun can't be used with %s, which makes changes outside Fastly", what))
+	}
+}
+
+// atExit, if set, is run before check exits on an error, e.g. to delete a
+// minted access key that nothing is using.
+var atExit func()
+
 func check(err error) {
+	if err != nil && atExit != nil {
+		cleanup := atExit
+		atExit = nil
+		cleanup()
+	}
+	if errors.Is(err, errDryRun) {
+		fmt.Fprintln(messages(), err.Error())
+		printStats()
+		os.Exit(0)
+	}
 	if err != nil {
-		fmt.Println(err.Error())
+		fmt.Fprintln(messages(), err.Error())
+		printStats()
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestFlagEnvVar(t *testing.T) {
+	for flag, want := range map[string]string{
+		"serviceID":       "FLC_SERVICE_ID",
+		"loggingName":     "FLC_LOGGING_NAME",
+		"dryRun":          "FLC_DRY_RUN",
+		"maxApiCalls":     "FLC_MAX_API_CALLS",
+		"awsAccessKey":    "FLC_AWS_ACCESS_KEY",
+		"cloudTrailCheck": "FLC_CLOUD_TRAIL_CHECK",
+		"newKeyFor":       "FLC_NEW_KEY_FOR",
+		"yes":             "FLC_YES",
+	} {
+		if got := flagEnvVar(flag); got != want {
+			t.Errorf("flagEnvVar(%q) = %q, want %q", flag, got, want)
+		}
+	}
+}
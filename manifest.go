@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// manifest is the exported logging configuration of a service version, with
+// credentials redacted.
+type manifest struct {
+	ServiceID   string                              `json:"service_id"`
+	ServiceName string                              `json:"service_name"`
+	Version     int                                 `json:"version"`
+	Logging     map[string][]map[string]interface{} `json:"logging"`
+}
+
+func exportManifest(ctx context.Context, f *fastlyClient, serviceID string, version int) (manifest, error) {
+	s, err := f.service(ctx, serviceID)
+	if err != nil {
+		return manifest{}, err
+	}
+
+	logging, err := f.loggingConfig(ctx, serviceID, version)
+	return manifest{ServiceID: serviceID, ServiceName: s.Name, Version: version, Logging: logging}, err
+}
+
+// commitManifest writes m to <repo>/<serviceID>.json and commits it, giving a
+// reviewable history of every change made.
+func commitManifest(repo string, m manifest, message string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	name := m.ServiceID + ".json"
+	if err := ioutil.WriteFile(filepath.Join(repo, name), append(data, '\n'), 0644); err != nil {
+		return err
+	}
+
+	if err := git(repo, "add", name); err != nil {
+		return err
+	}
+
+	// Nothing to commit if the configuration is unchanged since last time.
+	if exec.Command("git", "-C", repo, "diff", "--cached", "--quiet", "--", name).Run() == nil {
+		return nil
+	}
+
+	return git(repo, "commit", "-q", "-m", message, "--", name)
+}
+
+func git(repo string, args ...string) error {
+	cmd := exec.Command("git", append([]string{"-C", repo}, args...)...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git %s failed: %s", args[0], err.Error())
+	}
+	return nil
+}
+
+// export prints (or commits) the logging configuration of a service.
+func export(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	serviceID := fs.String("serviceID", "", "A Fastly Service ID.")
+	version := fs.Int("version", 0, "Version to export (default: the active version).")
+	gitRepo := fs.String("gitRepo", "", "Git repository to write the manifest to and commit, rather than printing it.")
+	commonFlags(fs)
+
+	fs.Usage = commandUsage(fs, "Note, FASTLY_KEY must be provided as an env var.")
+	parseFlags(fs, args)
+
+	checkArg("serviceID", *serviceID)
+
+	f := fastlyFor(*serviceID)
+	ctx := context.Background()
+
+	number := *version
+	if number == 0 {
+		active, err := f.activeVersion(ctx, *serviceID)
+		check(err)
+		number = active
+	}
+
+	m, err := exportManifest(ctx, f, *serviceID, number)
+	check(err)
+
+	if *gitRepo != "" {
+		check(commitManifest(*gitRepo, m, fmt.Sprintf("Export logging configuration of %s (%s) version %d", m.ServiceName, m.ServiceID, m.Version)))
+		return
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	check(err)
+	fmt.Println(string(data))
+}
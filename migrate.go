@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// vclNamespaces are the top-level VCL variables that format_version 2
+// format strings can use as they are.
+var vclNamespaces = []string{"req", "resp", "bereq", "beresp", "obj", "client", "server", "fastly", "fastly_info", "geoip", "now", "time", "tls", "math", "std", "digest", "var", "segmented_caching", "workspace", "esi", "stale", "backend", "waf"}
+
+var vclVariable = regexp.MustCompile(`%\{([^}]*)\}V`)
+
+// migrateFormat rewrites a format_version 1 format string for version 2,
+// where request variables need their req. prefix.
+func migrateFormat(format string) string {
+	return vclVariable.ReplaceAllStringFunc(format, func(m string) string {
+		name := vclVariable.FindStringSubmatch(m)[1]
+		namespace := strings.SplitN(name, ".", 2)[0]
+		// Function calls, e.g. json.escape(req.url), are left as they are.
+		if contains(vclNamespaces, namespace) || strings.Contains(name, "(") {
+			return m
+		}
+		return "%{req." + name + "}V"
+	})
+}
+
+// migrateFormatVersion upgrades logging configurations still on
+// format_version 1 to version 2, one new version per service.
+func migrateFormatVersion(args []string) {
+	fs := flag.NewFlagSet("migrate-format-version", flag.ExitOnError)
+	var serviceIDs listFlag
+	fs.Var(&serviceIDs, "serviceID", "A Fastly Service ID. Can be repeated.")
+	allServices := fs.Bool("allServices", false, "Migrate every service on the account, rather than just -serviceID.")
+	yes := fs.Bool("yes", false, "Make the changes without asking for confirmation.")
+	tagFlags(fs)
+	checkpointFlags(fs)
+	draftFlags(fs)
+	notifyFlags(fs)
+	commonFlags(fs)
+
+	fs.Usage = commandUsage(fs, "Note, FASTLY_KEY (or the tokens of each account in the config file) must be provided as env vars.")
+	parseFlags(fs, args)
+
+	if !*allServices && len(serviceIDs) == 0 {
+		checkArg("serviceID", "")
+	}
+
+	ctx := context.Background()
+	var services []accountService
+	if *allServices {
+		services = servicesFor(ctx, "")
+	}
+	for _, id := range serviceIDs {
+		services = append(services, servicesFor(ctx, id)...)
+	}
+
+	// A migration's fields are exported for -checkpoint to record.
+	type migration struct {
+		accountService `json:"-"`
+		Endpoints      []endpointRef `json:"endpoints"`
+		OldFormats     []string      `json:"old_formats"`
+		Formats        []string      `json:"formats"`
+	}
+	cp := loadCheckpoint()
+	var migrations []migration
+	total, done := 0, 0
+	failed := false
+	for _, s := range services {
+		if s.svc.Version == 0 {
+			continue
+		}
+		var o outcome
+		if cp.result(s.svc.ID, &o) {
+			fmt.Fprintf(messages(), "%s: %s (before resuming)\n", s.svc.ID, o.Detail)
+			failed = failed || o.Status == statusFailed
+			done++
+			continue
+		}
+
+		m := migration{accountService: s}
+		if !cp.result("migration:"+s.svc.ID, &m) {
+			for _, t := range loggingTypes {
+				loggings, err := s.f.listableLoggings(ctx, s.svc.ID, s.svc.Version, t)
+				cp.stopIfExhausted(err)
+				check(err)
+
+				for _, l := range loggings {
+					name := fmt.Sprint(l["name"])
+					if fmt.Sprint(l["format_version"]) != "1" || !hasTags(name, selectedTags) {
+						continue
+					}
+					format, _ := l["format"].(string)
+					m.Endpoints = append(m.Endpoints, endpointRef{typ: t, name: name})
+					m.OldFormats = append(m.OldFormats, format)
+					m.Formats = append(m.Formats, migrateFormat(format))
+				}
+			}
+			cp.done("migration:"+s.svc.ID, m)
+		}
+
+		for i, e := range m.Endpoints {
+			fmt.Fprintf(messages(), "%s %s/%s: format_version 1 -> 2\n", s.svc.ID, e.typ, e.name)
+			if m.Formats[i] != m.OldFormats[i] {
+				fmt.Fprintf(messages(), "  - format %s\n  + format %s\n", m.OldFormats[i], m.Formats[i])
+			}
+		}
+		if len(m.Endpoints) > 0 {
+			migrations = append(migrations, m)
+			total += len(m.Endpoints)
+		}
+	}
+
+	if total == 0 {
+		cp.finish()
+		if done > 0 {
+			fmt.Fprintln(messages(), "No more logging configurations are on format_version 1.")
+		} else {
+			fmt.Fprintln(messages(), "No logging configurations are on format_version 1.")
+		}
+		if failed {
+			check(fmt.Errorf("Unable to migrate every service"))
+		}
+		return
+	}
+	if !*yes && !dryRun && !confirm(fmt.Sprintf("Migrate these %d logging configuration(s) on %d service(s)?", total, len(migrations))) {
+		fmt.Fprintln(messages(), "Not migrating anything.")
+		return
+	}
+
+	for _, m := range migrations {
+		f, id := m.f, m.svc.ID
+		number, err := withDraft(ctx, f, id, func(number int) error {
+			for i, e := range m.Endpoints {
+				params := url.Values{"format_version": {"2"}, "format": {m.Formats[i]}}
+				if err := f.updateLogging(ctx, id, number, e.typ, e.name, params); err != nil {
+					return err
+				}
+			}
+			return f.setVersionComment(ctx, id, number, versionComment("Migrated logging to format_version 2"))
+		})
+		if errors.Is(err, errDryRun) {
+			// The calls it would make have been printed.
+			continue
+		}
+		if err != nil {
+			cp.stopIfExhausted(err)
+			o := outcome{ServiceID: id, Status: statusFailed, Detail: err.Error()}
+			cp.done(id, o)
+			notify(o)
+			fmt.Fprintf(messages(), "%s: %s\n", id, err.Error())
+			failed = true
+			continue
+		}
+
+		msg := fmt.Sprintf("Activated version %d of service %s, migrating %d logging configuration(s) to format_version 2.", number, id, len(m.Endpoints))
+		o := outcome{ServiceID: id, Status: statusRotated, Detail: msg}
+		cp.done(id, o)
+		notify(o)
+		fmt.Fprintln(messages(), msg)
+	}
+	if dryRun {
+		fmt.Fprintf(messages(), "Would migrate %d logging configuration(s) on %d service(s).\n", total, len(migrations))
+	}
+	flushNotifications()
+	cp.finish()
+
+	if failed {
+		check(fmt.Errorf("Unable to migrate every service"))
+	}
+}
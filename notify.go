@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	statusRotated = "rotated"
+	statusSkipped = "skipped"
+	statusFailed  = "failed"
+	// statusUnverified is a rotation that was activated, but whose logs
+	// weren't seen delivered. The new credentials are live, so it mustn't
+	// be cleaned up like a failure.
+	statusUnverified = "unverified"
+)
+
+// outcome is the result of running a command against one service.
+type outcome struct {
+	ServiceID string `json:"service_id"`
+	Status    string `json:"status"`
+	Detail    string `json:"detail"`
+	// activatedAt is when the change was activated, for checking the logs
+	// delivered since.
+	activatedAt time.Time
+	// rotated is the S3 logging configuration rotated, for verifying its
+	// delivery once every service is rotated.
+	rotated *s3Logging
+}
+
+// failedOutcome is the outcome of a change to a service that returned err,
+// which in a -dryRun is only a skip.
+func failedOutcome(serviceID string, err error) outcome {
+	if errors.Is(err, errDryRun) {
+		return outcome{ServiceID: serviceID, Status: statusSkipped, Detail: err.Error()}
+	}
+	return outcome{ServiceID: serviceID, Status: statusFailed, Detail: err.Error()}
+}
+
+var (
+	notifyURLs   listFlag
+	notifyDigest bool
+	outcomes     []outcome
+)
+
+func notifyFlags(fs *flag.FlagSet) {
+	fs.Var(&notifyURLs, "notify", "Slack-compatible incoming webhook URL to notify of results. Can be repeated.")
+	fs.BoolVar(&jsonLines, "jsonLines", false, "Stream the outcome for each service to stdout as a line of JSON.")
+	fs.BoolVar(&notifyDigest, "notifyDigest", false, "Send a single summary of all services at the end of the run, rather than a notification per service.")
+}
+
+// notify records the outcome for a service, notifying the -notify channels
+// straight away unless they're getting a digest.
+func notify(o outcome) {
+	outcomes = append(outcomes, o)
+	if jsonLines {
+		emitJSONLine(o)
+	}
+	if !notifyDigest {
+		sendNotification(fmt.Sprintf("fastly-logging-creds %s: service %s %s: %s", commandName, o.ServiceID, o.Status, o.Detail))
+	}
+}
+
+// flushNotifications sends the digest of the run, if one was asked for.
+func flushNotifications() {
+	if !notifyDigest || len(outcomes) == 0 {
+		return
+	}
+
+	counts := map[string]int{}
+	var failures []string
+	for _, o := range outcomes {
+		counts[o.Status]++
+		if o.Status == statusFailed || o.Status == statusUnverified {
+			failures = append(failures, fmt.Sprintf("• %s: %s", o.ServiceID, o.Detail))
+		}
+	}
+
+	msg := fmt.Sprintf("fastly-logging-creds %s: rotated: %d, skipped: %d, failed: %d",
+		commandName, counts[statusRotated], counts[statusSkipped], counts[statusFailed])
+	if counts[statusUnverified] > 0 {
+		msg += fmt.Sprintf(", rotated but delivery unverified: %d", counts[statusUnverified])
+	}
+	if len(failures) > 0 {
+		msg += "\n" + strings.Join(failures, "\n")
+	}
+	sendNotification(msg)
+}
+
+// sendNotification posts msg to each channel. Notifications are best effort:
+// failing to send one shouldn't fail the run it's reporting on. Nothing is
+// sent in a -dryRun.
+func sendNotification(msg string) {
+	if dryRun {
+		return
+	}
+	payload, err := json.Marshal(map[string]string{"text": msg})
+	if err != nil {
+		return
+	}
+
+	for _, u := range notifyURLs {
+		resp, err := httpClient().Post(u, "application/json", bytes.NewReader(payload))
+		if err == nil {
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode < 200 || resp.StatusCode > 299 {
+				err = fmt.Errorf("%d, %s", resp.StatusCode, string(body))
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(messages(), "Unable to send notification to %s: %s\n", redactURL(u), err.Error())
+		}
+	}
+}
+
+// redactURL hides the path of webhook URLs, which is usually their secret.
+func redactURL(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return "<invalid URL>"
+	}
+	return parsed.Scheme + "://" + parsed.Host + "/..."
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	webIdentityRole     string
+	webIdentityCreds    awsCredentials
+	webIdentityExpiry   time.Time
+	webIdentityCredsMux sync.Mutex
+)
+
+// webIdentityRoleARN is the role to assume with an OIDC token: -webIdentityRole,
+// or else AWS_ROLE_ARN when the AWS SDKs would use it (with
+// AWS_WEB_IDENTITY_TOKEN_FILE).
+func webIdentityRoleARN() string {
+	if webIdentityRole != "" {
+		return webIdentityRole
+	}
+	if os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE") != "" {
+		return os.Getenv("AWS_ROLE_ARN")
+	}
+	return ""
+}
+
+// webIdentityCredentials are credentials for the web identity role, from
+// exchanging the CI job's OIDC token, exchanged again before they expire.
+func webIdentityCredentials() (awsCredentials, error) {
+	webIdentityCredsMux.Lock()
+	defer webIdentityCredsMux.Unlock()
+
+	if time.Until(webIdentityExpiry) > refreshBefore {
+		return webIdentityCreds, nil
+	}
+
+	ctx := context.Background()
+	token, err := oidcToken(ctx)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("Unable to obtain an OIDC token for %s: %s", webIdentityRoleARN(), err.Error())
+	}
+	creds, expiry, err := assumeRoleWithWebIdentity(ctx, webIdentityRoleARN(), token, assumeRoleFor)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("Unable to assume role %s: %s", webIdentityRoleARN(), err.Error())
+	}
+	webIdentityCreds, webIdentityExpiry = creds, expiry
+	return creds, nil
+}
+
+// oidcToken is the CI job's OIDC token: requested from GitHub Actions (which
+// needs the id-token: write permission), or read from
+// AWS_WEB_IDENTITY_TOKEN_FILE, or else the OIDC_TOKEN secret (as a GitLab job
+// gets with id_tokens: OIDC_TOKEN: aud: sts.amazonaws.com).
+//
+// https://docs.github.com/en/actions/deployment/security-hardening-your-deployments/about-security-hardening-with-openid-connect
+// https://docs.gitlab.com/ee/ci/secrets/id_token_authentication.html
+func oidcToken(ctx context.Context) (string, error) {
+	if requestURL := os.Getenv("ACTIONS_ID_TOKEN_REQUEST_URL"); requestURL != "" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL+"&audience=sts.amazonaws.com", nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Authorization", "Bearer "+os.Getenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN"))
+
+		body, err := readResponse(httpClient().Do(req))
+		if err != nil {
+			return "", err
+		}
+		var resp struct {
+			Value string `json:"value"`
+		}
+		err = json.Unmarshal(body, &resp)
+		return resp.Value, err
+	}
+
+	if file := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"); file != "" {
+		token, err := ioutil.ReadFile(file)
+		return strings.TrimSpace(string(token)), err
+	}
+
+	token, err := cachedSecret("OIDC_TOKEN")
+	if err != nil || token != "" {
+		return token, err
+	}
+	return "", errors.New("Not in GitHub Actions, and neither AWS_WEB_IDENTITY_TOKEN_FILE nor OIDC_TOKEN is set")
+}
+
+// assumeRoleWithWebIdentity is the one STS call made without AWS credentials:
+// the OIDC token stands in for them, so the request isn't signed.
+//
+// https://docs.aws.amazon.com/STS/latest/APIReference/API_AssumeRoleWithWebIdentity.html
+func assumeRoleWithWebIdentity(ctx context.Context, roleARN, token string, duration time.Duration) (awsCredentials, time.Time, error) {
+	form := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {roleARN},
+		"RoleSessionName":  {"fastly-logging-creds"},
+		"WebIdentityToken": {token},
+		"DurationSeconds":  {fmt.Sprint(int(duration.Seconds()))},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://sts.amazonaws.com/", strings.NewReader(form.Encode()))
+	if err != nil {
+		return awsCredentials{}, time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+
+	body, err := readResponse(httpClient().Do(req))
+	if err != nil {
+		return awsCredentials{}, time.Time{}, err
+	}
+
+	var resp struct {
+		AccessKeyID     string    `xml:"AssumeRoleWithWebIdentityResult>Credentials>AccessKeyId"`
+		SecretAccessKey string    `xml:"AssumeRoleWithWebIdentityResult>Credentials>SecretAccessKey"`
+		SessionToken    string    `xml:"AssumeRoleWithWebIdentityResult>Credentials>SessionToken"`
+		Expiration      time.Time `xml:"AssumeRoleWithWebIdentityResult>Credentials>Expiration"`
+	}
+	if err := xml.Unmarshal(body, &resp); err != nil {
+		return awsCredentials{}, time.Time{}, err
+	}
+	return awsCredentials{accessKey: resp.AccessKeyID, secretKey: resp.SecretAccessKey, sessionToken: resp.SessionToken}, resp.Expiration, nil
+}
+
+func readResponse(resp *http.Response, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("%s %s failed: %d, %s", resp.Request.Method, resp.Request.URL.Host+resp.Request.URL.Path, resp.StatusCode, string(body))
+	}
+	return body, nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	jsonLines   bool
+	jsonLinesMu sync.Mutex
+)
+
+// messages is where progress and error messages go: stdout, unless it is
+// reserved for -jsonLines output.
+func messages() io.Writer {
+	if jsonLines {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// emitJSONLine writes v to stdout as a single line of JSON, for -jsonLines
+// consumers to process as the run progresses.
+func emitJSONLine(v interface{}) {
+	jsonLinesMu.Lock()
+	defer jsonLinesMu.Unlock()
+
+	b, err := json.Marshal(v)
+	check(err)
+	fmt.Println(string(b))
+}
+
+// confirm asks the operator a yes/no question on the terminal.
+func confirm(question string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	var answer string
+	fmt.Scanln(&answer)
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// confirmTyped asks for want to be typed to confirm, for changes that are
+// hard to undo.
+func confirmTyped(question, want string) bool {
+	fmt.Fprintf(os.Stderr, "%s\nType %q to confirm: ", question, want)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimSpace(answer) == want
+}
+
+// outputFormat is -output: json, yaml or table.
+var outputFormat string
+
+// outputFlag adds -output, for commands that can print their results in a
+// structured format for scripts, or as a table.
+func outputFlag(fs *flag.FlagSet, def string) {
+	outputFormat = def
+	fs.Var(outputValue{}, "output", "Output `format`: json, yaml or table (default "+def+").")
+}
+
+// outputValue checks -output as it's parsed, rather than after the work.
+type outputValue struct{}
+
+func (outputValue) String() string { return outputFormat }
+
+func (outputValue) Set(s string) error {
+	switch s {
+	case "json", "yaml", "table":
+		outputFormat = s
+		return nil
+	}
+	return fmt.Errorf("expected json, yaml or table")
+}
+
+// printStructured prints v in the -output format, unless it is table, when
+// it's for the caller to print and it returns false.
+func printStructured(v interface{}) bool {
+	switch outputFormat {
+	case "json":
+		data, err := json.MarshalIndent(v, "", "  ")
+		check(err)
+		fmt.Println(string(data))
+		return true
+	case "yaml":
+		data, err := toYAML(v)
+		check(err)
+		fmt.Print(data)
+		return true
+	}
+	return false
+}
+
+// toYAML encodes v as YAML, via its JSON encoding so that the same field
+// names are used.
+func toYAML(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	writeYAML(&b, generic, "")
+	return b.String(), nil
+}
+
+func writeYAML(b *strings.Builder, v interface{}, indent string) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 {
+			b.WriteString(indent + "{}\n")
+			return
+		}
+		for _, k := range sortedMapKeys(v) {
+			b.WriteString(indent + yamlScalar(k) + ":")
+			writeYAMLValue(b, v[k], indent+"  ")
+		}
+	case []interface{}:
+		if len(v) == 0 {
+			b.WriteString(indent + "[]\n")
+			return
+		}
+		for _, item := range v {
+			var nested strings.Builder
+			writeYAML(&nested, item, indent+"  ")
+			// The first line of each item goes after its dash.
+			b.WriteString(indent + "- " + strings.TrimPrefix(nested.String(), indent+"  "))
+		}
+	default:
+		b.WriteString(indent + yamlScalar(v) + "\n")
+	}
+}
+
+// writeYAMLValue writes the value of a mapping key: scalars and empty
+// collections on the same line, anything else on the lines after.
+func writeYAMLValue(b *strings.Builder, v interface{}, indent string) {
+	switch c := v.(type) {
+	case map[string]interface{}:
+		if len(c) == 0 {
+			b.WriteString(" {}\n")
+			return
+		}
+	case []interface{}:
+		if len(c) == 0 {
+			b.WriteString(" []\n")
+			return
+		}
+	default:
+		b.WriteString(" " + yamlScalar(v) + "\n")
+		return
+	}
+	b.WriteString("\n")
+	writeYAML(b, v, indent)
+}
+
+var yamlPlain = regexp.MustCompile(`^[A-Za-z_./][A-Za-z0-9_ ./@+()-]*[A-Za-z0-9_./@+()-]$|^[A-Za-z_]$`)
+
+func yamlScalar(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		switch strings.ToLower(v) {
+		case "true", "false", "yes", "no", "on", "off", "null", "y", "n":
+			return strconv.Quote(v)
+		}
+		if yamlPlain.MatchString(v) {
+			return v
+		}
+		return strconv.Quote(v)
+	}
+	return strconv.Quote(fmt.Sprint(v))
+}
+
+func sortedMapKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
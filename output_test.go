@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestToYAML(t *testing.T) {
+	for _, tt := range []struct {
+		v    interface{}
+		want string
+	}{
+		{"logs", "logs\n"},
+		{map[string]interface{}{}, "{}\n"},
+		{[]string{}, "[]\n"},
+		{map[string]interface{}{"b": 2, "a": true, "c": nil}, "a: true\nb: 2\nc: null\n"},
+		// Strings that would read back as something else are quoted.
+		{map[string]string{"a": "", "b": "true", "c": "x: y", "d": "100", "e": "s3/logs"}, "a: \"\"\nb: \"true\"\nc: \"x: y\"\nd: \"100\"\ne: s3/logs\n"},
+		{map[string]interface{}{"m": map[string]int{"x": 1}, "l": []int{1, 2}, "e": []int{}}, "e: []\nl:\n  - 1\n  - 2\nm:\n  x: 1\n"},
+		{[]map[string]interface{}{{"name": "logs", "period": 300}, {"name": "more"}}, "- name: logs\n  period: 300\n- name: more\n"},
+		// Structs are written with their JSON field names.
+		{historyEntry{Type: "s3", ToVersion: 2}, "command: \"\"\nfrom_version: 0\nlogging_name: \"\"\nnew_key: \"\"\noperator: \"\"\nrotated_at: \"0001-01-01T00:00:00Z\"\nservice_id: \"\"\nto_version: 2\ntype: s3\n"},
+	} {
+		got, err := toYAML(tt.v)
+		if err != nil {
+			t.Errorf("toYAML(%v): %s", tt.v, err)
+		} else if got != tt.want {
+			t.Errorf("toYAML(%v) = %q, want %q", tt.v, got, tt.want)
+		}
+	}
+}
+
+// TestToYAMLReadBack checks that -output yaml can be read as a config file
+// or manifest would be.
+func TestToYAMLReadBack(t *testing.T) {
+	v := map[string]interface{}{
+		"service_id": "abc",
+		"versions":   []interface{}{1.0, 2.0},
+		"logging":    map[string]interface{}{"name": "logs: s3", "format": "%h\n%t", "empty": ""},
+		"flags":      []interface{}{true, nil},
+	}
+	out, err := toYAML(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := yamlToJSON([]byte(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got interface{}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, v) {
+		t.Errorf("yamlToJSON(toYAML(%v)) = %s", v, data)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+var (
+	policy      string
+	policyQuery string
+)
+
+// policyInput is what a policy is evaluated against: the logging
+// configuration of the version about to be activated.
+type policyInput struct {
+	Command   string                              `json:"command"`
+	ServiceID string                              `json:"service_id"`
+	Version   int                                 `json:"version"`
+	Operator  string                              `json:"operator"`
+	Logging   map[string][]map[string]interface{} `json:"logging"`
+}
+
+// checkPolicy evaluates the proposed version against the -policy, returning
+// an error listing any violations.
+//
+// https://www.openpolicyagent.org/docs/latest/rest-api/#get-a-document-with-input
+func checkPolicy(ctx context.Context, f *fastlyClient, hc hookContext) error {
+	if policy == "" {
+		return nil
+	}
+
+	logging, err := f.loggingConfig(ctx, hc.ServiceID, hc.Version)
+	if err != nil {
+		return err
+	}
+	input := policyInput{Command: hc.Command, ServiceID: hc.ServiceID, Version: hc.Version, Operator: hc.Operator, Logging: logging}
+
+	var violations []string
+	if strings.HasPrefix(policy, "http://") || strings.HasPrefix(policy, "https://") {
+		violations, err = evalPolicyServer(ctx, input)
+	} else {
+		violations, err = evalPolicyFile(ctx, input)
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to evaluate policy: %s", err.Error())
+	}
+
+	if len(violations) > 0 {
+		return fmt.Errorf("Version %d violates policy:\n  - %s", hc.Version, strings.Join(violations, "\n  - "))
+	}
+	return nil
+}
+
+// evalPolicyServer queries an OPA server, where policy is the URL of the deny
+// document, e.g. http://localhost:8181/v1/data/fastly_logging/deny.
+func evalPolicyServer(ctx context.Context, input policyInput) ([]string, error) {
+	body, err := json.Marshal(map[string]interface{}{"input": input})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, policy, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Content-Type", "application/json")
+
+	resp, err := httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%d, %s", resp.StatusCode, string(respBody))
+	}
+
+	var result struct {
+		Result interface{} `json:"result"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return nil, err
+	}
+	if result.Result == nil {
+		return nil, fmt.Errorf("%s is undefined: check the URL names the deny document", policy)
+	}
+	return violationMessages(result.Result), nil
+}
+
+// evalPolicyFile evaluates a local Rego file with the opa CLI.
+func evalPolicyFile(ctx context.Context, input policyInput) ([]string, error) {
+	in, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout bytes.Buffer
+	cmd := exec.CommandContext(ctx, "opa", "eval", "--format", "json", "--stdin-input", "--data", policy, policyQuery)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Result []struct {
+			Expressions []struct {
+				Value interface{} `json:"value"`
+			} `json:"expressions"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+		return nil, err
+	}
+
+	// opa eval gives no results, rather than an empty set, for a query that
+	// names no document.
+	if len(result.Result) == 0 {
+		return nil, fmt.Errorf("%s is undefined in %s: check -policyQuery names the deny document", policyQuery, policy)
+	}
+
+	var violations []string
+	for _, r := range result.Result {
+		for _, e := range r.Expressions {
+			if e.Value == nil {
+				return nil, fmt.Errorf("%s is undefined in %s: check -policyQuery names the deny document", policyQuery, policy)
+			}
+			violations = append(violations, violationMessages(e.Value)...)
+		}
+	}
+	return violations, nil
+}
+
+// violationMessages flattens a defined deny document (a set of messages, or a
+// boolean) into messages.
+func violationMessages(v interface{}) []string {
+	switch v := v.(type) {
+	case []interface{}:
+		var msgs []string
+		for _, m := range v {
+			msgs = append(msgs, fmt.Sprint(m))
+		}
+		return msgs
+	case bool:
+		if v {
+			return []string{"denied"}
+		}
+	default:
+		return []string{fmt.Sprint(v)}
+	}
+	return nil
+}
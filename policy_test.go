@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestEvalPolicyServerUndefined covers an OPA server with no deny document
+// at the URL, which must block activation rather than allow it.
+func TestEvalPolicyServerUndefined(t *testing.T) {
+	for body, want := range map[string]int{
+		`{"result": []}`:                 0,
+		`{"result": ["no public ACLs"]}`: 1,
+		`{"result": false}`:              0,
+		`{"result": true}`:               1,
+		`{}`:                             -1,
+		`{"result": null}`:               -1,
+	} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(body))
+		}))
+		policy = srv.URL
+		violations, err := evalPolicyServer(context.Background(), policyInput{})
+		srv.Close()
+
+		switch {
+		case want < 0 && err == nil:
+			t.Errorf("evalPolicyServer() of %s = %q, want an error", body, violations)
+		case want >= 0 && err != nil:
+			t.Errorf("evalPolicyServer() of %s: %s", body, err)
+		case want >= 0 && len(violations) != want:
+			t.Errorf("evalPolicyServer() of %s = %q, want %d violations", body, violations, want)
+		}
+	}
+	policy = ""
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// promote applies the logging configuration of one service (staging) to
+// another (production), in a single new version. Each service keeps its own
+// credentials, and fields such as buckets are rewritten by the substitutions
+// in the config file.
+func promote(args []string) {
+	fs := flag.NewFlagSet("promote", flag.ExitOnError)
+	from := fs.String("from", "", "The Fastly Service ID to promote from, e.g. of staging.")
+	to := fs.String("to", "", "The Fastly Service ID to promote to, e.g. of production.")
+	draftFlags(fs)
+	notifyFlags(fs)
+	commonFlags(fs)
+
+	fs.Usage = commandUsage(fs, "Note, FASTLY_KEY (or the tokens of the services' accounts in the config file) must be provided as env vars. Substitutions are read from the promotions in the config file.")
+	parseFlags(fs, args)
+
+	checkArg("from", *from)
+	checkArg("to", *to)
+
+	var substitutions map[string]map[string]string
+	for _, p := range loadConfig().Promotions {
+		if p.From == *from && p.To == *to {
+			substitutions = p.Substitutions
+		}
+	}
+
+	ctx := context.Background()
+	source := fastlyFor(*from)
+	f := fastlyFor(*to)
+
+	sourceVersion, err := source.activeVersion(ctx, *from)
+	check(err)
+	targetVersion, err := f.activeVersion(ctx, *to)
+	check(err)
+
+	// Work out the changes up front, so nothing is cloned if the promotion
+	// can't be made.
+	type update struct {
+		typ    string
+		name   string
+		params url.Values
+	}
+	var updates []update
+	for _, t := range loggingTypes {
+		wanted, err := source.listableLoggings(ctx, *from, sourceVersion, t)
+		check(err)
+		current, err := f.listableLoggings(ctx, *to, targetVersion, t)
+		check(err)
+		existing := byName(current)
+
+		for _, l := range wanted {
+			name := fmt.Sprint(l["name"])
+			target, ok := existing[name]
+			if !ok {
+				check(fmt.Errorf("%s/%s isn't on %s: create it there (with its own credentials) before promoting", t, name, *to))
+			}
+
+			params := promotedFields(t, l, substitutions)
+			for field, values := range params {
+				if fmt.Sprint(target[field]) == values[0] || target[field] == nil && values[0] == "" {
+					delete(params, field)
+				}
+			}
+			if len(params) > 0 {
+				updates = append(updates, update{t, name, params})
+				fmt.Fprintf(messages(), "%s/%s: updating %s\n", t, name, strings.Join(sortedKeys(params), ", "))
+			}
+		}
+	}
+
+	if len(updates) == 0 {
+		fmt.Fprintf(messages(), "The logging of %s already matches %s.\n", *to, *from)
+		return
+	}
+
+	number, err := withDraft(ctx, f, *to, func(number int) error {
+		for _, u := range updates {
+			if err := f.updateLogging(ctx, *to, number, u.typ, u.name, u.params); err != nil {
+				return err
+			}
+		}
+		return f.setVersionComment(ctx, *to, number, versionComment(fmt.Sprintf("Promoted logging from %s version %d", *from, sourceVersion)))
+	})
+	if err != nil {
+		notify(failedOutcome(*to, err))
+		flushNotifications()
+		check(err)
+	}
+
+	msg := fmt.Sprintf("Activated version %d of service %s, promoting %d logging configuration change(s) from version %d of %s.", number, *to, len(updates), sourceVersion, *from)
+	notify(outcome{ServiceID: *to, Status: statusRotated, Detail: msg})
+	flushNotifications()
+	fmt.Fprintln(messages(), msg)
+}
+
+// credentialFields identify the credentials of a logging configuration, which
+// differ between services like the secrets themselves.
+var credentialFields = []string{"access_key", "iam_role", "user"}
+
+// promotedFields are the form values to carry a logging configuration over
+// to another service: everything but its name, credentials and version
+// bookkeeping, with substitutions made.
+func promotedFields(typ string, l map[string]interface{}, substitutions map[string]map[string]string) url.Values {
+	params := url.Values{}
+	for field, v := range l {
+		if field == "name" || isSecretField(typ, field) || contains(credentialFields, field) || contains(volatileFields, field) {
+			continue
+		}
+
+		value := ""
+		if v != nil {
+			value = fmt.Sprint(v)
+		}
+		for old, replacement := range substitutions[field] {
+			value = strings.Replace(value, old, replacement, -1)
+		}
+		params.Set(field, value)
+	}
+	return params
+}
+
+func sortedKeys(values url.Values) []string {
+	var keys []string
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// loggingProvider manages the logging configurations of one Fastly logging
+// type. Commands go through the provider of a type rather than its API, so
+// supporting a new type (including one only a fork knows about) takes no
+// more than registering a provider for it.
+type loggingProvider interface {
+	typ() string
+	list(ctx context.Context, f *fastlyClient, serviceID string, version int) ([]map[string]interface{}, error)
+	get(ctx context.Context, f *fastlyClient, serviceID string, version int, name string) (map[string]interface{}, error)
+	create(ctx context.Context, f *fastlyClient, serviceID string, version int, fields url.Values) error
+	update(ctx context.Context, f *fastlyClient, serviceID string, version int, name string, fields url.Values) error
+	delete(ctx context.Context, f *fastlyClient, serviceID string, version int, name string) error
+	// rotateCreds adds new credentials to fields, which must already hold
+	// any fields identifying them.
+	rotateCreds(fields url.Values) error
+	// isSecret tells whether a field holds a credential.
+	isSecret(field string) bool
+	credentials() credentialSpec
+}
+
+var (
+	loggingProviders = map[string]loggingProvider{}
+	// loggingTypes are the Fastly logging endpoint types this tool knows
+	// about, in the order they were registered.
+	loggingTypes []string
+)
+
+func registerLoggingProvider(p loggingProvider) {
+	if _, ok := loggingProviders[p.typ()]; !ok {
+		loggingTypes = append(loggingTypes, p.typ())
+	}
+	loggingProviders[p.typ()] = p
+}
+
+func providerFor(typ string) (loggingProvider, error) {
+	p, ok := loggingProviders[typ]
+	if !ok {
+		return nil, fmt.Errorf("Unknown logging type '%s', expected one of %s", typ, strings.Join(loggingTypes, ","))
+	}
+	return p, nil
+}
+
+// secretFields are logging configuration fields that hold credentials.
+var secretFields = []string{"secret_key", "sas_token", "token", "password", "tls_client_key", "access_key_secret"}
+
+// restProvider is a logging type managed with the standard Fastly logging
+// API: /service/{id}/version/{n}/logging/{type}.
+type restProvider struct {
+	name  string
+	creds credentialSpec
+	// secrets are fields holding credentials for this type in particular,
+	// such as Sumo Logic collector URLs, which embed their secret.
+	secrets []string
+}
+
+func init() {
+	for _, p := range []loggingProvider{
+		restProvider{name: "s3", creds: credentialSpec{
+			secrets:  map[string]string{"secret_key": "AWS_SECRET_KEY"},
+			required: []string{"access_key"},
+			iamRole:  true,
+		}},
+		gcpKeyProvider{restProvider{name: "gcs", creds: credentialSpec{secrets: map[string]string{"secret_key": "GCS_SECRET_KEY"}, required: []string{"user"}}}},
+		gcpKeyProvider{restProvider{name: "bigquery", creds: credentialSpec{secrets: map[string]string{"secret_key": "BIGQUERY_SECRET_KEY"}, required: []string{"user"}}}},
+		restProvider{name: "azureblob", creds: credentialSpec{secrets: map[string]string{"sas_token": "AZURE_SAS_TOKEN"}}},
+		restProvider{name: "splunk", creds: credentialSpec{secrets: map[string]string{"token": "SPLUNK_HEC_TOKEN"}}},
+		restProvider{name: "datadog"},
+		restProvider{name: "newrelic", creds: credentialSpec{secrets: map[string]string{"token": "NEW_RELIC_INSERT_KEY"}}},
+		restProvider{name: "sumologic", creds: credentialSpec{secrets: map[string]string{"url": "SUMO_COLLECTOR_URL"}}, secrets: []string{"url"}},
+		restProvider{name: "elasticsearch", creds: credentialSpec{
+			secrets:         map[string]string{"password": "ELASTICSEARCH_PASSWORD"},
+			optionalSecrets: map[string]string{"tls_client_key": "ELASTICSEARCH_TLS_CLIENT_KEY"},
+			required:        []string{"user"},
+		}},
+		restProvider{name: "honeycomb", creds: credentialSpec{secrets: map[string]string{"token": "HONEYCOMB_WRITE_KEY"}}},
+		// Heroku Logplex drains.
+		restProvider{name: "heroku", creds: credentialSpec{secrets: map[string]string{"token": "LOGPLEX_TOKEN"}}},
+		restProvider{name: "loggly", creds: credentialSpec{secrets: map[string]string{"token": "LOGGLY_TOKEN"}}},
+		restProvider{name: "papertrail"},
+		restProvider{name: "scalyr", creds: credentialSpec{secrets: map[string]string{"token": "SCALYR_TOKEN"}}},
+		restProvider{name: "sftp", creds: credentialSpec{
+			optionalSecrets: map[string]string{"password": "SFTP_PASSWORD", "secret_key": "SFTP_SSH_KEY"},
+			required:        []string{"user"},
+		}},
+		restProvider{name: "ftp", creds: credentialSpec{secrets: map[string]string{"password": "FTP_PASSWORD"}}},
+		restProvider{name: "syslog", creds: credentialSpec{
+			secrets:  map[string]string{"tls_client_key": "SYSLOG_TLS_CLIENT_KEY"},
+			required: []string{"tls_client_cert"},
+		}},
+		restProvider{name: "openstack", creds: credentialSpec{secrets: map[string]string{"access_key": "OPENSTACK_ACCESS_KEY"}}, secrets: []string{"access_key"}},
+		restProvider{name: "cloudfiles", creds: credentialSpec{
+			secrets:  map[string]string{"access_key": "CLOUDFILES_API_KEY"},
+			required: []string{"user"},
+		}, secrets: []string{"access_key"}},
+		restProvider{name: "kinesis", creds: credentialSpec{
+			secrets:  map[string]string{"secret_key": "AWS_SECRET_KEY"},
+			required: []string{"access_key"},
+			iamRole:  true,
+		}},
+		restProvider{name: "logentries", creds: credentialSpec{secrets: map[string]string{"token": "LOGENTRIES_TOKEN"}}},
+	} {
+		registerLoggingProvider(p)
+	}
+}
+
+func (p restProvider) typ() string {
+	return p.name
+}
+
+func (p restProvider) path(serviceID string, version int) string {
+	return fmt.Sprintf("/service/%s/version/%d/logging/%s", serviceID, version, p.name)
+}
+
+func (p restProvider) list(ctx context.Context, f *fastlyClient, serviceID string, version int) ([]map[string]interface{}, error) {
+	var loggings []map[string]interface{}
+	err := f.do(ctx, http.MethodGet, p.path(serviceID, version), nil, &loggings)
+	return loggings, err
+}
+
+func (p restProvider) get(ctx context.Context, f *fastlyClient, serviceID string, version int, name string) (map[string]interface{}, error) {
+	var l map[string]interface{}
+	err := f.do(ctx, http.MethodGet, p.path(serviceID, version)+"/"+name, nil, &l)
+	return l, err
+}
+
+// create adds a logging configuration. If it is unclear whether it was
+// added, the version is checked for it, so that a rerun doesn't fail on the
+// name already being taken.
+func (p restProvider) create(ctx context.Context, f *fastlyClient, serviceID string, version int, fields url.Values) error {
+	err := f.do(ctx, http.MethodPost, p.path(serviceID, version), fields, nil)
+	if !errors.Is(err, errAmbiguous) {
+		return err
+	}
+
+	loggings, lerr := p.list(ctx, f, serviceID, version)
+	if lerr != nil {
+		return err
+	}
+	if _, ok := byName(loggings)[fields.Get("name")]; !ok {
+		return fmt.Errorf("%s (%s logging %q was not created)", err.Error(), p.name, fields.Get("name"))
+	}
+	fmt.Fprintf(messages(), "Creating %s logging %q on version %d of service %s reported an error, but it was created.\n", p.name, fields.Get("name"), version, serviceID)
+	return nil
+}
+
+func (p restProvider) update(ctx context.Context, f *fastlyClient, serviceID string, version int, name string, fields url.Values) error {
+	return f.do(ctx, http.MethodPut, p.path(serviceID, version)+"/"+name, fields, nil)
+}
+
+func (p restProvider) delete(ctx context.Context, f *fastlyClient, serviceID string, version int, name string) error {
+	return f.do(ctx, http.MethodDelete, p.path(serviceID, version)+"/"+name, nil, nil)
+}
+
+func (p restProvider) rotateCreds(fields url.Values) error {
+	return p.creds.addSecrets(p.name, fields)
+}
+
+func (p restProvider) isSecret(field string) bool {
+	return contains(secretFields, field) || contains(p.secrets, field)
+}
+
+func (p restProvider) credentials() credentialSpec {
+	return p.creds
+}
+
+// loggings fetches the logging configurations of one type as raw fields.
+func (f *fastlyClient) loggings(ctx context.Context, serviceID string, version int, typ string) ([]map[string]interface{}, error) {
+	p, err := providerFor(typ)
+	if err != nil {
+		return nil, err
+	}
+	return p.list(ctx, f, serviceID, version)
+}
+
+// unlistedTypes are the logging types listing has warned can't be listed.
+var unlistedTypes = map[string]bool{}
+
+// listableLoggings is loggings for listing every type of logging in turn: a
+// type whose list 404s is warned about (once) and skipped, so that it can't
+// break the whole listing.
+func (f *fastlyClient) listableLoggings(ctx context.Context, serviceID string, version int, typ string) ([]map[string]interface{}, error) {
+	loggings, err := f.loggings(ctx, serviceID, version, typ)
+	if isFastlyStatus(err, http.StatusNotFound) {
+		if !unlistedTypes[typ] {
+			fmt.Fprintf(messages(), "Warning: skipping %s logging, which can't be listed: %s\n", typ, err.Error())
+			unlistedTypes[typ] = true
+		}
+		return nil, nil
+	}
+	return loggings, err
+}
+
+func (f *fastlyClient) updateLogging(ctx context.Context, serviceID string, version int, typ, name string, fields url.Values) error {
+	p, err := providerFor(typ)
+	if err != nil {
+		return err
+	}
+	return p.update(ctx, f, serviceID, version, name, fields)
+}
+
+func isSecretField(typ, field string) bool {
+	if p, ok := loggingProviders[typ]; ok {
+		return p.isSecret(field)
+	}
+	return contains(secretFields, field)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// TestLoggingConfigSkipsUnlistedTypes checks that a type whose list 404s
+// doesn't stop the other types being listed.
+func TestLoggingConfigSkipsUnlistedTypes(t *testing.T) {
+	f := fakeFastly(t, serveJSON(t, map[string]interface{}{
+		"/service/abc/version/2/logging/s3":     []map[string]interface{}{{"name": "logs", "bucket_name": "bucket"}},
+		"/service/abc/version/2/logging/heroku": []map[string]interface{}{{"name": "drain", "url": "https://1.us.logplex.io/logs"}},
+	}))
+
+	config, err := f.loggingConfig(context.Background(), "abc", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config["s3"]) != 1 || len(config["heroku"]) != 1 {
+		t.Errorf("loggingConfig() = %v, want the s3 and heroku logging", config)
+	}
+
+	if _, err := f.matchingEndpoints(context.Background(), "abc", 2, "splunk", "*"); err == nil {
+		t.Error("matchingEndpoints() of a type that can't be listed succeeded, want an error")
+	}
+}
+
+func TestMigrateFormat(t *testing.T) {
+	for format, want := range map[string]string{
+		`%h %{req.http.host}V %{resp.status}V`:                   `%h %{req.http.host}V %{resp.status}V`,
+		`%{http.User-Agent}V %{url}V`:                            `%{req.http.User-Agent}V %{req.url}V`,
+		`%{fastly_info.state}V %{json.escape(req.url)}V %{url}V`: `%{fastly_info.state}V %{json.escape(req.url)}V %{req.url}V`,
+		`%h %l %u %t "%r" %>s %b`:                                `%h %l %u %t "%r" %>s %b`,
+	} {
+		if got := migrateFormat(format); got != want {
+			t.Errorf("migrateFormat(%q) = %q, want %q", format, got, want)
+		}
+	}
+}
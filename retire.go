@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// recordRetirement notes an old access key for retire-keys to retire.
+func recordRetirement(oldKey string, r retirementRecord) error {
+	return updateState(func(state *rotationState) {
+		state.Retirements[oldKey] = r
+	})
+}
+
+// retireKeys is the second phase of a rotation with -gracePeriod: once the
+// grace period is over, each old key is retired, but only if Fastly still
+// uses the new key and AWS has seen it used by S3.
+func retireKeys(args []string) {
+	fs := flag.NewFlagSet("retire-keys", flag.ExitOnError)
+	wait := fs.Bool("wait", false, "Wait until every pending key's grace period is over, rather than only retiring those already due.")
+	cloudTrailFlags(fs)
+	notifyFlags(fs)
+	commonFlags(fs)
+
+	fs.Usage = commandUsage(fs, "Note, FASTLY_KEY and AWS credentials able to manage the keys' IAM users (in the standard AWS env vars) must be provided.")
+	parseFlags(fs, args)
+
+	state, err := loadState()
+	check(err)
+
+	var oldKeys []string
+	for k := range state.Retirements {
+		oldKeys = append(oldKeys, k)
+	}
+	sort.Slice(oldKeys, func(i, j int) bool {
+		return state.Retirements[oldKeys[i]].RetireAfter.Before(state.Retirements[oldKeys[j]].RetireAfter)
+	})
+	if len(oldKeys) == 0 {
+		fmt.Fprintln(messages(), "No keys are waiting to be retired.")
+		return
+	}
+
+	ctx := context.Background()
+	a := newAWSClient(awsEnvCredentials(), "")
+
+	failed := 0
+	for _, oldKey := range oldKeys {
+		r := state.Retirements[oldKey]
+		if remaining := time.Until(r.RetireAfter); remaining > 0 {
+			if !*wait {
+				fmt.Fprintf(messages(), "%s: grace period ends %s.\n", oldKey, r.RetireAfter.Local().Format(time.RFC3339))
+				continue
+			}
+			fmt.Fprintf(messages(), "Waiting %s for the grace period of %s to end...\n", remaining.Round(time.Second), oldKey)
+			time.Sleep(remaining)
+		}
+
+		o := retireKey(ctx, a, oldKey, r, dryRun)
+		notify(o)
+		fmt.Fprintf(messages(), "%s: %s\n", oldKey, o.Detail)
+		if o.Status == statusFailed {
+			failed++
+			continue
+		}
+		if o.Status == statusRotated {
+			check(updateState(func(state *rotationState) {
+				delete(state.Retirements, oldKey)
+			}))
+		}
+	}
+	flushNotifications()
+
+	if failed > 0 {
+		check(fmt.Errorf("%d key(s) couldn't be retired", failed))
+	}
+}
+
+// retireKey retires one old key, after checking the new one is in use.
+func retireKey(ctx context.Context, a *awsClient, oldKey string, r retirementRecord, dryRun bool) outcome {
+	failed := func(format string, args ...interface{}) outcome {
+		return outcome{ServiceID: r.ServiceID, Status: statusFailed, Detail: fmt.Sprintf(format, args...)}
+	}
+
+	f := fastlyFor(r.ServiceID)
+	active, err := f.activeVersion(ctx, r.ServiceID)
+	if err != nil {
+		return failed("%s", err.Error())
+	}
+	current, err := f.s3Logging(ctx, r.ServiceID, active, r.LoggingName)
+	if err != nil {
+		return failed("%s", err.Error())
+	}
+	if current.AccessKey != r.NewKey {
+		return failed("Not retired: %s/%s uses %s now, not the new key %s", r.ServiceID, r.LoggingName, current.AccessKey, r.NewKey)
+	}
+
+	// IAM can take hours to report a key as used, which the grace period
+	// should allow for.
+	used, err := a.accessKeyLastUsed(ctx, r.NewKey)
+	if err != nil {
+		return failed("%s", err.Error())
+	}
+	if used.ServiceName != "s3" || used.LastUsedDate.Before(r.RotatedAt) {
+		return failed("Not retired: the new key %s hasn't been used by S3 since the rotation yet", r.NewKey)
+	}
+
+	if r.Action == "delete" {
+		if _, err := a.checkOldKeyUnused(ctx, oldKey, r.RotatedAt.Add(deliveryTimeout(int(current.Period)))); err != nil {
+			return failed("Not deleted: %s", err.Error())
+		}
+	}
+
+	if dryRun {
+		return outcome{ServiceID: r.ServiceID, Status: statusSkipped, Detail: fmt.Sprintf("Would %s it (user %s): the new key %s was last used %s.", r.Action, r.Owner, r.NewKey, used.LastUsedDate.Format(time.RFC3339))}
+	}
+
+	if r.Action == "delete" {
+		err = a.deleteAccessKey(ctx, r.Owner, oldKey)
+	} else {
+		err = a.updateAccessKey(ctx, r.Owner, oldKey, "Inactive")
+	}
+	if err != nil {
+		return failed("%s", err.Error())
+	}
+	return outcome{ServiceID: r.ServiceID, Status: statusRotated, Detail: fmt.Sprintf("%sd (user %s).", r.Action, r.Owner)}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// rollback re-activates the version of a service that was active before the
+// current one, undoing a rotation (or anything else) in a single step.
+func rollback(args []string) {
+	fs := flag.NewFlagSet("rollback", flag.ExitOnError)
+	serviceID := fs.String("serviceID", "", "A Fastly Service ID.")
+	to := fs.Int("version", 0, "Version to activate (default: the version active before the current one).")
+	yes := fs.Bool("yes", false, "Roll back without asking for confirmation.")
+	notifyFlags(fs)
+	commonFlags(fs)
+
+	fs.Usage = commandUsage(fs, "Note, FASTLY_KEY must be provided as an env var.")
+	parseFlags(fs, args)
+
+	checkArg("serviceID", *serviceID)
+
+	f := fastlyFor(*serviceID)
+	ctx := context.Background()
+
+	versions, err := f.versions(ctx, *serviceID)
+	check(err)
+	active, err := f.activeVersion(ctx, *serviceID)
+	check(err)
+
+	if *to == 0 {
+		*to, err = previousVersion(*serviceID, active, versions)
+		check(err)
+	}
+	if *to == active {
+		check(fmt.Errorf("Version %d of service %s is already active", active, *serviceID))
+	}
+	found := false
+	for _, v := range versions {
+		found = found || v.Number == *to
+	}
+	if !found {
+		check(fmt.Errorf("Service %s has no version %d", *serviceID, *to))
+	}
+
+	if !*yes && !dryRun && !confirm(fmt.Sprintf("Roll service %s back from version %d to %d?", *serviceID, active, *to)) {
+		fmt.Fprintln(messages(), "Not rolling back.")
+		return
+	}
+
+	if err := f.activateVersion(ctx, *serviceID, *to); err != nil {
+		notify(outcome{ServiceID: *serviceID, Status: statusFailed, Detail: err.Error()})
+		flushNotifications()
+		check(err)
+	}
+	if dryRun {
+		check(errDryRun)
+	}
+
+	msg := fmt.Sprintf("Rolled service %s back from version %d to %d.", *serviceID, active, *to)
+	notify(outcome{ServiceID: *serviceID, Status: statusRotated, Detail: msg})
+	flushNotifications()
+	fmt.Fprintln(messages(), msg)
+}
+
+// previousVersion is the version active before active: the one it was
+// rotated from according to the state file, or else the latest version
+// before it that has been locked by activation (rather than discarded).
+func previousVersion(serviceID string, active int, versions []version) (int, error) {
+	if state, err := loadState(); err == nil {
+		for i := len(state.History) - 1; i >= 0; i-- {
+			if e := state.History[i]; e.ServiceID == serviceID && e.ToVersion == active {
+				return e.FromVersion, nil
+			}
+		}
+	}
+
+	previous := 0
+	discarded := strings.SplitN(discardedComment, "%", 2)[0]
+	for _, v := range versions {
+		if v.Number < active && v.Number > previous && v.Locked && !strings.HasPrefix(v.Comment, discarded) {
+			previous = v.Number
+		}
+	}
+	if previous == 0 {
+		return 0, fmt.Errorf("Unable to tell which version of service %s was active before %d, give -version", serviceID, active)
+	}
+	return previous, nil
+}
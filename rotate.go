@@ -0,0 +1,607 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// https://developer.fastly.com/reference/api/logging/
+func rotateCreds(args []string) {
+	fs := flag.NewFlagSet("rotate-creds", flag.ExitOnError)
+	var serviceIDs listFlag
+	fs.Var(&serviceIDs, "serviceID", "A Fastly Service ID (optional with -tag, to rotate every service). Can be repeated (or comma-separated) to rotate each service in turn.")
+	loggingName := fs.String("loggingName", "", "Name of your service logging configuration in Fastly (or select them with -tag).")
+	typ := fs.String("type", "s3", "Type of the logging configuration: s3, or one of "+strings.Join(rotatableTypes(), ",")+".")
+	awsAccessKey := fs.String("awsAccessKey", "", "AWS Access Key (or the key of another S3-compatible -provider) for write access to the target bucket, or stream with -type kinesis.")
+	newKeyFor := fs.String("newKeyFor", "", "IAM user to mint a new access key for with CreateAccessKey, in place of -awsAccessKey and AWS_SECRET_KEY, e.g. with -webIdentityRole in CI. The key is deleted again if the rotation fails.")
+	retireOldKey := fs.String("retireOldKey", "keep", "With -newKeyFor and -type s3, what to do with the old access key once logs are delivered with the new one: keep, deactivate or delete.")
+	gracePeriod := fs.Duration("gracePeriod", 0, "With -retireOldKey, leave the old key active for this long rather than waiting for delivery, for retire-keys to retire it later once the new key is in use.")
+	verifyTimeout := fs.Duration("verifyTimeout", 0, "How long to wait for logs to be delivered with the new key, with -retireOldKey (default: two logging periods plus 10m).")
+	awsProfile := fs.String("awsProfile", "", "Profile of the AWS shared credentials file (~/.aws/credentials) to take the new access key and secret from, in place of -awsAccessKey and AWS_SECRET_KEY.")
+	var sets listFlag
+	fs.Var(&sets, "set", "A non-secret field to change along with the credentials, as field=value, e.g. account_name=logs. Can be repeated.")
+	gcsUser := fs.String("gcsUser", "", "Service account email for GCS write access to the target bucket, with -type gcs (short for -set user=...).")
+	gcpKeyFile := fs.String("gcpKeyFile", "", "Service account JSON key file to take the new user and secret key from, with -type gcs or bigquery.")
+	sasFrom := fs.String("sasFrom", "", "Secret store location to fetch the new SAS token from at rotation time, with -type azureblob, e.g. keyvault://vault/secret (short for -secretFrom AZURE_SAS_TOKEN=...).")
+	manifestFile := fs.String("manifest", "", "Fleet manifest (JSON, or YAML with a .yaml extension) of the S3 logging configurations to rotate, in place of -serviceID and -loggingName, each with an optional bucket_name and path to move its logs to.")
+	hecURL := fs.String("hecURL", "", "URL of the Splunk HTTP Event Collector to send logs to, with -type splunk (short for -set url=...).")
+	providerFlags(fs)
+	tagFlags(fs)
+	cloudTrailFlags(fs)
+	canaryFlags(fs)
+	checkpointFlags(fs)
+	draftFlags(fs)
+	notifyFlags(fs)
+	commonFlags(fs)
+
+	fs.Usage = commandUsage(fs, "Note, FASTLY_KEY must be provided as an env var, along with the new secrets: "+credentialSecretsUsage()+".")
+	parseFlags(fs, args)
+
+	ctx := context.Background()
+	ids := parseFields(serviceIDs.String())
+
+	if *awsProfile != "" {
+		*awsAccessKey = useAWSProfile(*awsProfile)
+	}
+
+	if *retireOldKey != "keep" {
+		if *retireOldKey != "deactivate" && *retireOldKey != "delete" {
+			check(fmt.Errorf("Unknown -retireOldKey '%s', expected keep, deactivate or delete", *retireOldKey))
+		}
+		if *newKeyFor == "" || *typ != "s3" || len(selectedTags) > 0 || len(ids) > 1 || *manifestFile != "" {
+			check(errors.New("-retireOldKey needs -newKeyFor, for one S3 logging configuration"))
+		}
+	} else if *gracePeriod > 0 {
+		check(errors.New("-gracePeriod needs -retireOldKey"))
+	}
+	if *manifestFile != "" && (len(ids) > 0 || *loggingName != "" || len(selectedTags) > 0 || *typ != "s3") {
+		check(errors.New("-manifest is for S3 logging, in place of -serviceID, -loggingName and -tag"))
+	}
+	if canaryServiceID != "" && (len(selectedTags) == 0 || *typ != "s3") {
+		check(errors.New("-canary needs -tag, to rotate S3 logging across several services"))
+	}
+	if checkpointFile != "" && (len(selectedTags) == 0 || *typ != "s3") {
+		check(errors.New("-checkpoint needs -tag, to rotate S3 logging across several services"))
+	}
+	if checkpointFile != "" && *newKeyFor != "" {
+		// A resumed run would mint another key for the rest.
+		check(errors.New("-checkpoint can't be used with -newKeyFor, which mints a different key each run"))
+	}
+
+	var minted *awsClient
+	newKey, keyInUse := "", false
+	// ran is this run's outcomes so far, for discarding the new key if the
+	// run exits part way.
+	var ran []outcome
+	record := func(o outcome) {
+		ran = append(ran, o)
+	}
+	// discardMinted deletes the new key unless something is using it.
+	discardMinted := func(outcomes ...outcome) []outcome {
+		for _, o := range outcomes {
+			keyInUse = keyInUse || o.Status != statusFailed
+		}
+		if newKey != "" && !keyInUse {
+			if err := minted.deleteAccessKey(ctx, *newKeyFor, newKey); err != nil {
+				fmt.Fprintf(messages(), "Unable to delete the new access key %s: %s\n", newKey, err.Error())
+			}
+			newKey = ""
+		}
+		return outcomes
+	}
+	if *newKeyFor != "" {
+		refuseDryRun("-newKeyFor")
+		if len(selectedTags) == 0 && *manifestFile == "" {
+			checkArg("serviceID", strings.Join(ids, ","))
+			checkArg("loggingName", *loggingName)
+		}
+		minted = newAWSClient(awsEnvCredentials(), "")
+		creds, err := minted.createAccessKey(ctx, *newKeyFor)
+		check(err)
+		fmt.Fprintf(messages(), "Created access key %s for %s.\n", creds.accessKey, *newKeyFor)
+		newKey = creds.accessKey
+		// However the run exits from here, the key mustn't be left behind
+		// unused, as IAM users can only have two.
+		atExit = func() { discardMinted(ran...) }
+		*awsAccessKey = creds.accessKey
+		secretValues["AWS_SECRET_KEY"] = creds.secretKey
+		check(waitForAccessKey(ctx, creds))
+	}
+
+	if *typ == "s3" {
+		awsSecretKey := secret("AWS_SECRET_KEY")
+
+		checkArg("awsAccessKey", *awsAccessKey)
+		checkArg("AWS_SECRET_KEY", awsSecretKey)
+		check(checkLongLivedKey(*awsAccessKey))
+
+		if len(selectedTags) > 0 {
+			rotateTagged(ctx, ids, *awsAccessKey, awsSecretKey, record)
+			return
+		}
+		if *manifestFile != "" {
+			fleet, err := loadFleet(ctx, *manifestFile)
+			check(err)
+			finishRotations(discardMinted(verifyPolicyDeliveries(ctx, rotateFleet(ctx, fleet, *awsAccessKey, awsSecretKey, record))...))
+			return
+		}
+
+		checkArg("serviceID", strings.Join(ids, ","))
+		checkArg("loggingName", *loggingName)
+
+		if *retireOldKey != "keep" {
+			f := fastlyFor(ids[0])
+			o, rotated := rotateIAMKey(ctx, f, minted, ids[0], *loggingName, *awsAccessKey, awsSecretKey, *retireOldKey, *verifyTimeout, *gracePeriod)
+			record(o)
+			keyInUse = keyInUse || rotated
+			discardMinted(o)
+			finishRotation(o)
+			return
+		}
+		finishRotations(discardMinted(verifyPolicyDeliveries(ctx, eachService(ids, func(id string) outcome {
+			o := rotateService(ctx, fastlyFor(id), id, *loggingName, *awsAccessKey, awsSecretKey)
+			record(o)
+			return o
+		}))...))
+		return
+	}
+
+	p, err := providerFor(*typ)
+	check(err)
+	if *sasFrom != "" {
+		if *typ != "azureblob" {
+			check(fmt.Errorf("-sasFrom can't be used with -type %s", *typ))
+		}
+		check(secretFroms.Set("AZURE_SAS_TOKEN=" + *sasFrom))
+	}
+
+	fields := url.Values{}
+	for _, set := range sets {
+		field, value, err := parseAssignment(set)
+		check(err)
+		fields.Set(field, value)
+	}
+	if *gcsUser != "" {
+		fields.Set("user", *gcsUser)
+	}
+	if *gcpKeyFile != "" {
+		if _, ok := p.(gcpKeyProvider); !ok {
+			check(fmt.Errorf("-gcpKeyFile can't be used with -type %s", *typ))
+		}
+		key, err := readGCPKeyFile(*gcpKeyFile)
+		check(err)
+		fields.Set("user", key.ClientEmail)
+		secretFroms[p.credentials().secrets["secret_key"]] = "file://" + *gcpKeyFile + "#private_key"
+	}
+	if *hecURL != "" {
+		fields.Set("url", *hecURL)
+	}
+	if *awsAccessKey != "" {
+		check(checkLongLivedKey(*awsAccessKey))
+		fields.Set("access_key", *awsAccessKey)
+	}
+	check(p.rotateCreds(fields))
+
+	checkArg("serviceID", strings.Join(ids, ","))
+	checkArg("loggingName", *loggingName)
+
+	finishRotations(discardMinted(eachService(ids, func(id string) outcome {
+		o := rotateEndpoint(ctx, fastlyFor(id), id, *typ, *loggingName, fields)
+		record(o)
+		return o
+	})...))
+}
+
+// eachService rotates each service in turn, going on past failures.
+func eachService(ids []string, rotate func(serviceID string) outcome) []outcome {
+	var outcomes []outcome
+	for _, id := range ids {
+		o := rotate(id)
+		if len(ids) > 1 {
+			fmt.Fprintf(messages(), "%s: %s\n", id, o.Detail)
+		}
+		outcomes = append(outcomes, o)
+	}
+	return outcomes
+}
+
+func finishRotation(o outcome) {
+	notify(o)
+	flushNotifications()
+
+	if o.Status == statusFailed || o.Status == statusUnverified {
+		check(errors.New(o.Detail))
+	}
+	fmt.Fprintln(messages(), o.Detail)
+}
+
+// finishRotations is finishRotation for several services, ending with a
+// summary of how each went.
+func finishRotations(outcomes []outcome) {
+	if len(outcomes) == 1 {
+		finishRotation(outcomes[0])
+		return
+	}
+
+	failed := 0
+	for _, o := range outcomes {
+		notify(o)
+		if o.Status == statusFailed || o.Status == statusUnverified {
+			failed++
+		}
+	}
+	flushNotifications()
+
+	fmt.Fprintln(messages())
+	w := tabwriter.NewWriter(messages(), 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVICE\tSTATUS\t")
+	for _, o := range outcomes {
+		fmt.Fprintf(w, "%s\t%s\t\n", o.ServiceID, o.Status)
+	}
+	w.Flush()
+
+	if failed > 0 {
+		check(fmt.Errorf("%d of %d service(s) failed to rotate, or weren't verified delivering", failed, len(outcomes)))
+	}
+}
+
+// rotateEndpoint sets new credentials on a logging configuration of any
+// type, unless it already has them.
+func rotateEndpoint(ctx context.Context, f *fastlyClient, serviceID, typ, loggingName string, fields url.Values) outcome {
+	failed := func(err error) outcome {
+		return outcome{ServiceID: serviceID, Status: statusFailed, Detail: err.Error()}
+	}
+
+	active, err := f.activeVersion(ctx, serviceID)
+	if err != nil {
+		return failed(err)
+	}
+
+	loggings, err := f.loggings(ctx, serviceID, active, typ)
+	if err != nil {
+		return failed(err)
+	}
+	current, ok := byName(loggings)[loggingName]
+	if !ok {
+		return failed(fmt.Errorf("Service %s has no %s logging configuration named %q", serviceID, typ, loggingName))
+	}
+
+	unchanged := true
+	for field := range fields {
+		if fmt.Sprint(current[field]) != fields.Get(field) {
+			unchanged = false
+		}
+	}
+	if unchanged {
+		return outcome{ServiceID: serviceID, Status: statusSkipped, Detail: fmt.Sprintf("%s/%s already has these credentials.", typ, loggingName)}
+	}
+
+	number, err := withDraft(ctx, f, serviceID, func(number int) error {
+		return f.updateLogging(ctx, serviceID, number, typ, loggingName, fields)
+	})
+	if errors.Is(err, errDryRun) {
+		return outcome{ServiceID: serviceID, Status: statusSkipped, Detail: err.Error()}
+	} else if err != nil {
+		return failed(err)
+	}
+
+	var old, credentials []string
+	for _, field := range sortedKeys(fields) {
+		if current[field] != nil {
+			old = append(old, fmt.Sprint(current[field]))
+		}
+		credentials = append(credentials, fields.Get(field))
+	}
+	recordRotation(typ, serviceID, loggingName, active, number, strings.Join(old, "\n"), strings.Join(credentials, "\n"))
+	return outcome{ServiceID: serviceID, Status: statusRotated, Detail: fmt.Sprintf("Activated version %d of service %s.", number, serviceID)}
+}
+
+// rotateIAMKey is the whole lifecycle of an IAM key rotation, after the new
+// key is minted: put it in place, wait until logs are delivered with it, and
+// only then deactivate or delete the old key, which is left alone if logs
+// aren't delivered. It also tells whether the new key was put in place, as
+// it then mustn't be discarded even if the rest fails.
+func rotateIAMKey(ctx context.Context, f *fastlyClient, a *awsClient, serviceID, loggingName, accessKey, secretKey, retire string, timeout, gracePeriod time.Duration) (outcome, bool) {
+	active, err := f.activeVersion(ctx, serviceID)
+	if err != nil {
+		return outcome{ServiceID: serviceID, Status: statusFailed, Detail: err.Error()}, false
+	}
+	current, err := f.s3Logging(ctx, serviceID, active, loggingName)
+	if err != nil {
+		return outcome{ServiceID: serviceID, Status: statusFailed, Detail: err.Error()}, false
+	}
+	// Refuse before rotating anything if delivery with the new key can't be
+	// verified.
+	if current.AccessKey != "" && gracePeriod == 0 {
+		if err := checkDeliveryPrefix(current); err != nil {
+			return outcome{ServiceID: serviceID, Status: statusFailed, Detail: err.Error()}, false
+		}
+	}
+
+	o := rotateService(ctx, f, serviceID, loggingName, accessKey, secretKey)
+	if current.AccessKey == "" || gracePeriod > 0 {
+		// Delivery isn't waited for before retiring the old key, but the
+		// rotation policy may still require it.
+		o = verifyPolicyDeliveries(ctx, []outcome{o})[0]
+	}
+	// Without verified delivery, the old key is left alone.
+	if o.Status != statusRotated || current.AccessKey == "" {
+		return o, o.Status == statusRotated || o.Status == statusUnverified
+	}
+	fmt.Fprintln(messages(), o.Detail)
+	activatedAt := o.activatedAt
+
+	// Any failure from here leaves the old key in place, and the rotation
+	// stands.
+	leaveOldKey := func(err error) (outcome, bool) {
+		o.Status = statusFailed
+		o.Detail = fmt.Sprintf("%s Leaving old key %s in place: %s", o.Detail, current.AccessKey, err.Error())
+		return o, true
+	}
+
+	if gracePeriod > 0 {
+		owner, err := a.accessKeyLastUsed(ctx, current.AccessKey)
+		if err != nil {
+			return leaveOldKey(err)
+		}
+		r := retirementRecord{
+			Owner:       owner.UserName,
+			NewKey:      accessKey,
+			ServiceID:   serviceID,
+			LoggingName: loggingName,
+			Action:      retire,
+			RotatedAt:   activatedAt.UTC(),
+			RetireAfter: activatedAt.Add(gracePeriod).UTC(),
+		}
+		if err := recordRetirement(current.AccessKey, r); err != nil {
+			return leaveOldKey(err)
+		}
+		o.Detail = fmt.Sprintf("%s Old key %s will be %sd by retire-keys after %s.", o.Detail, current.AccessKey, retire, r.RetireAfter.Format(time.RFC3339))
+		return o, true
+	}
+
+	if timeout == 0 {
+		timeout = keyDeliveryTimeout(int(current.Period))
+	}
+	creds, err := operatorCredentials()
+	if err != nil {
+		return leaveOldKey(err)
+	}
+	s3, err := s3ClientFor(ctx, current, creds)
+	if err != nil {
+		return leaveOldKey(err)
+	}
+	fmt.Fprintf(messages(), "Waiting up to %s for logs to be delivered to s3://%s with the new key...\n", timeout, current.BucketName)
+	object, err := waitForS3Delivery(ctx, s3, current, activatedAt, timeout)
+	if err == errTimeout {
+		err = fmt.Errorf("no logs delivered within %s", timeout)
+	}
+	if err != nil {
+		return leaveOldKey(err)
+	}
+	fmt.Fprintf(messages(), "Delivery verified: s3://%s/%s\n", current.BucketName, object)
+
+	owner, err := a.accessKeyLastUsed(ctx, current.AccessKey)
+	if retire == "delete" && err == nil {
+		// Fastly can go on using the old key for a logging period while the
+		// new version reaches every POP.
+		owner, err = a.checkOldKeyUnused(ctx, current.AccessKey, activatedAt.Add(deliveryTimeout(int(current.Period))))
+	}
+	if err != nil {
+		return leaveOldKey(err)
+	}
+	if retire == "delete" {
+		err = a.deleteAccessKey(ctx, owner.UserName, current.AccessKey)
+	} else {
+		err = a.updateAccessKey(ctx, owner.UserName, current.AccessKey, "Inactive")
+	}
+	if err != nil {
+		return leaveOldKey(err)
+	}
+
+	o.Detail = fmt.Sprintf("%s Old key %s of %s: %sd.", o.Detail, current.AccessKey, owner.UserName, retire)
+	return o, true
+}
+
+// rotateTagged rotates every S3 logging configuration with the -tag tags, on
+// the given services or across all of them.
+func rotateTagged(ctx context.Context, serviceIDs []string, accessKey, secretKey string, record func(outcome)) {
+	tagged := func(l s3Logging) bool {
+		return hasTags(l.Name, selectedTags)
+	}
+	rotateMatching(ctx, serviceIDs, tagged, "tagged "+selectedTags.String(), accessKey, secretKey, record)
+}
+
+// rotateMatching rotates every S3 logging configuration that matches, on the
+// given services or across all of them. With -canary, that service is
+// rotated and verified first, and the rest only if it succeeds. With
+// -checkpoint, a run stopped by -maxApiCalls resumes with the configurations
+// it hadn't rotated. Each outcome is passed to record as it happens.
+func rotateMatching(ctx context.Context, serviceIDs []string, match func(s3Logging) bool, matching, accessKey, secretKey string, record func(outcome)) {
+	cp := loadCheckpoint()
+	var checkpointed string
+	if cp.result("access_key", &checkpointed) && checkpointed != accessKey {
+		check(fmt.Errorf("-checkpoint %s is of a rotation to access key %s, not %s", checkpointFile, checkpointed, accessKey))
+	}
+	cp.done("access_key", accessKey)
+
+	type target struct {
+		accountService
+		l s3Logging
+	}
+	var targets, canaries []target
+	for _, s := range servicesForIDs(ctx, serviceIDs) {
+		if s.svc.Version == 0 {
+			continue
+		}
+		loggings, err := cp.s3Loggings(ctx, s)
+		cp.stopIfExhausted(err)
+		check(err)
+
+		for _, l := range loggings {
+			if !match(l) {
+				continue
+			}
+			if s.svc.ID == canaryServiceID {
+				canaries = append(canaries, target{s, l})
+			} else {
+				targets = append(targets, target{s, l})
+			}
+		}
+	}
+
+	if len(targets)+len(canaries) == 0 {
+		check(fmt.Errorf("No S3 logging configurations %s", matching))
+	}
+	if canaryServiceID != "" && len(canaries) == 0 {
+		check(fmt.Errorf("Canary %s has no S3 logging configurations %s", canaryServiceID, matching))
+	}
+
+	rotated, failed := 0, 0
+	// rotate rotates each target, and then waits for the delivery the
+	// rotation policy may require from all of them at once, rather than in
+	// turn.
+	rotate := func(ts []target) {
+		outcomes := make([]outcome, len(ts))
+		resumed := make([]bool, len(ts))
+		var outOfCalls *outcome
+		for i, t := range ts {
+			if cp.result(t.svc.ID+"/"+t.l.Name, &outcomes[i]) {
+				resumed[i] = true
+				continue
+			}
+			o := rotateService(ctx, t.f, t.svc.ID, t.l.Name, accessKey, secretKey)
+			record(o)
+			if o.Status == statusFailed && budgetSpent() {
+				// Those rotated so far are still verified and recorded
+				// before stopping.
+				outOfCalls = &o
+				ts, outcomes = ts[:i], outcomes[:i]
+				break
+			}
+			cp.done(t.svc.ID+"/"+t.l.Name, o)
+			outcomes[i] = o
+		}
+		verifyPolicyDeliveries(ctx, outcomes)
+
+		for i, o := range outcomes {
+			id := ts[i].svc.ID + "/" + ts[i].l.Name
+			if resumed[i] {
+				fmt.Fprintf(messages(), "%s: %s (before resuming)\n", id, o.Detail)
+			} else {
+				cp.done(id, o)
+				notify(o)
+				fmt.Fprintf(messages(), "%s: %s\n", id, o.Detail)
+			}
+			if o.Status == statusFailed || o.Status == statusUnverified {
+				failed++
+			} else {
+				rotated++
+			}
+		}
+		if outOfCalls != nil {
+			cp.stopIfOutOfCalls(*outOfCalls)
+		}
+	}
+
+	if len(canaries) > 0 {
+		var loggings []s3Logging
+		rotate(canaries)
+		for _, t := range canaries {
+			loggings = append(loggings, t.l)
+		}
+		// Once every canary is activated.
+		rotatedAt := time.Now()
+		if failed > 0 {
+			flushNotifications()
+			check(fmt.Errorf("Canary %s failed to rotate, not rotating the other %d logging configuration(s)", canaryServiceID, len(targets)))
+		}
+		var verified bool
+		if !dryRun && !cp.result("canary", &verified) {
+			if err := verifyCanary(ctx, loggings, rotatedAt); err != nil {
+				notify(outcome{ServiceID: canaryServiceID, Status: statusFailed, Detail: "Canary not verified: " + err.Error()})
+				flushNotifications()
+				check(fmt.Errorf("Canary %s not verified, not rotating the other %d logging configuration(s): %s", canaryServiceID, len(targets), err.Error()))
+			}
+			cp.done("canary", true)
+		}
+	}
+
+	rotate(targets)
+	flushNotifications()
+	cp.finish()
+
+	if failed > 0 {
+		check(fmt.Errorf("%d of %d logging configuration(s) failed to rotate, or weren't verified delivering", failed, rotated+failed))
+	}
+}
+
+// rotateService puts a new key pair in place on one service's S3 logging
+// configuration.
+func rotateService(ctx context.Context, f *fastlyClient, serviceID, loggingName, accessKey, secretKey string) outcome {
+	return rotateServiceTo(ctx, f, serviceID, loggingName, accessKey, secretKey, "", "")
+}
+
+// rotateServiceTo is rotateService, also moving the logs to another bucket
+// or path if they're given, as for a fleet manifest entry.
+func rotateServiceTo(ctx context.Context, f *fastlyClient, serviceID, loggingName, accessKey, secretKey, bucketName, logPath string) outcome {
+	failed := func(err error) outcome {
+		return outcome{ServiceID: serviceID, Status: statusFailed, Detail: err.Error()}
+	}
+
+	active, err := f.activeVersion(ctx, serviceID)
+	if err != nil {
+		return failed(err)
+	}
+
+	current, err := f.s3Logging(ctx, serviceID, active, loggingName)
+	if err != nil {
+		return failed(err)
+	}
+
+	form := url.Values{"access_key": {accessKey}, "secret_key": {secretKey}}
+	moved := false
+	if bucketName != "" && bucketName != current.BucketName {
+		form.Set("bucket_name", bucketName)
+		current.BucketName, moved = bucketName, true
+	}
+	if logPath != "" && logPath != current.Path {
+		form.Set("path", logPath)
+		current.Path, moved = logPath, true
+	}
+	if domain, ok := providerDomain(); ok && domain != current.Domain {
+		form.Set("domain", domain)
+		current.Domain = domain
+	} else if current.AccessKey == accessKey && !moved {
+		return outcome{ServiceID: serviceID, Status: statusSkipped, Detail: fmt.Sprintf("%s already uses access key %s.", loggingName, accessKey)}
+	}
+
+	// Check the new key can write before changing Fastly at all, so a bad
+	// key doesn't leave a discarded draft on every service it's tried on.
+	// The draft's own write check then checks the configuration as cloned.
+	if !skipWriteCheck && !dryRun {
+		object, err := checkWriteAccess(ctx, current, awsCredentials{accessKey: accessKey, secretKey: secretKey})
+		if err != nil {
+			return failed(err)
+		}
+		fmt.Fprintf(messages(), "Write check passed: %s\n", object)
+	}
+
+	number, err := withDraft(ctx, f, serviceID, func(number int) error {
+		return f.updateLogging(ctx, serviceID, number, "s3", loggingName, form)
+	})
+	if errors.Is(err, errDryRun) {
+		return outcome{ServiceID: serviceID, Status: statusSkipped, Detail: err.Error()}
+	} else if err != nil {
+		return failed(err)
+	}
+
+	recordRotation("s3", serviceID, loggingName, active, number, current.AccessKey, accessKey)
+	return outcome{ServiceID: serviceID, Status: statusRotated, Detail: fmt.Sprintf("Activated version %d of service %s.", number, serviceID), activatedAt: time.Now(), rotated: &current}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"flag"
+)
+
+// rotateKey replaces an access key everywhere it is used: every S3 logging
+// configuration on every service (across all configured accounts) that has
+// it is rotated to the new key, without knowing the services in advance.
+func rotateKey(args []string) {
+	fs := flag.NewFlagSet("rotate-key", flag.ExitOnError)
+	oldKey := fs.String("accessKey", "", "The AWS Access Key to replace.")
+	awsAccessKey := fs.String("awsAccessKey", "", "The new AWS Access Key.")
+	awsProfile := fs.String("awsProfile", "", "Profile of the AWS shared credentials file to take the new access key and secret from, in place of -awsAccessKey and AWS_SECRET_KEY.")
+	canaryFlags(fs)
+	checkpointFlags(fs)
+	draftFlags(fs)
+	notifyFlags(fs)
+	commonFlags(fs)
+
+	fs.Usage = commandUsage(fs, "Note, FASTLY_KEY (or the tokens of each account in the config file) and AWS_SECRET_KEY must be provided as env vars.")
+	parseFlags(fs, args)
+
+	checkArg("accessKey", *oldKey)
+	if *awsProfile != "" {
+		*awsAccessKey = useAWSProfile(*awsProfile)
+	}
+
+	var awsSecretKey string
+	if !dryRun {
+		awsSecretKey = secret("AWS_SECRET_KEY")
+		checkArg("awsAccessKey", *awsAccessKey)
+		checkArg("AWS_SECRET_KEY", awsSecretKey)
+		check(checkLongLivedKey(*awsAccessKey))
+	}
+
+	usesKey := func(l s3Logging) bool {
+		return l.AccessKey == *oldKey
+	}
+	rotateMatching(context.Background(), nil, usesKey, "using access key "+*oldKey, *awsAccessKey, awsSecretKey, func(outcome) {})
+}
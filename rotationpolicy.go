@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotationPolicy is the config file's rotation_policy, which every change is
+// held to unless -override is given.
+type rotationPolicy struct {
+	// MaxKeyAgeDays is the default -maxKeyAge of sla-report and audit, which
+	// fail if any key is older, and the age at which the daemon rotates a
+	// key without waiting for its schedule.
+	MaxKeyAgeDays int `json:"max_key_age_days"`
+	// Window is when changes can be made.
+	Window *changeWindow `json:"window"`
+	// Require lists checks that can't be skipped: write_check (so no
+	// -skipWriteCheck), delivery (waiting for logs after rotating S3 logging)
+	// and approval (so -approvedBy must be given).
+	Require []string `json:"require"`
+}
+
+// changeWindow is a daily window, e.g. {"days": ["Mon", "Tue", "Wed",
+// "Thu"], "start": "09:00", "end": "16:00", "timezone": "Europe/London"}.
+type changeWindow struct {
+	// Days defaults to every day.
+	Days  []string `json:"days"`
+	Start string   `json:"start"`
+	End   string   `json:"end"`
+	// Timezone is an IANA time zone, defaulting to UTC.
+	Timezone string `json:"timezone"`
+}
+
+var policyChecks = []string{"write_check", "delivery", "approval"}
+
+// overridePolicy is -override.
+var overridePolicy bool
+
+// requiresCheck tells whether the rotation policy requires a check, e.g.
+// delivery.
+func requiresCheck(name string) bool {
+	return contains(loadConfig().RotationPolicy.Require, name) && !overridePolicy
+}
+
+// maxKeyAgeDays is the default -maxKeyAge: the rotation policy's, or 90 days.
+func maxKeyAgeDays() int {
+	if days := loadConfig().RotationPolicy.MaxKeyAgeDays; days > 0 {
+		return days
+	}
+	return 90
+}
+
+// checkMaxKeyAge returns an error if any of the ages of keys is over the
+// rotation policy's max_key_age_days, so that a report of them fails.
+func checkMaxKeyAge(ages []time.Duration) error {
+	days := loadConfig().RotationPolicy.MaxKeyAgeDays
+	if days <= 0 {
+		return nil
+	}
+	over := 0
+	for _, age := range ages {
+		if age > time.Duration(days)*24*time.Hour {
+			over++
+		}
+	}
+	if over == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d key(s) are older than the rotation policy's max_key_age_days of %d, and must be rotated", over, days)
+}
+
+// checkRotationPolicy returns an error if making a change now breaks the
+// rotation policy, or prints what -override is allowing.
+func checkRotationPolicy(now time.Time) error {
+	p := loadConfig().RotationPolicy
+
+	var violations []string
+	for _, r := range p.Require {
+		if !contains(policyChecks, r) {
+			return fmt.Errorf("Unknown check %q in rotation_policy require, expected one of %s", r, strings.Join(policyChecks, ","))
+		}
+	}
+	if contains(p.Require, "write_check") && skipWriteCheck {
+		violations = append(violations, "it requires write checks, so -skipWriteCheck can't be given")
+	}
+	if contains(p.Require, "approval") && approvedBy == "" {
+		violations = append(violations, "it requires -approvedBy")
+	}
+	if p.Window != nil {
+		open, err := p.Window.contains(now)
+		if err != nil {
+			return err
+		}
+		if !open {
+			violations = append(violations, fmt.Sprintf("it is outside the change window (%s)", p.Window))
+		}
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+	if overridePolicy {
+		fmt.Fprintf(messages(), "Overriding the rotation policy: %s.\n", strings.Join(violations, "; "))
+		return nil
+	}
+	return errors.New("The rotation policy forbids this change, as " + strings.Join(violations, "; ") + ". Give -override to make it anyway")
+}
+
+// contains tells whether t is within the window.
+func (w changeWindow) contains(t time.Time) (bool, error) {
+	loc := time.UTC
+	if w.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(w.Timezone); err != nil {
+			return false, fmt.Errorf("Invalid rotation_policy window timezone: %s", err.Error())
+		}
+	}
+	t = t.In(loc)
+
+	if len(w.Days) > 0 && !contains(w.Days, t.Weekday().String()[:3]) {
+		return false, nil
+	}
+
+	start, end := 0, 24*60
+	var err error
+	if w.Start != "" {
+		if start, err = minuteOfDay(w.Start); err != nil {
+			return false, err
+		}
+	}
+	if w.End != "" {
+		if end, err = minuteOfDay(w.End); err != nil {
+			return false, err
+		}
+	}
+	now := t.Hour()*60 + t.Minute()
+	if start > end {
+		// e.g. 22:00-02:00, overnight
+		return now >= start || now < end, nil
+	}
+	return now >= start && now < end, nil
+}
+
+// minuteOfDay parses a window time, HH:MM (or H:MM), as minutes since
+// midnight, up to 24:00.
+func minuteOfDay(hm string) (int, error) {
+	invalid := fmt.Errorf("Invalid rotation_policy window time %q, expected HH:MM", hm)
+	parts := strings.Split(hm, ":")
+	if len(parts) != 2 || len(parts[1]) != 2 {
+		return 0, invalid
+	}
+	h, err := strconv.Atoi(parts[0])
+	if err != nil || h < 0 || h > 24 {
+		return 0, invalid
+	}
+	m, err := strconv.Atoi(parts[1])
+	if err != nil || m < 0 || m > 59 || h == 24 && m != 0 {
+		return 0, invalid
+	}
+	return h*60 + m, nil
+}
+
+func (w changeWindow) String() string {
+	days := "every day"
+	if len(w.Days) > 0 {
+		days = strings.Join(w.Days, ",")
+	}
+	tz := w.Timezone
+	if tz == "" {
+		tz = "UTC"
+	}
+	return fmt.Sprintf("%s %s-%s %s", days, w.Start, w.End, tz)
+}
+
+// verifyPolicyDeliveries is verifyPolicyDelivery for the S3 logging
+// configurations rotated by each of outcomes, waiting for them all at once
+// rather than up to two logging periods for each in turn. Those that aren't
+// delivered become unverified.
+func verifyPolicyDeliveries(ctx context.Context, outcomes []outcome) []outcome {
+	var wg sync.WaitGroup
+	for i := range outcomes {
+		o := &outcomes[i]
+		if o.Status != statusRotated || o.rotated == nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := verifyPolicyDelivery(ctx, *o.rotated, o.activatedAt); err != nil {
+				o.Status = statusUnverified
+				o.Detail = fmt.Sprintf("%s Logs weren't delivered with the new key: %s", o.Detail, err.Error())
+			}
+		}()
+	}
+	wg.Wait()
+	return outcomes
+}
+
+// verifyPolicyDelivery waits for logs to be delivered by a rotated S3 logging
+// configuration, if the rotation policy requires it.
+func verifyPolicyDelivery(ctx context.Context, l s3Logging, activatedAt time.Time) error {
+	if !requiresCheck("delivery") {
+		return nil
+	}
+
+	creds, err := operatorCredentials()
+	if err != nil {
+		return err
+	}
+	if creds.accessKey == "" {
+		return errors.New("The rotation policy requires delivery to be verified, with AWS credentials able to list the bucket in the standard AWS env vars")
+	}
+	a, err := s3ClientFor(ctx, l, creds)
+	if err != nil {
+		return err
+	}
+
+	timeout := keyDeliveryTimeout(int(l.Period))
+	fmt.Fprintf(messages(), "Waiting up to %s for logs to be delivered to s3://%s...\n", timeout, l.BucketName)
+	object, err := waitForS3Delivery(ctx, a, l, activatedAt, timeout)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(messages(), "Delivery verified: s3://%s/%s\n", l.BucketName, object)
+	return nil
+}
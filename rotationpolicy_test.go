@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChangeWindowContains(t *testing.T) {
+	// 2024-01-03 is a Wednesday.
+	wednesday := func(hm string) time.Time {
+		t, err := time.Parse("2006-01-02 15:04", "2024-01-03 "+hm)
+		if err != nil {
+			panic(err)
+		}
+		return t
+	}
+	expect := func(w changeWindow, at time.Time, want bool) {
+		t.Helper()
+		got, err := w.contains(at)
+		if err != nil {
+			t.Errorf("%s contains(%s): %s", w, at.Format(time.RFC3339), err)
+		} else if got != want {
+			t.Errorf("%s contains(%s) = %t, want %t", w, at.Format(time.RFC3339), got, want)
+		}
+	}
+
+	working := changeWindow{Start: "9:00", End: "16:00"}
+	expect(working, wednesday("08:59"), false)
+	expect(working, wednesday("09:00"), true)
+	expect(working, wednesday("15:59"), true)
+	expect(working, wednesday("16:00"), false)
+
+	overnight := changeWindow{Start: "22:00", End: "02:00"}
+	expect(overnight, wednesday("23:30"), true)
+	expect(overnight, wednesday("01:59"), true)
+	expect(overnight, wednesday("02:00"), false)
+	expect(overnight, wednesday("12:00"), false)
+
+	expect(changeWindow{}, wednesday("03:00"), true)
+	expect(changeWindow{Start: "20:00", End: "24:00"}, wednesday("23:59"), true)
+	expect(changeWindow{Days: []string{"Mon", "Wed"}}, wednesday("12:00"), true)
+	expect(changeWindow{Days: []string{"Sat", "Sun"}}, wednesday("12:00"), false)
+
+	if _, err := time.LoadLocation("Europe/London"); err == nil {
+		// 09:30 UTC is 10:30 in London, in summer.
+		summer := time.Date(2024, 7, 3, 9, 30, 0, 0, time.UTC)
+		expect(changeWindow{Start: "10:00", End: "11:00", Timezone: "Europe/London"}, summer, true)
+		expect(changeWindow{Start: "10:00", End: "11:00"}, summer, false)
+	}
+
+	for _, w := range []changeWindow{
+		{Start: "9"},
+		{Start: "9:0"},
+		{End: "25:00"},
+		{End: "24:30"},
+		{Timezone: "Nowhere/Special"},
+	} {
+		if _, err := w.contains(wednesday("12:00")); err == nil {
+			t.Errorf("%+v contains() succeeded, want an error", w)
+		}
+	}
+}
+
+func TestCheckMaxKeyAge(t *testing.T) {
+	loadConfig()
+	defer func(p rotationPolicy) { cfg.RotationPolicy = p }(cfg.RotationPolicy)
+
+	day := 24 * time.Hour
+	ages := []time.Duration{10 * day, 40 * day}
+	if err := checkMaxKeyAge(ages); err != nil {
+		t.Errorf("checkMaxKeyAge() with no max_key_age_days: %s", err)
+	}
+
+	cfg.RotationPolicy.MaxKeyAgeDays = 90
+	if err := checkMaxKeyAge(ages); err != nil {
+		t.Errorf("checkMaxKeyAge() of keys under max_key_age_days: %s", err)
+	}
+	cfg.RotationPolicy.MaxKeyAgeDays = 30
+	if err := checkMaxKeyAge(ages); err == nil {
+		t.Error("checkMaxKeyAge() of a key over max_key_age_days succeeded")
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// s3Host is the S3 endpoint for a Fastly logging domain (blank for AWS).
+func s3Host(domain, region string) string {
+	if domain != "" {
+		return domain
+	}
+	if region == "" || region == "us-east-1" {
+		return "s3.amazonaws.com"
+	}
+	return fmt.Sprintf("s3.%s.amazonaws.com", region)
+}
+
+// S3-compatible providers, and the S3 domain of each region.
+var s3Providers = map[string]func(region string) string{
+	"aws": func(region string) string {
+		return "" // Fastly's default
+	},
+	"spaces": func(region string) string {
+		return region + ".digitaloceanspaces.com"
+	},
+}
+
+var s3Provider, s3Region string
+
+// providerFlags adds the flags of commands that configure S3 endpoints.
+func providerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&s3Provider, "provider", "", "S3-compatible provider to configure the domain of the endpoint for: aws or spaces (DigitalOcean Spaces) (default: leave the domain as it is).")
+	fs.StringVar(&s3Region, "region", "", "Region of the bucket with -provider, e.g. nyc3 for Spaces.")
+}
+
+// providerDomain is the domain for -provider and -region, and whether to
+// set it: only when -provider is given.
+func providerDomain() (domain string, change bool) {
+	if s3Provider == "" {
+		return "", false
+	}
+
+	provider, ok := s3Providers[s3Provider]
+	if !ok {
+		check(fmt.Errorf("Unknown -provider '%s', expected aws or spaces", s3Provider))
+	}
+	if s3Provider != "aws" {
+		checkArg("region", s3Region)
+	}
+	return provider(s3Region), true
+}
+
+// domainRegion is the signing region of the providers whose domain names it,
+// as Spaces buckets don't report their region like AWS.
+func domainRegion(domain string) string {
+	if strings.HasSuffix(domain, ".digitaloceanspaces.com") {
+		return strings.SplitN(domain, ".", 2)[0]
+	}
+	return ""
+}
+
+// headBucket makes an anonymous HeadBucket request: S3 answers 404 for
+// buckets that don't exist, and 301/403 (along with the bucket's region) for
+// ones that do but aren't ours to read anonymously.
+func headBucket(ctx context.Context, domain, bucket string) (exists bool, region string, err error) {
+	u := url.URL{Scheme: "https", Host: s3Host(domain, ""), Path: "/" + bucket}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u.String(), nil)
+	if err != nil {
+		return false, "", err
+	}
+
+	client := *httpClient()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, "", err
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode != http.StatusNotFound, resp.Header.Get("X-Amz-Bucket-Region"), nil
+}
+
+// s3Object addresses an object path-style, which (unlike virtual hosting)
+// works for bucket names containing dots.
+func (a *awsClient) s3Object(method, domain, bucket, key string, header http.Header, body []byte) awsRequest {
+	return awsRequest{
+		service: "s3",
+		method:  method,
+		host:    s3Host(domain, a.region),
+		path:    "/" + bucket + "/" + key,
+		header:  header,
+		body:    body,
+	}
+}
+
+func (a *awsClient) putObject(ctx context.Context, domain, bucket, key string, header http.Header, body []byte) error {
+	_, _, err := a.do(ctx, "s3:PutObject", a.s3Object(http.MethodPut, domain, bucket, key, header, body))
+	return err
+}
+
+func (a *awsClient) getObject(ctx context.Context, domain, bucket, key string) ([]byte, error) {
+	body, _, err := a.do(ctx, "s3:GetObject", a.s3Object(http.MethodGet, domain, bucket, key, nil, nil))
+	return body, err
+}
+
+func (a *awsClient) deleteObject(ctx context.Context, domain, bucket, key string) error {
+	_, _, err := a.do(ctx, "s3:DeleteObject", a.s3Object(http.MethodDelete, domain, bucket, key, nil, nil))
+	return err
+}
+
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_ListObjectsV2.html
+type s3ObjectInfo struct {
+	Key          string    `xml:"Key"`
+	Size         int64     `xml:"Size"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+// listObjects lists the objects under prefix, failing rather than listing
+// more than maxPages pages of them (0 for no limit).
+func (a *awsClient) listObjects(ctx context.Context, domain, bucket, prefix string, maxPages int) ([]s3ObjectInfo, error) {
+	var objects []s3ObjectInfo
+	token := ""
+
+	for pages := 1; ; pages++ {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		r := a.s3Object(http.MethodGet, domain, bucket, "", nil, nil)
+		r.path = "/" + bucket
+		r.query = query
+		body, _, err := a.do(ctx, "s3:ListObjectsV2", r)
+		if err != nil {
+			return nil, err
+		}
+
+		var page struct {
+			Contents              []s3ObjectInfo `xml:"Contents"`
+			IsTruncated           bool           `xml:"IsTruncated"`
+			NextContinuationToken string         `xml:"NextContinuationToken"`
+		}
+		if err := xml.Unmarshal(body, &page); err != nil {
+			return nil, err
+		}
+
+		objects = append(objects, page.Contents...)
+		if !page.IsTruncated {
+			return objects, nil
+		}
+		if maxPages > 0 && pages >= maxPages {
+			return nil, fmt.Errorf("More than %d pages of objects under s3://%s/%s", maxPages, bucket, prefix)
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// s3ClientFor is a client for the region of a logging configuration's bucket.
+func s3ClientFor(ctx context.Context, l s3Logging, creds awsCredentials) (*awsClient, error) {
+	_, region, err := headBucket(ctx, l.Domain, l.BucketName)
+	if err != nil {
+		return nil, err
+	}
+	if region == "" {
+		region = domainRegion(l.Domain)
+	}
+	return newAWSClient(creds, region), nil
+}
+
+// checkWriteAccess proves creds can write where Fastly will write for l, by
+// putting a small probe object under its path with the same ACL and
+// encryption headers Fastly sends. Cross-account buckets typically require
+// an ACL such as bucket-owner-full-control, which this checks too.
+func checkWriteAccess(ctx context.Context, l s3Logging, creds awsCredentials) (string, error) {
+	a, err := s3ClientFor(ctx, l, creds)
+	if err != nil {
+		return "", err
+	}
+
+	header := http.Header{"Content-Type": {"text/plain"}}
+	if l.ACL != "" {
+		header.Set("X-Amz-Acl", l.ACL)
+	}
+	if l.SSE != "" {
+		header.Set("X-Amz-Server-Side-Encryption", l.SSE)
+	}
+	if l.SSEKMSKeyID != "" {
+		header.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", l.SSEKMSKeyID)
+	}
+
+	now := time.Now().UTC()
+	key := strings.TrimPrefix(strftime(l.Path, now), "/") + fmt.Sprintf("fastly-logging-creds-write-check-%d.txt", now.Unix())
+	body := []byte("Write access check by fastly-logging-creds. Safe to delete.\n")
+
+	if err := a.putObject(ctx, l.Domain, l.BucketName, key, header, body); err != nil {
+		return "", fmt.Errorf("Write check to s3://%s/%s failed: %s", l.BucketName, key, err.Error())
+	}
+
+	// Fastly only needs PutObject, so the key may well not be allowed to
+	// delete; the probe is harmless to leave behind.
+	a.deleteObject(ctx, l.Domain, l.BucketName, key)
+
+	return fmt.Sprintf("s3://%s/%s", l.BucketName, key), nil
+}
+
+// strftime expands the common strftime escapes that Fastly supports in
+// logging paths.
+func strftime(format string, t time.Time) string {
+	r := strings.NewReplacer(
+		"%Y", t.Format("2006"),
+		"%y", t.Format("06"),
+		"%m", t.Format("01"),
+		"%d", t.Format("02"),
+		"%H", t.Format("15"),
+		"%M", t.Format("04"),
+		"%S", t.Format("05"),
+		"%j", fmt.Sprintf("%03d", t.YearDay()),
+		"%%", "%",
+	)
+	return r.Replace(format)
+}
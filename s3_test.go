@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListObjectsMaxPages(t *testing.T) {
+	pages := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pages++
+		fmt.Fprintf(w, "<ListBucketResult><Contents><Key>logs/%d.log</Key></Contents><IsTruncated>true</IsTruncated><NextContinuationToken>%d</NextContinuationToken></ListBucketResult>", pages, pages)
+	}))
+	t.Cleanup(server.Close)
+
+	u := strings.TrimPrefix(server.URL, "http://")
+	a := &awsClient{region: "eu-west-1", client: &http.Client{Transport: testServerTransport(u)}}
+
+	_, err := a.listObjects(context.Background(), "", "bucket", "logs/", 3)
+	if err == nil {
+		t.Fatal("listObjects listed past maxPages")
+	}
+	if pages != 3 {
+		t.Errorf("listObjects listed %d pages, want 3", pages)
+	}
+}
+
+// TestWaitForS3DeliveryNoPrefix covers a path with no directory, which
+// mustn't be polled for by listing the whole bucket.
+func TestWaitForS3DeliveryNoPrefix(t *testing.T) {
+	a := &awsClient{region: "eu-west-1", client: &http.Client{Transport: testServerTransport("127.0.0.1:1")}}
+	l := s3Logging{Name: "logs", BucketName: "bucket", Path: "/", Period: 300}
+	if _, err := waitForS3Delivery(context.Background(), a, l, time.Now(), time.Minute); err == nil {
+		t.Error("waitForS3Delivery() polled a path with no directory")
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+)
+
+// schemas are JSON Schemas of the JSON output of commands, for tooling that
+// consumes it. Each schema's $id is versioned: fields may be added within a
+// version, but changing or removing one means a new version.
+var schemas = map[string]string{
+	"describe": `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "$id": "https://github.com/guardian/fastly-logging-creds/schemas/describe/v1.json",
+  "title": "Logging configuration of a service version, by endpoint type",
+  "description": "Configurations are sorted by name, version bookkeeping fields are dropped, and secrets are masked as ****last4 (fingerprint).",
+  "type": "object",
+  "additionalProperties": {
+    "type": "array",
+    "items": {
+      "type": "object",
+      "properties": {
+        "name": {"type": "string"}
+      },
+      "additionalProperties": true
+    }
+  }
+}`,
+
+	"sla-report": `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "$id": "https://github.com/guardian/fastly-logging-creds/schemas/sla-report/v1.json",
+  "title": "A line of sla-report -jsonLines output: the credentials of one service",
+  "type": "object",
+  "required": ["service_id", "service_name", "credentials"],
+  "properties": {
+    "service_id": {"type": "string"},
+    "service_name": {"type": "string"},
+    "credentials": {
+      "type": ["array", "null"],
+      "items": {
+        "type": "object",
+        "required": ["service", "endpoint", "key", "age"],
+        "properties": {
+          "service": {
+            "type": "object",
+            "properties": {
+              "id": {"type": "string"},
+              "name": {"type": "string"},
+              "version": {"type": "integer"}
+            }
+          },
+          "endpoint": {"type": "string", "description": "type/name of the logging configuration"},
+          "key": {"type": "string", "description": "The access key, or a fingerprint of other credentials"},
+          "age": {"type": "integer", "description": "Age of the credential in nanoseconds"},
+          "error": {"type": "string", "description": "Why the age couldn't be found, if it couldn't"}
+        }
+      }
+    }
+  }
+}`,
+
+	"plan": `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "$id": "https://github.com/guardian/fastly-logging-creds/schemas/plan/v1.json",
+  "title": "A signed change bundle, created by plan and applied by apply",
+  "type": "object",
+  "required": ["service_id", "base_version", "changes", "created_at", "expires_at", "signature"],
+  "properties": {
+    "service_id": {"type": "string"},
+    "base_version": {"type": "integer"},
+    "changes": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "required": ["type", "name"],
+        "properties": {
+          "type": {"type": "string"},
+          "name": {"type": "string"},
+          "old": {"type": "object", "additionalProperties": {"type": "string"}},
+          "set": {"type": "object", "additionalProperties": {"type": "string"}},
+          "secrets": {
+            "type": "object",
+            "additionalProperties": {
+              "type": "object",
+              "required": ["env", "sha256"],
+              "properties": {
+                "env": {"type": "string"},
+                "sha256": {"type": "string"}
+              }
+            }
+          }
+        }
+      }
+    },
+    "created_at": {"type": "string", "format": "date-time"},
+    "expires_at": {"type": "string", "format": "date-time"},
+    "signature": {"type": "string"}
+  }
+}`,
+}
+
+// schemaFlag adds -schema to a command with JSON output.
+func schemaFlag(fs *flag.FlagSet) *bool {
+	return fs.Bool("schema", false, "Print the JSON Schema of the command's JSON output, and exit.")
+}
+
+func printSchema(command string) {
+	fmt.Println(schemas[command])
+}
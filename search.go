@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// search finds services by fuzzy matching their names and IDs, and shows the
+// logging of the best matches.
+func search(args []string) {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	limit := fs.Int("limit", 10, "Maximum number of matches to show.")
+	commonFlags(fs)
+
+	fs.Usage = commandUsage(fs, "Takes the search query as its argument, e.g. search [flags] front.\n\nNote, FASTLY_KEY (or the tokens of each account in the config file) must be provided as env vars.")
+	parseFlags(fs, args)
+
+	query := strings.Join(fs.Args(), " ")
+	checkArg("query", query)
+	ctx := context.Background()
+
+	type match struct {
+		accountService
+		score int
+	}
+	var matches []match
+	for _, s := range servicesFor(ctx, "") {
+		score := fuzzyScore(query, s.svc.Name)
+		if id := fuzzyScore(query, s.svc.ID); id > score {
+			score = id
+		}
+		if score > 0 {
+			matches = append(matches, match{s, score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].score > matches[j].score })
+	if len(matches) > *limit {
+		matches = matches[:*limit]
+	}
+
+	if len(matches) == 0 {
+		fmt.Printf("No services match '%s'.\n", query)
+		os.Exit(1)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tID\tVERSION\tLOGGING")
+	for _, m := range matches {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", m.svc.Name, m.svc.ID, m.svc.Version, loggingSummary(ctx, m.f, m.svc))
+	}
+	w.Flush()
+}
+
+// loggingSummary counts a service's logging configurations by type.
+func loggingSummary(ctx context.Context, f *fastlyClient, s service) string {
+	if s.Version == 0 {
+		return "no active version"
+	}
+
+	var counts []string
+	for _, t := range loggingTypes {
+		loggings, err := f.listableLoggings(ctx, s.ID, s.Version, t)
+		if err != nil {
+			return err.Error()
+		}
+		if len(loggings) > 0 {
+			counts = append(counts, fmt.Sprintf("%s:%d", t, len(loggings)))
+		}
+	}
+	if len(counts) == 0 {
+		return "none"
+	}
+	return strings.Join(counts, " ")
+}
+
+// fuzzyScore rates how well s matches query, ignoring case: exact matches
+// best, then substrings (earlier is better), then the query's characters
+// appearing in order (closer together is better). Zero means no match.
+func fuzzyScore(query, s string) int {
+	query, s = strings.ToLower(query), strings.ToLower(s)
+	if query == s {
+		return 1000
+	}
+	if i := strings.Index(s, query); i >= 0 {
+		return 500 - i
+	}
+
+	gaps, last := 0, -1
+	for _, c := range query {
+		i := strings.IndexRune(s[last+1:], c)
+		if i < 0 {
+			return 0
+		}
+		if last >= 0 {
+			gaps += i
+		}
+		last += 1 + i
+	}
+	if score := 250 - gaps; score > 0 {
+		return score
+	}
+	return 1
+}
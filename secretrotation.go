@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// managedSecret is the value of a Secrets Manager secret rotated by
+// rotate-secret: an IAM user's access key, and the S3 logging configuration
+// that uses it.
+type managedSecret struct {
+	ServiceID       string `json:"service_id"`
+	LoggingName     string `json:"logging_name"`
+	IAMUser         string `json:"iam_user"`
+	AccessKeyID     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key"`
+}
+
+func (s managedSecret) creds() awsCredentials {
+	return awsCredentials{accessKey: s.AccessKeyID, secretKey: s.SecretAccessKey}
+}
+
+// rotateSecret is one step of a Secrets Manager rotation of a managedSecret,
+// for serving as the secret's rotation Lambda function:
+//
+//   - createSecret mints a new key for the IAM user, as the AWSPENDING version.
+//   - setSecret puts the pending key in place on the S3 logging configuration.
+//   - testSecret checks the key in use can write to the logging bucket.
+//   - finishSecret makes the pending version AWSCURRENT.
+//
+// Every step can be retried. An IAM user can only have two keys, so
+// createSecret deletes the key of a previous rotation that nothing uses.
+//
+// https://docs.aws.amazon.com/secretsmanager/latest/userguide/rotate-secrets_how.html
+func rotateSecret(args []string) {
+	fs := flag.NewFlagSet("rotate-secret", flag.ExitOnError)
+	secretID := fs.String("secretId", "", "ARN (or name) of the Secrets Manager secret being rotated.")
+	token := fs.String("token", "", "The rotation's ClientRequestToken: the version ID of the new version of the secret.")
+	step := fs.String("step", "", "Step of the rotation: createSecret, setSecret, testSecret or finishSecret.")
+	cloudTrailFlags(fs)
+	draftFlags(fs)
+	notifyFlags(fs)
+	commonFlags(fs)
+
+	fs.Usage = commandUsage(fs, "Note, FASTLY_KEY must be provided as an env var, and AWS credentials with access to the secret and the IAM user's keys in the standard AWS env vars.")
+	parseFlags(fs, args)
+	refuseDryRun("rotate-secret")
+
+	checkArg("secretId", *secretID)
+	checkArg("token", *token)
+	checkArg("step", *step)
+
+	ctx := context.Background()
+	sm := newAWSClient(awsEnvCredentials(), secretRegion(*secretID))
+
+	var described struct {
+		RotationEnabled    bool                `json:"RotationEnabled"`
+		LastRotatedDate    float64             `json:"LastRotatedDate"`
+		VersionIdsToStages map[string][]string `json:"VersionIdsToStages"`
+	}
+	check(sm.jsonAPI(ctx, "secretsmanager", "secretsmanager.DescribeSecret", map[string]string{"SecretId": *secretID}, &described))
+	if !described.RotationEnabled {
+		check(fmt.Errorf("Rotation is not enabled for secret %s", *secretID))
+	}
+	stages, ok := described.VersionIdsToStages[*token]
+	if !ok {
+		check(fmt.Errorf("Secret %s has no version %s to rotate to", *secretID, *token))
+	}
+	if contains(stages, "AWSCURRENT") {
+		fmt.Fprintf(messages(), "Version %s of secret %s is already current.\n", *token, *secretID)
+		return
+	}
+	if !contains(stages, "AWSPENDING") {
+		check(fmt.Errorf("Version %s of secret %s is not pending rotation", *token, *secretID))
+	}
+
+	switch *step {
+	case "createSecret":
+		lastRotated := time.Unix(int64(described.LastRotatedDate), 0)
+		check(createPendingSecret(ctx, sm, *secretID, *token, lastRotated))
+	case "setSecret":
+		pending, err := managedSecretVersion(ctx, sm, *secretID, *token, "AWSPENDING")
+		check(err)
+		check(waitForAccessKey(ctx, pending.creds()))
+
+		o := rotateService(ctx, fastlyFor(pending.ServiceID), pending.ServiceID, pending.LoggingName, pending.AccessKeyID, pending.SecretAccessKey)
+		finishRotation(verifyPolicyDeliveries(ctx, []outcome{o})[0])
+	case "testSecret":
+		pending, err := managedSecretVersion(ctx, sm, *secretID, *token, "AWSPENDING")
+		check(err)
+
+		f := fastlyFor(pending.ServiceID)
+		active, err := f.activeVersion(ctx, pending.ServiceID)
+		check(err)
+		l, err := f.s3Logging(ctx, pending.ServiceID, active, pending.LoggingName)
+		check(err)
+		if l.AccessKey != pending.AccessKeyID {
+			check(fmt.Errorf("%s of service %s uses access key %s, not the pending %s", pending.LoggingName, pending.ServiceID, l.AccessKey, pending.AccessKeyID))
+		}
+
+		object, err := checkWriteAccess(ctx, l, pending.creds())
+		check(err)
+		fmt.Fprintf(messages(), "Wrote %s with access key %s.\n", object, pending.AccessKeyID)
+	case "finishSecret":
+		params := map[string]string{"SecretId": *secretID, "VersionStage": "AWSCURRENT", "MoveToVersionId": *token}
+		for version, stages := range described.VersionIdsToStages {
+			if contains(stages, "AWSCURRENT") {
+				params["RemoveFromVersionId"] = version
+			}
+		}
+		check(sm.jsonAPI(ctx, "secretsmanager", "secretsmanager.UpdateSecretVersionStage", params, nil))
+		fmt.Fprintf(messages(), "Version %s of secret %s is now current.\n", *token, *secretID)
+	default:
+		check(fmt.Errorf("Unknown -step '%s', expected createSecret, setSecret, testSecret or finishSecret", *step))
+	}
+}
+
+// createPendingSecret mints a new access key for the secret's IAM user and
+// stores it as the pending version, unless there is one already.
+func createPendingSecret(ctx context.Context, sm *awsClient, secretID, token string, lastRotated time.Time) error {
+	current, err := managedSecretVersion(ctx, sm, secretID, "", "AWSCURRENT")
+	if err != nil {
+		return err
+	}
+	if _, err := managedSecretVersion(ctx, sm, secretID, token, "AWSPENDING"); err == nil {
+		fmt.Fprintf(messages(), "Version %s of secret %s already exists.\n", token, secretID)
+		return nil
+	} else if !isSecretsManagerError(err, "ResourceNotFoundException") {
+		return err
+	}
+
+	iam := newAWSClient(awsEnvCredentials(), "")
+	if err := deleteUnusedKeys(ctx, iam, current, lastRotated); err != nil {
+		return err
+	}
+	creds, err := iam.createAccessKey(ctx, current.IAMUser)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(messages(), "Created access key %s for %s.\n", creds.accessKey, current.IAMUser)
+
+	pending := current
+	pending.AccessKeyID, pending.SecretAccessKey = creds.accessKey, creds.secretKey
+	value, err := json.Marshal(pending)
+	if err != nil {
+		return err
+	}
+	err = sm.jsonAPI(ctx, "secretsmanager", "secretsmanager.PutSecretValue", map[string]interface{}{
+		"SecretId":           secretID,
+		"ClientRequestToken": token,
+		"SecretString":       string(value),
+		"VersionStages":      []string{"AWSPENDING"},
+	}, nil)
+	if err != nil {
+		// Nothing has the new key, so don't leave it lying around.
+		iam.deleteAccessKey(ctx, current.IAMUser, creds.accessKey)
+	}
+	return err
+}
+
+// deleteUnusedKeys makes room for a new key, deleting the IAM user's keys
+// that are neither the current version of the secret nor used by Fastly, as
+// long as nothing has used them since the secret was last rotated.
+func deleteUnusedKeys(ctx context.Context, iam *awsClient, current managedSecret, lastRotated time.Time) error {
+	keys, err := iam.listAccessKeys(ctx, current.IAMUser)
+	if err != nil || len(keys) < 2 {
+		return err
+	}
+
+	f := fastlyFor(current.ServiceID)
+	active, err := f.activeVersion(ctx, current.ServiceID)
+	if err != nil {
+		return err
+	}
+	l, err := f.s3Logging(ctx, current.ServiceID, active, current.LoggingName)
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		if k.AccessKeyID == current.AccessKeyID || k.AccessKeyID == l.AccessKey {
+			continue
+		}
+		if _, err := iam.checkOldKeyUnused(ctx, k.AccessKeyID, lastRotated.Add(deliveryTimeout(int(l.Period)))); err != nil {
+			return fmt.Errorf("Unable to make room for a new key for %s: %s", current.IAMUser, err.Error())
+		}
+		if err := iam.deleteAccessKey(ctx, current.IAMUser, k.AccessKeyID); err != nil {
+			return err
+		}
+		fmt.Fprintf(messages(), "Deleted unused access key %s of %s.\n", k.AccessKeyID, current.IAMUser)
+	}
+	return nil
+}
+
+// managedSecretVersion fetches a version of the secret, by version ID and
+// stage, or just by stage for a blank versionID.
+func managedSecretVersion(ctx context.Context, sm *awsClient, secretID, versionID, stage string) (managedSecret, error) {
+	params := map[string]string{"SecretId": secretID, "VersionStage": stage}
+	if versionID != "" {
+		params["VersionId"] = versionID
+	}
+	var resp struct {
+		SecretString string `json:"SecretString"`
+	}
+	if err := sm.jsonAPI(ctx, "secretsmanager", "secretsmanager.GetSecretValue", params, &resp); err != nil {
+		return managedSecret{}, err
+	}
+
+	var s managedSecret
+	if err := json.Unmarshal([]byte(resp.SecretString), &s); err != nil {
+		return s, fmt.Errorf("Secret %s is not JSON with service_id, logging_name, iam_user, access_key_id and secret_access_key: %s", secretID, err.Error())
+	}
+	if s.ServiceID == "" || s.LoggingName == "" || s.IAMUser == "" || s.AccessKeyID == "" {
+		return s, errors.New("Secret " + secretID + " needs service_id, logging_name, iam_user and access_key_id")
+	}
+	return s, nil
+}
+
+// isSecretsManagerError reports whether err is the Secrets Manager error of
+// the given type, e.g. ResourceNotFoundException.
+func isSecretsManagerError(err error, typ string) bool {
+	e, ok := err.(*awsError)
+	return ok && strings.Contains(e.body, typ)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// secretCmdFlag maps secret names (as their env var would be named) to
+// commands that print the secret, so any secret store with a CLI can be used.
+type secretCmdFlag map[string]string
+
+func (s secretCmdFlag) String() string {
+	var names []string
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func (s secretCmdFlag) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("expected NAME=command, e.g. AWS_SECRET_KEY=\"pass show fastly/aws-secret\"")
+	}
+	s[parts[0]] = parts[1]
+	return nil
+}
+
+var secretCmds = secretCmdFlag{}
+
+// secretValues are secrets obtained in-process, such as keys just minted,
+// which take the place of any other source.
+var secretValues = map[string]string{}
+
+func secretFlags(fs *flag.FlagSet) {
+	fs.Var(secretCmds, "secretCmd", "Obtain a secret by running a command rather than from its env var, as NAME=command. Can be repeated.")
+	fs.Var(secretFroms, "secretFrom", "Fetch a secret from a secret store rather than its env var, as NAME=location, e.g. FASTLY_KEY=ssm:///fastly/token or AWS_SECRET_KEY=secretsmanager://fastly/logging. Can be repeated.")
+	fs.Var(envFileFlag{}, "envFile", ".env file of secrets, as NAME=value lines, to use where their env vars aren't set. It must not be accessible by other users.")
+	fs.Var(sopsFileFlag{}, "sopsFile", "SOPS-encrypted JSON file of secrets, as top-level NAME: value pairs, to use where their env vars aren't set. It must be encrypted with an AWS KMS key.")
+	vaultFlags(fs)
+	fs.Var(&stdinSecrets, "secretStdin", "Read a secret from stdin rather than its env var, as its NAME. Can be repeated, to read several, one per line in the order given.")
+	fs.Var(stdinSecretFlag("FASTLY_KEY"), "fastlyKeyStdin", "Read FASTLY_KEY from stdin (short for -secretStdin FASTLY_KEY).")
+	fs.Var(stdinSecretFlag("AWS_SECRET_KEY"), "awsSecretStdin", "Read AWS_SECRET_KEY from stdin (short for -secretStdin AWS_SECRET_KEY).")
+	fs.Var(awsSecretCiphertextFlag{}, "awsSecretCiphertextFile", "File holding AWS_SECRET_KEY encrypted with AWS KMS (by aws kms encrypt), to decrypt when it's needed (short for -secretFrom AWS_SECRET_KEY=kmsfile://...).")
+	fs.Var(awsSecretFromFlag{}, "awsSecretFrom", "Secret store location to fetch AWS_SECRET_KEY from, e.g. secretsmanager://fastly/logging or ssm:///fastly/aws-secret (short for -secretFrom AWS_SECRET_KEY=...).")
+}
+
+// secret returns the secret with the given name, from its -secretFrom secret
+// store or -secretCmd if one was given, or else the -vaultPath secret with
+// -secretSource vault, or else the env var of the same name, or else the
+// -envFile or -sopsFile, or else the OS keyring (where login stores tokens).
+func secret(name string) string {
+	value, err := cachedSecret(name)
+	check(err)
+	return value
+}
+
+var (
+	secretCacheMu sync.Mutex
+	// secretCache holds each secret once looked up, so a secret store or
+	// command is only asked once a run, however many services use it.
+	secretCache = map[string]string{}
+)
+
+// cachedSecret is secret, returning any error obtaining it. Failures aren't
+// cached, so a later call tries again.
+func cachedSecret(name string) (string, error) {
+	readStdinSecrets()
+	if value, ok := secretValues[name]; ok {
+		return value, nil
+	}
+
+	secretCacheMu.Lock()
+	value, ok := secretCache[name]
+	secretCacheMu.Unlock()
+	if ok {
+		return value, nil
+	}
+
+	// Not locked while looking up, as fetching from a secret store can need
+	// other secrets (its credentials).
+	value, err := lookupSecret(name)
+	if err != nil {
+		return "", err
+	}
+	secretCacheMu.Lock()
+	secretCache[name] = value
+	secretCacheMu.Unlock()
+	return value, nil
+}
+
+func lookupSecret(name string) (string, error) {
+	if location, ok := secretFroms[name]; ok {
+		value, err := fetchSecret(name, location)
+		if err != nil {
+			return "", fmt.Errorf("Unable to fetch %s from %s: %s", name, location, err.Error())
+		}
+		return value, nil
+	}
+
+	cmd, ok := secretCmds[name]
+	if !ok {
+		if secretSource != "env" && secretSource != "vault" {
+			return "", fmt.Errorf("Unknown -secretSource '%s', expected env or vault", secretSource)
+		}
+		if secretSource == "vault" {
+			value, err := vaultSecret(name)
+			if err != nil {
+				return "", fmt.Errorf("Unable to fetch %s from Vault: %s", name, err.Error())
+			}
+			if value != "" {
+				return value, nil
+			}
+		}
+		if value := os.Getenv(name); value != "" {
+			return value, nil
+		}
+		if value := envFileSecrets[name]; value != "" {
+			return value, nil
+		}
+		if value := sopsSecrets[name]; value != "" {
+			return value, nil
+		}
+		// Only login stores a secret in the keyring, so only FASTLY_KEY is
+		// looked for there, rather than running the keyring's CLI for every
+		// secret that isn't set.
+		if name != "FASTLY_KEY" {
+			return "", nil
+		}
+		value, _ := keyringGet(name)
+		return value, nil
+	}
+
+	value, err := runSecretCmd(cmd)
+	if err != nil {
+		return "", fmt.Errorf("Unable to obtain %s from -secretCmd: %s", name, err.Error())
+	}
+	return value, nil
+}
+
+func runSecretCmd(command string) (string, error) {
+	var stdout bytes.Buffer
+	cmd := shellCommand(context.Background(), command)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", err
+	}
+
+	// Only the trailing newline is stripped: secrets may legitimately
+	// contain other whitespace.
+	return strings.TrimRight(stdout.String(), "\r\n"), nil
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// secretFromFlag maps secret names (as their env var would be named) to the
+// secret store location to fetch them from, so secrets never need to be in
+// the environment (and so shell history or CI logs) at all. Locations are
+// secretsmanager://name-or-arn or ssm:///parameter/path, with #key to pick a
+// key out of a secret holding JSON, or vault://path#key, or
+// awsprofile://profile (the AWS shared credentials file profile's secret key),
+// or gcpsecretmanager://projects/p/secrets/s, or keyvault://vault/secret, or
+// file:///path, or kmsfile:///path (a file encrypted with AWS KMS), #key also
+// working for these.
+type secretFromFlag map[string]string
+
+func (s secretFromFlag) String() string {
+	return secretCmdFlag(s).String()
+}
+
+func (s secretFromFlag) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("expected NAME=location, e.g. AWS_SECRET_KEY=secretsmanager://fastly/logging")
+	}
+	if _, err := parseSecretLocation(parts[1]); err != nil {
+		return err
+	}
+	s[parts[0]] = parts[1]
+	return nil
+}
+
+// awsSecretFromFlag is -awsSecretFrom, short for -secretFrom AWS_SECRET_KEY=...
+type awsSecretFromFlag struct{}
+
+func (awsSecretFromFlag) String() string {
+	return secretFroms["AWS_SECRET_KEY"]
+}
+
+func (awsSecretFromFlag) Set(value string) error {
+	return secretFroms.Set("AWS_SECRET_KEY=" + value)
+}
+
+// awsSecretCiphertextFlag is -awsSecretCiphertextFile, short for -secretFrom
+// AWS_SECRET_KEY=kmsfile://...
+type awsSecretCiphertextFlag struct{}
+
+func (awsSecretCiphertextFlag) String() string {
+	return ""
+}
+
+func (awsSecretCiphertextFlag) Set(file string) error {
+	return secretFroms.Set("AWS_SECRET_KEY=kmsfile://" + file)
+}
+
+var secretFroms = secretFromFlag{}
+
+// secretStores are the stores -secretFrom can fetch secrets from.
+var secretStores = []string{"secretsmanager", "ssm", "vault", "gcpsecretmanager", "keyvault", "awsprofile", "file", "kmsfile"}
+
+// secretLocation is where a secret is kept in a secret store: its ID there,
+// and the key within it for secrets holding JSON key/value pairs.
+type secretLocation struct {
+	store string
+	id    string
+	key   string
+}
+
+// parseSecretLocation parses a location of the form store://id#key, the key
+// being optional. It isn't parsed as a URL, since IDs such as ARNs aren't
+// valid hosts.
+func parseSecretLocation(location string) (secretLocation, error) {
+	parts := strings.SplitN(location, "://", 2)
+	if len(parts) != 2 {
+		return secretLocation{}, fmt.Errorf("Invalid secret location %q, expected store://id, the store being one of %s", location, strings.Join(secretStores, ","))
+	}
+	l := secretLocation{store: parts[0], id: parts[1]}
+	if i := strings.LastIndex(l.id, "#"); i >= 0 {
+		l.id, l.key = l.id[:i], l.id[i+1:]
+	}
+
+	switch {
+	case !contains(secretStores, l.store):
+		return secretLocation{}, fmt.Errorf("Unsupported secret location %q, expected one of %s", location, strings.Join(secretStores, ","))
+	case l.id == "":
+		return secretLocation{}, fmt.Errorf("Invalid secret location %q, expected %s://id", location, l.store)
+	case l.store == "vault" && l.key == "":
+		return secretLocation{}, fmt.Errorf("Invalid secret location %q, expected vault://path#key", location)
+	}
+	return l, nil
+}
+
+// fetching guards against a secret store needing the very secret it is
+// fetching, e.g. the operator's AWS secret key from Secrets Manager.
+var fetching = map[string]bool{}
+
+func fetchSecret(name, location string) (string, error) {
+	if fetching[name] {
+		return "", fmt.Errorf("%s is needed to fetch itself from %s", name, location)
+	}
+	fetching[name] = true
+	defer delete(fetching, name)
+
+	l, err := parseSecretLocation(location)
+	if err != nil {
+		return "", err
+	}
+
+	ctx := context.Background()
+	var value string
+	switch l.store {
+	case "secretsmanager":
+		value, err = secretsManagerSecret(ctx, l.id)
+	case "ssm":
+		value, err = ssmParameter(ctx, l.id)
+	case "gcpsecretmanager":
+		value, err = gcpSecret(ctx, l.id)
+	case "keyvault":
+		value, err = keyVaultSecret(ctx, l.id)
+	case "file":
+		var data []byte
+		data, err = ioutil.ReadFile(l.id)
+		value = strings.TrimRight(string(data), "\r\n")
+	case "kmsfile":
+		value, err = kmsFileSecret(ctx, l.id)
+	case "awsprofile":
+		return profileSecret(l.id, l.key)
+	case "vault":
+		// Vault secrets are always key/value pairs.
+		var data map[string]interface{}
+		if err := vaultRead(ctx, l.id, &data); err != nil {
+			return "", err
+		}
+		value, _ = data[l.key].(string)
+		if value == "" {
+			return "", fmt.Errorf("Vault secret %s has no key %q", l.id, l.key)
+		}
+		return value, nil
+	}
+	if err != nil || l.key == "" {
+		return value, err
+	}
+	return jsonSecretKey(l, value)
+}
+
+// jsonSecretKey picks a key out of a secret holding JSON key/value pairs, as
+// Secrets Manager secrets often do, e.g. secretsmanager://fastly/logging#secret_key.
+func jsonSecretKey(l secretLocation, value string) (string, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &fields); err != nil {
+		return "", fmt.Errorf("Secret %s isn't JSON, so has no key %q", l.id, l.key)
+	}
+	v, ok := fields[l.key].(string)
+	if !ok {
+		return "", fmt.Errorf("Secret %s has no string key %q", l.id, l.key)
+	}
+	return v, nil
+}
+
+// kmsFileSecret decrypts a file encrypted with AWS KMS, as written by aws kms
+// encrypt, either in binary or base64 (with --output text).
+func kmsFileSecret(ctx context.Context, file string) (string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	ciphertext := data
+	if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data))); err == nil {
+		ciphertext = decoded
+	}
+
+	plaintext, err := kmsDecrypt(ctx, "", ciphertext, nil)
+	return strings.TrimRight(string(plaintext), "\r\n"), err
+}
+
+// gcpSecret fetches the latest version (unless one is named) of a secret
+// from GCP Secret Manager, with the operator's GCP credentials.
+//
+// https://cloud.google.com/secret-manager/docs/reference/rest/v1/projects.secrets.versions/access
+func gcpSecret(ctx context.Context, name string) (string, error) {
+	if !strings.Contains(name, "/versions/") {
+		name += "/versions/latest"
+	}
+
+	g, err := newGCPClient(ctx)
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		Payload struct {
+			Data []byte `json:"data"`
+		} `json:"payload"`
+	}
+	err = g.do(ctx, http.MethodGet, "https://secretmanager.googleapis.com/v1/"+name+":access", nil, "", &resp)
+	return string(resp.Payload.Data), err
+}
+
+// secretsManagerSecret fetches a secret from AWS Secrets Manager with the
+// operator's AWS credentials.
+//
+// https://docs.aws.amazon.com/secretsmanager/latest/apireference/API_GetSecretValue.html
+func secretsManagerSecret(ctx context.Context, id string) (string, error) {
+	var resp struct {
+		SecretString string `json:"SecretString"`
+	}
+	creds, err := operatorCredentials()
+	if err != nil {
+		return "", err
+	}
+	a := newAWSClient(creds, secretRegion(id))
+	err = a.jsonAPI(ctx, "secretsmanager", "secretsmanager.GetSecretValue", map[string]string{"SecretId": id}, &resp)
+	return resp.SecretString, err
+}
+
+// ssmParameter fetches a parameter from SSM Parameter Store with the
+// operator's AWS credentials. SecureString parameters are decrypted with
+// their KMS key, which the operator needs kms:Decrypt on.
+//
+// https://docs.aws.amazon.com/systems-manager/latest/APIReference/API_GetParameter.html
+func ssmParameter(ctx context.Context, name string) (string, error) {
+	var resp struct {
+		Parameter struct {
+			Value string `json:"Value"`
+		} `json:"Parameter"`
+	}
+	creds, err := operatorCredentials()
+	if err != nil {
+		return "", err
+	}
+	a := newAWSClient(creds, secretRegion(name))
+	err = a.jsonAPI(ctx, "ssm", "AmazonSSM.GetParameter", map[string]interface{}{"Name": name, "WithDecryption": true}, &resp)
+	return resp.Parameter.Value, err
+}
+
+// secretRegion is the region a secret is in: the one in its ARN, if it's
+// identified by one, or else the region of the operator's AWS config.
+func secretRegion(id string) string {
+	if arn := strings.Split(id, ":"); len(arn) > 3 && arn[0] == "arn" {
+		return arn[3]
+	}
+	return awsRegion()
+}
+
+// awsRegion is the operator's AWS region, from the standard env vars.
+func awsRegion() string {
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		return region
+	}
+	return os.Getenv("AWS_DEFAULT_REGION")
+}
+
+// jsonAPI calls an AWS JSON protocol API (Secrets Manager, SSM) and decodes
+// the response into out.
+func (a *awsClient) jsonAPI(ctx context.Context, service, target string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	respBody, _, err := a.do(ctx, target, awsRequest{
+		service: service,
+		method:  http.MethodPost,
+		host:    fmt.Sprintf("%s.%s.amazonaws.com", service, a.region),
+		header: http.Header{
+			"Content-Type": {"application/x-amz-json-1.1"},
+			"X-Amz-Target": {target},
+		},
+		body: body,
+	})
+	if err != nil || out == nil {
+		return err
+	}
+	return json.Unmarshal(respBody, out)
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestParseSecretLocation(t *testing.T) {
+	for location, want := range map[string]secretLocation{
+		"secretsmanager://arn:aws:secretsmanager:eu-west-1:123456789012:secret:logs": {store: "secretsmanager", id: "arn:aws:secretsmanager:eu-west-1:123456789012:secret:logs"},
+		"secretsmanager://logs#secret_key":                                           {store: "secretsmanager", id: "logs", key: "secret_key"},
+		"vault://secret/data/logs#secret_key":                                        {store: "vault", id: "secret/data/logs", key: "secret_key"},
+		"file:///run/secrets/key#a#b":                                                {store: "file", id: "/run/secrets/key#a", key: "b"},
+	} {
+		got, err := parseSecretLocation(location)
+		if err != nil {
+			t.Errorf("parseSecretLocation(%q): %s", location, err)
+		} else if got != want {
+			t.Errorf("parseSecretLocation(%q) = %+v, want %+v", location, got, want)
+		}
+	}
+
+	// Vault secrets hold several values, so need a #key.
+	for _, location := range []string{"vault://secret/data/logs", "ssm://", "nope://logs", "logs"} {
+		if _, err := parseSecretLocation(location); err == nil {
+			t.Errorf("parseSecretLocation(%q) succeeded, want an error", location)
+		}
+	}
+}
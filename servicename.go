@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// serviceNameFlag adds -serviceName to commands with -serviceID, so that
+// runbooks can name services rather than give their IDs.
+func serviceNameFlag(fs *flag.FlagSet) *string {
+	if fs.Lookup("serviceID") == nil || fs.Lookup("serviceName") != nil {
+		return nil
+	}
+	return fs.String("serviceName", "", "A Fastly service name, looked up to give -serviceID.")
+}
+
+// setServiceID sets -serviceID to the ID of the service named by
+// -serviceName, if it was given. It overrides a -serviceID from the config
+// file, but not one given as a flag or env var.
+func setServiceID(fs *flag.FlagSet, serviceName *string, serviceIDGiven bool) {
+	if serviceName == nil || *serviceName == "" {
+		return
+	}
+	if serviceIDGiven {
+		check(fmt.Errorf("Use one of -serviceID and -serviceName"))
+	}
+
+	id, err := lookupServiceName(context.Background(), *serviceName)
+	check(err)
+	// A list of service IDs (as rotate-creds takes) is replaced, not added to.
+	if ids, ok := fs.Lookup("serviceID").Value.(*listFlag); ok {
+		*ids = nil
+	}
+	check(fs.Set("serviceID", id))
+}
+
+// lookupServiceName finds the ID of the service with a name, across every
+// account. Names aren't unique, so more than one match is an error.
+func lookupServiceName(ctx context.Context, name string) (string, error) {
+	return serviceIDNamed(servicesFor(ctx, ""), name)
+}
+
+// serviceIDNamed is lookupServiceName among the services already listed.
+func serviceIDNamed(all []accountService, name string) (string, error) {
+	var ids []string
+	for _, s := range all {
+		if s.svc.Name == name {
+			ids = append(ids, s.svc.ID)
+		}
+	}
+	switch len(ids) {
+	case 1:
+		return ids[0], nil
+	case 0:
+		return "", fmt.Errorf("No service is named '%s'%s", name, closestServiceNames(all, name))
+	}
+	sort.Strings(ids)
+	return "", fmt.Errorf("%d services are named '%s' (%s), use -serviceID", len(ids), name, strings.Join(ids, ", "))
+}
+
+// closestServiceNames suggests the names most like name, as search would.
+func closestServiceNames(all []accountService, name string) string {
+	type match struct {
+		name  string
+		score int
+	}
+	var matches []match
+	for _, s := range all {
+		if score := fuzzyScore(name, s.svc.Name); score > 0 {
+			matches = append(matches, match{s.svc.Name, score})
+		}
+	}
+	if len(matches) == 0 {
+		return ""
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].score > matches[j].score })
+
+	var names []string
+	for _, m := range matches {
+		if len(names) == 3 {
+			break
+		}
+		names = append(names, fmt.Sprintf("'%s'", m.name))
+	}
+	return ", did you mean " + strings.Join(names, " or ") + "?"
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+type credentialAge struct {
+	Service  service       `json:"service"`
+	Endpoint string        `json:"endpoint"`
+	Key      string        `json:"key"`
+	Age      time.Duration `json:"age"`
+	Err      string        `json:"error,omitempty"`
+}
+
+// serviceCredentials is the -jsonLines output of sla-report.
+type serviceCredentials struct {
+	ServiceID   string          `json:"service_id"`
+	ServiceName string          `json:"service_name"`
+	Credentials []credentialAge `json:"credentials"`
+}
+
+// slaReport lists logging credentials across the account that are older than
+// the maximum age, most overdue first.
+func slaReport(args []string) {
+	fs := flag.NewFlagSet("sla-report", flag.ExitOnError)
+	maxKeyAge := fs.Int("maxKeyAge", 0, "Maximum age in days of logging credentials before they are overdue for rotation (default: the config file's rotation_policy max_key_age_days, or 90).")
+	fields := fs.String("fields", "overdue_days,age_days,service,endpoint,key", "Comma-separated columns to report, from "+strings.Join(reportFields, ",")+".")
+	fs.BoolVar(&jsonLines, "jsonLines", false, "Stream the credentials of each service to stdout as a line of JSON, rather than printing a report at the end.")
+	schema := schemaFlag(fs)
+	tagFlags(fs)
+	checkpointFlags(fs)
+	commonFlags(fs)
+
+	fs.Usage = commandUsage(fs, "Note, FASTLY_KEY (or the tokens of each account in the config file) must be provided as env vars. Ages come from IAM when AWS credentials with IAM read access are in the standard AWS env vars, or else from the state file of past rotations.")
+	parseFlags(fs, args)
+
+	if *maxKeyAge == 0 {
+		*maxKeyAge = maxKeyAgeDays()
+	}
+
+	if *schema {
+		printSchema("sla-report")
+		return
+	}
+
+	columns := parseFields(*fields)
+	for _, c := range columns {
+		if !contains(reportFields, c) {
+			check(fmt.Errorf("Unknown field '%s', expected one of %s", c, strings.Join(reportFields, ",")))
+		}
+	}
+
+	accounts := fastlyAccounts()
+	ctx := context.Background()
+
+	var iam *awsClient
+	if creds := awsEnvCredentials(); creds.accessKey != "" {
+		iam = newAWSClient(creds, "")
+	}
+
+	state, err := loadState()
+	check(err)
+
+	cp := loadCheckpoint()
+	var ages []credentialAge
+	for _, f := range accounts {
+		services, err := f.services(ctx)
+		cp.stopIfExhausted(err)
+		check(err)
+
+		for _, s := range services {
+			var serviceAges []credentialAge
+			if !cp.result(s.ID, &serviceAges) {
+				serviceAges, err = credentialAges(ctx, f, s, iam, state)
+				cp.stopIfExhausted(err)
+				check(err)
+				cp.done(s.ID, serviceAges)
+			}
+			if jsonLines {
+				emitJSONLine(serviceCredentials{ServiceID: s.ID, ServiceName: s.Name, Credentials: serviceAges})
+			}
+			ages = append(ages, serviceAges...)
+		}
+	}
+	cp.finish()
+
+	var known []time.Duration
+	for _, a := range ages {
+		if a.Err == "" {
+			known = append(known, a.Age)
+		}
+	}
+	if jsonLines {
+		check(checkMaxKeyAge(known))
+		return
+	}
+
+	maxAge := time.Duration(*maxKeyAge) * 24 * time.Hour
+	var overdue, unknown []credentialAge
+	for _, a := range ages {
+		if a.Err != "" {
+			unknown = append(unknown, a)
+		} else if a.Age > maxAge {
+			overdue = append(overdue, a)
+		}
+	}
+	sort.SliceStable(overdue, func(i, j int) bool { return overdue[i].Age > overdue[j].Age })
+
+	fmt.Printf("Logging credentials older than %d days: %d of %d\n\n", *maxKeyAge, len(overdue), len(ages))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	if len(overdue) > 0 {
+		fmt.Fprintln(w, strings.ToUpper(strings.Join(columns, "\t")))
+		for _, a := range overdue {
+			var values []string
+			for _, c := range columns {
+				values = append(values, a.field(c, maxAge))
+			}
+			fmt.Fprintln(w, strings.Join(values, "\t"))
+		}
+	}
+	w.Flush()
+
+	if len(unknown) > 0 {
+		fmt.Printf("\nUnable to tell the age of %d credential(s):\n\n", len(unknown))
+		for _, a := range unknown {
+			fmt.Fprintf(w, "%s (%s)\t%s\t%s\t%s\n", a.Service.Name, a.Service.ID, a.Endpoint, a.Key, a.Err)
+		}
+		w.Flush()
+	}
+	check(checkMaxKeyAge(known))
+}
+
+// reportFields are the columns sla-report can show.
+var reportFields = []string{"overdue_days", "age_days", "service", "service_id", "service_name", "endpoint", "key"}
+
+func (a credentialAge) field(name string, maxAge time.Duration) string {
+	switch name {
+	case "overdue_days":
+		return fmt.Sprintf("%dd", days(a.Age-maxAge))
+	case "age_days":
+		return fmt.Sprintf("%dd", days(a.Age))
+	case "service":
+		return fmt.Sprintf("%s (%s)", a.Service.Name, a.Service.ID)
+	case "service_id":
+		return a.Service.ID
+	case "service_name":
+		return a.Service.Name
+	case "endpoint":
+		return a.Endpoint
+	case "key":
+		return a.Key
+	}
+	return ""
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func days(d time.Duration) int {
+	return int(d.Hours() / 24)
+}
+
+// credentialAges finds the age of the credentials of every logging endpoint
+// on a service: S3 keys from IAM (or the state file), and other types from
+// the state file.
+func credentialAges(ctx context.Context, f *fastlyClient, s service, iam *awsClient, state rotationState) ([]credentialAge, error) {
+	if s.Version == 0 {
+		return nil, nil // never activated, so not delivering logs
+	}
+
+	loggings, err := f.s3Loggings(ctx, s.ID, s.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	var ages []credentialAge
+	for _, l := range loggings {
+		if l.IAMRole != "" || l.AccessKey == "" || !hasTags(l.Name, selectedTags) {
+			continue
+		}
+		a := credentialAge{Service: s, Endpoint: "s3/" + l.Name, Key: l.AccessKey}
+		if created, err := keyCreated(ctx, s.ID, l, iam, state); err != nil {
+			a.Err = err.Error()
+		} else {
+			a.Age = time.Since(created)
+		}
+		ages = append(ages, a)
+	}
+
+	for _, r := range state.Rotations {
+		if r.ServiceID == s.ID && r.Type != "s3" && hasTags(r.LoggingName, selectedTags) {
+			ages = append(ages, credentialAge{Service: s, Endpoint: r.Type + "/" + r.LoggingName, Key: r.KeyFingerprint, Age: time.Since(r.RotatedAt)})
+		}
+	}
+	return ages, nil
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// sopsFileFlag is -sopsFile, a SOPS-encrypted JSON file of secrets (as
+// top-level NAME: value pairs) to use in place of env vars, decrypted by
+// loadSOPSFile once all flags are set.
+type sopsFileFlag struct{}
+
+var (
+	sopsFile    string
+	sopsSecrets map[string]string
+)
+
+func (sopsFileFlag) String() string {
+	return sopsFile
+}
+
+func (sopsFileFlag) Set(file string) error {
+	sopsFile = file
+	return nil
+}
+
+// loadSOPSFile decrypts the -sopsFile once flags are parsed, so that it's
+// decrypted with the AWS credentials they give (with -assumeRole, or from an
+// -envFile), wherever they come on the command line.
+func loadSOPSFile() error {
+	if sopsFile == "" || sopsSecrets != nil {
+		return nil
+	}
+	secrets, err := decryptSOPSFile(context.Background(), sopsFile)
+	if err != nil {
+		return fmt.Errorf("Unable to decrypt %s: %s", sopsFile, err.Error())
+	}
+	sopsSecrets = secrets
+
+	// Secrets that were looked up to decrypt it, and weren't set, may be in it.
+	secretCacheMu.Lock()
+	for name, value := range secretCache {
+		if value == "" {
+			delete(secretCache, name)
+		}
+	}
+	secretCacheMu.Unlock()
+	return nil
+}
+
+type sopsMetadata struct {
+	KMS []struct {
+		ARN     string            `json:"arn"`
+		Enc     string            `json:"enc"`
+		Context map[string]string `json:"context"`
+	} `json:"kms"`
+	Age []json.RawMessage `json:"age"`
+	PGP []json.RawMessage `json:"pgp"`
+
+	LastModified      string `json:"lastmodified"`
+	MAC               string `json:"mac"`
+	MACOnlyEncrypted  bool   `json:"mac_only_encrypted"`
+	UnencryptedSuffix string `json:"unencrypted_suffix"`
+	EncryptedSuffix   string `json:"encrypted_suffix"`
+	UnencryptedRegex  string `json:"unencrypted_regex"`
+	EncryptedRegex    string `json:"encrypted_regex"`
+}
+
+// encrypts is whether SOPS encrypts the value at a path, by the file's
+// unencrypted or encrypted suffix or regex, any key on the path matching.
+func (m sopsMetadata) encrypts(path []string) (bool, error) {
+	matches := func(suffix, pattern string) (bool, error) {
+		for _, key := range path {
+			if suffix != "" && strings.HasSuffix(key, suffix) {
+				return true, nil
+			}
+			if pattern == "" {
+				continue
+			}
+			ok, err := regexp.MatchString(pattern, key)
+			if err != nil || ok {
+				return ok, err
+			}
+		}
+		return false, nil
+	}
+
+	switch {
+	case m.EncryptedSuffix != "" || m.EncryptedRegex != "":
+		return matches(m.EncryptedSuffix, m.EncryptedRegex)
+	case m.UnencryptedSuffix != "" || m.UnencryptedRegex != "":
+		ok, err := matches(m.UnencryptedSuffix, m.UnencryptedRegex)
+		return !ok, err
+	default:
+		// SOPS's default.
+		ok, err := matches("_unencrypted", "")
+		return !ok, err
+	}
+}
+
+// sopsValue is an encrypted SOPS value.
+var sopsValue = regexp.MustCompile(`^ENC\[AES256_GCM,data:([^,]*),iv:([^,]+),tag:([^,]+),type:(str|int|float|bool|bytes)\]$`)
+
+// decryptSOPSFile decrypts a SOPS JSON file with its AWS KMS data key, the
+// only kind of key that can be used without the sops binary: for age or PGP
+// keys, decrypt it with sops first (e.g. with -secretCmd).
+//
+// https://github.com/getsops/sops#encryption-protocol
+func decryptSOPSFile(ctx context.Context, file string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(file, ".json") {
+		return nil, errors.New("Only SOPS JSON files are supported")
+	}
+
+	var doc struct {
+		SOPS *sopsMetadata `json:"sops"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc.SOPS == nil {
+		return nil, errors.New("It isn't encrypted by SOPS: it has no sops metadata")
+	}
+
+	key, err := sopsDataKey(ctx, *doc.SOPS)
+	if err != nil {
+		return nil, err
+	}
+	return sopsDecryptDocument(data, *doc.SOPS, key)
+}
+
+// sopsDecryptDocument decrypts the top-level strings of a SOPS JSON document,
+// having checked its MAC: that its values are those it was encrypted with,
+// none added, removed, reordered or left unencrypted where they shouldn't be.
+func sopsDecryptDocument(data []byte, meta sopsMetadata, key []byte) (map[string]string, error) {
+	d := sopsDocument{
+		meta:    meta,
+		key:     key,
+		dec:     json.NewDecoder(bytes.NewReader(data)),
+		mac:     sha512.New(),
+		secrets: map[string]string{},
+	}
+	d.dec.UseNumber()
+	if err := d.walk(nil); err != nil {
+		return nil, err
+	}
+
+	if meta.MAC == "" {
+		return nil, errors.New("It has no MAC")
+	}
+	mac, _, err := sopsDecrypt(key, meta.MAC, meta.LastModified)
+	if err != nil {
+		return nil, fmt.Errorf("MAC: %s", err.Error())
+	}
+	if subtle.ConstantTimeCompare([]byte(mac), []byte(fmt.Sprintf("%X", d.mac.Sum(nil)))) != 1 {
+		return nil, errors.New("Its MAC doesn't match its values, so it has been tampered with or corrupted")
+	}
+	return d.secrets, nil
+}
+
+// sopsDocument walks a SOPS JSON document in order, decrypting its values and
+// hashing them for its MAC as SOPS does.
+type sopsDocument struct {
+	meta    sopsMetadata
+	key     []byte
+	dec     *json.Decoder
+	mac     hash.Hash
+	secrets map[string]string
+}
+
+func (d *sopsDocument) walk(path []string) error {
+	t, err := d.dec.Token()
+	if err != nil {
+		return err
+	}
+
+	switch t {
+	case json.Delim('{'):
+		for d.dec.More() {
+			t, err := d.dec.Token()
+			if err != nil {
+				return err
+			}
+			name := t.(string)
+			if len(path) == 0 && name == "sops" {
+				var skip json.RawMessage
+				if err := d.dec.Decode(&skip); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := d.walk(append(path[:len(path):len(path)], name)); err != nil {
+				return err
+			}
+		}
+		_, err := d.dec.Token()
+		return err
+	case json.Delim('['):
+		// SOPS doesn't add list indexes to paths.
+		for d.dec.More() {
+			if err := d.walk(path); err != nil {
+				return err
+			}
+		}
+		_, err := d.dec.Token()
+		return err
+	}
+
+	name := strings.Join(path, ":")
+	encrypted, err := d.meta.encrypts(path)
+	if err != nil {
+		return fmt.Errorf("Invalid unencrypted or encrypted regex: %s", err.Error())
+	}
+	var plaintext, hashed string
+	switch v := t.(type) {
+	case string:
+		plaintext, hashed = v, v
+	case json.Number:
+		hashed = sopsNumber(string(v))
+	case bool:
+		hashed = sopsBool(v)
+	default:
+		return fmt.Errorf("%s: null values aren't supported", name)
+	}
+	if encrypted {
+		// Anything but an encrypted string is refused by sopsDecrypt.
+		plaintext, hashed, err = sopsDecrypt(d.key, fmt.Sprint(t), name+":")
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err.Error())
+		}
+	}
+
+	if encrypted || !d.meta.MACOnlyEncrypted {
+		d.mac.Write([]byte(hashed))
+	}
+	if _, ok := t.(string); ok && len(path) == 1 {
+		// Only top-level strings are secrets.
+		d.secrets[name] = plaintext
+	}
+	return nil
+}
+
+// sopsNumber is a number as SOPS hashes it: an int if it is one, else a float.
+func sopsNumber(n string) string {
+	if i, err := strconv.Atoi(n); err == nil {
+		return strconv.Itoa(i)
+	}
+	if f, err := strconv.ParseFloat(n, 64); err == nil {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return n
+}
+
+// sopsBool is a bool as SOPS hashes it, spelled as Python does.
+func sopsBool(b bool) string {
+	if b {
+		return "True"
+	}
+	return "False"
+}
+
+// sopsDataKey decrypts the file's data key with the first of its KMS keys
+// that the operator can use.
+func sopsDataKey(ctx context.Context, meta sopsMetadata) ([]byte, error) {
+	if len(meta.KMS) == 0 {
+		if len(meta.Age) > 0 || len(meta.PGP) > 0 {
+			return nil, errors.New("Only AWS KMS keys are supported, not age or PGP: decrypt it with sops -d instead")
+		}
+		return nil, errors.New("It has no AWS KMS key")
+	}
+
+	var lastErr error
+	for _, k := range meta.KMS {
+		blob, err := base64.StdEncoding.DecodeString(k.Enc)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		key, err := kmsDecrypt(ctx, k.ARN, blob, k.Context)
+		if err == nil {
+			return key, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// sopsDecrypt decrypts a value, given the path to it (keys joined and ended
+// with ':') as SOPS authenticates it with. It returns the plaintext, and the
+// plaintext as SOPS hashes it for the file's MAC.
+func sopsDecrypt(key []byte, value, path string) (string, string, error) {
+	m := sopsValue.FindStringSubmatch(value)
+	if m == nil {
+		return "", "", errors.New("It isn't encrypted, though it doesn't match the file's unencrypted_suffix or unencrypted_regex")
+	}
+
+	var parts [3][]byte
+	for i, s := range m[1:4] {
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return "", "", err
+		}
+		parts[i] = b
+	}
+	ciphertext, iv, tag := parts[0], parts[1], parts[2]
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", "", err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return "", "", err
+	}
+	plaintext, err := gcm.Open(nil, iv, append(ciphertext, tag...), []byte(path))
+	if err != nil {
+		return "", "", errors.New("It can't be decrypted with the file's data key, so has been tampered with or corrupted")
+	}
+
+	hashed := string(plaintext)
+	switch m[4] {
+	case "int", "float":
+		hashed = sopsNumber(hashed)
+	case "bool":
+		if b, err := strconv.ParseBool(hashed); err == nil {
+			hashed = sopsBool(b)
+		}
+	}
+	return string(plaintext), hashed, nil
+}
+
+// https://docs.aws.amazon.com/kms/latest/APIReference/API_Decrypt.html
+func kmsDecrypt(ctx context.Context, keyARN string, ciphertext []byte, encryptionContext map[string]string) ([]byte, error) {
+	in := map[string]interface{}{"CiphertextBlob": ciphertext}
+	if keyARN != "" {
+		in["KeyId"] = keyARN
+	}
+	if len(encryptionContext) > 0 {
+		in["EncryptionContext"] = encryptionContext
+	}
+
+	var resp struct {
+		Plaintext []byte `json:"Plaintext"`
+	}
+	a := newAWSClient(awsEnvCredentials(), secretRegion(keyARN))
+	err := a.jsonAPI(ctx, "kms", "TrentService.Decrypt", in, &resp)
+	return resp.Plaintext, err
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// sopsEncrypt encrypts a value as SOPS does, with a fixed IV.
+func sopsEncrypt(t *testing.T, key []byte, value, typ, path string) string {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iv := make([]byte, 32)
+	sealed := gcm.Seal(nil, iv, []byte(value), []byte(path))
+	ciphertext, tag := sealed[:len(value)], sealed[len(value):]
+	b64 := base64.StdEncoding.EncodeToString
+	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:%s]", b64(ciphertext), b64(iv), b64(tag), typ)
+}
+
+func TestSOPSDecryptDocument(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	lastModified := "2024-01-02T03:04:05Z"
+	mac := sha512.Sum512([]byte("token" + "443" + "hello"))
+	meta := sopsMetadata{
+		LastModified:      lastModified,
+		MAC:               sopsEncrypt(t, key, fmt.Sprintf("%X", mac), "str", lastModified),
+		UnencryptedSuffix: "_unencrypted",
+	}
+	fastlyKey := sopsEncrypt(t, key, "token", "str", "FASTLY_KEY:")
+	port := sopsEncrypt(t, key, "443", "int", "PORT:")
+	doc := func(fields ...string) []byte {
+		return []byte("{" + strings.Join(fields, ",") + `,"sops":{"mac":"..."}}`)
+	}
+
+	secrets, err := sopsDecryptDocument(doc(`"FASTLY_KEY":"`+fastlyKey+`"`, `"PORT":"`+port+`"`, `"note_unencrypted":"hello"`), meta, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secrets["FASTLY_KEY"] != "token" || secrets["PORT"] != "443" || secrets["note_unencrypted"] != "hello" {
+		t.Errorf("sopsDecryptDocument = %v", secrets)
+	}
+
+	for name, data := range map[string][]byte{
+		"unencrypted value added":   doc(`"FASTLY_KEY":"`+fastlyKey+`"`, `"PORT":"`+port+`"`, `"note_unencrypted":"hello"`, `"AWS_SECRET_KEY":"mine"`),
+		"unencrypted value changed": doc(`"FASTLY_KEY":"`+fastlyKey+`"`, `"PORT":"`+port+`"`, `"note_unencrypted":"bye"`),
+		"value removed":             doc(`"FASTLY_KEY":"`+fastlyKey+`"`, `"note_unencrypted":"hello"`),
+		"values reordered":          doc(`"PORT":"`+port+`"`, `"FASTLY_KEY":"`+fastlyKey+`"`, `"note_unencrypted":"hello"`),
+		"value moved":               doc(`"AWS_SECRET_KEY":"`+fastlyKey+`"`, `"PORT":"`+port+`"`, `"note_unencrypted":"hello"`),
+	} {
+		if _, err := sopsDecryptDocument(data, meta, key); err == nil {
+			t.Errorf("%s: sopsDecryptDocument succeeded, want an error", name)
+		}
+	}
+}
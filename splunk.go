@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// https://developer.fastly.com/reference/api/logging/splunk/
+type splunkLogging struct {
+	Name  string `json:"name"`
+	URL   string `json:"url"`
+	Token string `json:"token"`
+}
+
+func (f *fastlyClient) splunkLogging(ctx context.Context, serviceID string, version int, name string) (splunkLogging, error) {
+	var l splunkLogging
+	err := f.do(ctx, http.MethodGet, fmt.Sprintf("/service/%s/version/%d/logging/splunk/%s", serviceID, version, name), nil, &l)
+	return l, err
+}
+
+func (f *fastlyClient) updateSplunkToken(ctx context.Context, serviceID string, version int, name, token string) error {
+	return f.updateLogging(ctx, serviceID, version, "splunk", name, url.Values{"token": {token}})
+}
+
+// splunkClient calls the Splunk management (REST) API.
+//
+// https://docs.splunk.com/Documentation/Splunk/latest/RESTREF/RESTinput#data.2Finputs.2Fhttp
+type splunkClient struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+func (s *splunkClient) do(ctx context.Context, method, path string, params url.Values, out interface{}) error {
+	if params == nil {
+		params = url.Values{}
+	}
+	params.Set("output_mode", "json")
+
+	reqURL := strings.TrimSuffix(s.baseURL, "/") + path
+	var body io.Reader
+	if method == http.MethodGet {
+		reqURL += "?" + params.Encode()
+	} else {
+		body = strings.NewReader(params.Encode())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Authorization", "Bearer "+s.token)
+	if body != nil {
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s %s failed: %d, %s", method, path, resp.StatusCode, string(respBody))
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(respBody, out)
+}
+
+type hecInput struct {
+	Name    string `json:"name"`
+	Content struct {
+		Token      string   `json:"token"`
+		Index      string   `json:"index"`
+		Indexes    []string `json:"indexes"`
+		Sourcetype string   `json:"sourcetype"`
+		Disabled   bool     `json:"disabled"`
+	} `json:"content"`
+}
+
+const hecInputsPath = "/servicesNS/nobody/splunk_httpinput/data/inputs/http"
+
+func (s *splunkClient) hecInputs(ctx context.Context) ([]hecInput, error) {
+	var resp struct {
+		Entry []hecInput `json:"entry"`
+	}
+	err := s.do(ctx, http.MethodGet, hecInputsPath, url.Values{"count": {"0"}}, &resp)
+	return resp.Entry, err
+}
+
+// createHECInput creates a token configured like template.
+func (s *splunkClient) createHECInput(ctx context.Context, name string, template hecInput) (hecInput, error) {
+	params := url.Values{"name": {name}}
+	if template.Content.Index != "" {
+		params.Set("index", template.Content.Index)
+	}
+	if len(template.Content.Indexes) > 0 {
+		params.Set("indexes", strings.Join(template.Content.Indexes, ","))
+	}
+	if template.Content.Sourcetype != "" {
+		params.Set("sourcetype", template.Content.Sourcetype)
+	}
+
+	var resp struct {
+		Entry []hecInput `json:"entry"`
+	}
+	if err := s.do(ctx, http.MethodPost, hecInputsPath, params, &resp); err != nil {
+		return hecInput{}, err
+	}
+	if len(resp.Entry) == 0 {
+		return hecInput{}, fmt.Errorf("Splunk returned no token for new input %s", name)
+	}
+	return resp.Entry[0], nil
+}
+
+func (s *splunkClient) disableHECInput(ctx context.Context, name string) error {
+	return s.do(ctx, http.MethodPost, hecInputsPath+"/"+url.PathEscape(name)+"/disable", nil, nil)
+}
+
+// hasEvents reports whether any events have arrived from source since t.
+func (s *splunkClient) hasEvents(ctx context.Context, source string, t time.Time) (bool, error) {
+	params := url.Values{
+		"search":        {fmt.Sprintf("search index=* source=%q | head 1", source)},
+		"exec_mode":     {"oneshot"},
+		"earliest_time": {fmt.Sprintf("%d", t.Unix())},
+	}
+
+	var resp struct {
+		Results []json.RawMessage `json:"results"`
+	}
+	err := s.do(ctx, http.MethodPost, "/services/search/jobs", params, &resp)
+	return len(resp.Results) > 0, err
+}
+
+// rotateSplunkToken creates a new HEC token, switches Fastly to it, checks
+// events arrive through it, then disables the old token.
+func rotateSplunkToken(args []string) {
+	fs := flag.NewFlagSet("rotate-splunk-token", flag.ExitOnError)
+	serviceID := fs.String("serviceID", "", "A Fastly Service ID.")
+	loggingName := fs.String("loggingName", "", "Name of your service Splunk logging configuration in Fastly.")
+	splunkURL := fs.String("splunkURL", "", "URL of the Splunk management API, e.g. https://splunk.example.com:8089.")
+	keepOldToken := fs.Bool("keepOldToken", false, "Don't disable the old HEC token after verifying events arrive.")
+	verifyTimeout := fs.Duration("verifyTimeout", 10*time.Minute, "How long to wait for events to arrive with the new token.")
+	draftFlags(fs)
+	commonFlags(fs)
+
+	fs.Usage = commandUsage(fs, "Note, FASTLY_KEY and SPLUNK_MGMT_TOKEN (a Splunk authentication token) must be provided as env vars.")
+	parseFlags(fs, args)
+	refuseDryRun("rotate-splunk-token")
+
+	mgmtToken := secret("SPLUNK_MGMT_TOKEN")
+
+	checkArg("serviceID", *serviceID)
+	checkArg("loggingName", *loggingName)
+	checkArg("splunkURL", *splunkURL)
+	checkArg("SPLUNK_MGMT_TOKEN", mgmtToken)
+
+	f := fastlyFor(*serviceID)
+	s := &splunkClient{baseURL: *splunkURL, token: mgmtToken, client: httpClient()}
+	ctx := context.Background()
+
+	active, err := f.activeVersion(ctx, *serviceID)
+	check(err)
+
+	current, err := f.splunkLogging(ctx, *serviceID, active, *loggingName)
+	check(err)
+
+	inputs, err := s.hecInputs(ctx)
+	check(err)
+
+	var old *hecInput
+	for i := range inputs {
+		if inputs[i].Content.Token == current.Token {
+			old = &inputs[i]
+		}
+	}
+	if old == nil {
+		check(fmt.Errorf("No HEC input in Splunk has the token configured for %s", *loggingName))
+	}
+
+	name := fmt.Sprintf("fastly-%s-%s-%s", *serviceID, *loggingName, time.Now().UTC().Format("20060102150405"))
+	created, err := s.createHECInput(ctx, name, *old)
+	check(err)
+	fmt.Printf("Created HEC input %s.\n", name)
+
+	number, err := withDraft(ctx, f, *serviceID, func(number int) error {
+		return f.updateSplunkToken(ctx, *serviceID, number, *loggingName, created.Content.Token)
+	})
+	if err != nil {
+		// Unless the draft got as far as activation, nothing uses the new
+		// input, so don't leave it enabled.
+		if maybeActivated(err) {
+			fmt.Fprintf(messages(), "Leaving new HEC input %s enabled, as the version using it may be live.\n", name)
+		} else {
+			s.disableHECInput(ctx, name)
+		}
+		check(err)
+	}
+	activatedAt := time.Now()
+	fmt.Printf("Activated version %d of service %s.\n", number, *serviceID)
+	recordRotation("splunk", *serviceID, *loggingName, active, number, current.Token, created.Content.Token)
+
+	fmt.Printf("Waiting up to %s for events from %s...\n", *verifyTimeout, name)
+	err = waitFor(ctx, *verifyTimeout, 30*time.Second, func() (bool, error) {
+		return s.hasEvents(ctx, "http:"+name, activatedAt)
+	})
+	if err != nil {
+		check(fmt.Errorf("Unable to verify events arrive, leaving old HEC input %s enabled: %s", old.Name, err.Error()))
+	}
+	fmt.Println("Events verified.")
+
+	if *keepOldToken {
+		fmt.Printf("Leaving old HEC input %s enabled.\n", old.Name)
+		return
+	}
+
+	check(s.disableHECInput(ctx, old.Name))
+	fmt.Printf("Disabled old HEC input %s.\n", old.Name)
+}
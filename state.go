@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// rotationState records when each logging configuration's credentials were
+// last rotated by this tool, for checking key ages where IAM can't be asked
+// (e.g. keys owned by another team).
+type rotationState struct {
+	Rotations map[string]rotationRecord `json:"rotations"`
+	Tokens    map[string]tokenRecord    `json:"tokens,omitempty"`
+	// Retirements are old access keys to retire once their grace period is
+	// over, by access key.
+	Retirements map[string]retirementRecord `json:"retirements,omitempty"`
+	// Schedules are the last run of each daemon schedule, by state key.
+	Schedules map[string]scheduleRecord `json:"schedules,omitempty"`
+	// History is every rotation recorded, oldest first, for history.
+	History []historyEntry `json:"history,omitempty"`
+
+	// etag is the ETag of an s3:// state file as loaded, which it must still
+	// have to be saved, so that concurrent runs don't lose each other's
+	// changes. It's blank for a local file, or one that didn't exist.
+	etag string
+}
+
+type rotationRecord struct {
+	Type           string    `json:"type"`
+	ServiceID      string    `json:"service_id"`
+	LoggingName    string    `json:"logging_name"`
+	Version        int       `json:"version"`
+	RotatedAt      time.Time `json:"rotated_at"`
+	KeyFingerprint string    `json:"key_fingerprint"`
+}
+
+// historyEntry is one rotation. Keys are identified by historyKeyID: access
+// key IDs and role ARNs as they are, anything secret by its fingerprint.
+type historyEntry struct {
+	Type        string    `json:"type"`
+	ServiceID   string    `json:"service_id"`
+	LoggingName string    `json:"logging_name"`
+	OldKey      string    `json:"old_key,omitempty"`
+	NewKey      string    `json:"new_key"`
+	FromVersion int       `json:"from_version"`
+	ToVersion   int       `json:"to_version"`
+	RotatedAt   time.Time `json:"rotated_at"`
+	Operator    string    `json:"operator"`
+	Command     string    `json:"command"`
+}
+
+// tokenRecord is a Fastly token created by create-token, by token ID.
+type tokenRecord struct {
+	Name      string    `json:"name"`
+	Services  []string  `json:"services"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// retirementRecord is an old access key left active by rotate-creds
+// -gracePeriod, for retire-keys to retire once the new key is in use.
+type retirementRecord struct {
+	Owner       string    `json:"owner"`
+	NewKey      string    `json:"new_key"`
+	ServiceID   string    `json:"service_id"`
+	LoggingName string    `json:"logging_name"`
+	Action      string    `json:"action"`
+	RotatedAt   time.Time `json:"rotated_at"`
+	RetireAfter time.Time `json:"retire_after"`
+}
+
+// scheduleRecord is the result of the last scheduled rotation by daemon.
+type scheduleRecord struct {
+	Schedule string    `json:"schedule"`
+	RanAt    time.Time `json:"ran_at"`
+	Status   string    `json:"status"`
+	Detail   string    `json:"detail"`
+}
+
+func stateKey(typ, serviceID, loggingName string) string {
+	return typ + "/" + serviceID + "/" + loggingName
+}
+
+// fingerprint identifies a credential without revealing it.
+func fingerprint(credential string) string {
+	return sha256Hex([]byte(credential))[:16]
+}
+
+// historyKeyID identifies a credential in the history: access key IDs and
+// IAM role ARNs aren't secret, so are kept for cross-referencing with IAM and
+// CloudTrail.
+func historyKeyID(credential string) string {
+	switch {
+	case credential == "":
+		return ""
+	case awsAccessKeyID.MatchString(credential), iamRoleARN.MatchString(credential):
+		return credential
+	}
+	return fingerprint(credential)
+}
+
+var awsAccessKeyID = regexp.MustCompile(`^A[KS]IA[A-Z0-9]{16}$`)
+
+// encryptedStatePrefix marks a state file encrypted with FLC_STATE_KEY.
+const encryptedStatePrefix = "flc-state-v1:"
+
+// stateFile is FLC_STATE_FILE (a path, or s3://bucket/key), or state.json in
+// the user's config directory.
+func stateFile() (string, error) {
+	if path := os.Getenv("FLC_STATE_FILE"); path != "" {
+		return path, nil
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "fastly-logging-creds", "state.json"), nil
+}
+
+// stateCipher is AES-256-GCM with FLC_STATE_KEY (32 bytes, base64-encoded),
+// or nil if no key is set.
+func stateCipher() (cipher.AEAD, error) {
+	key, err := cachedSecret("FLC_STATE_KEY")
+	if err != nil || key == "" {
+		return nil, err
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(raw) != 32 {
+		return nil, errors.New("FLC_STATE_KEY must be 32 bytes, base64-encoded")
+	}
+
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func loadState() (rotationState, error) {
+	state := rotationState{Rotations: map[string]rotationRecord{}, Tokens: map[string]tokenRecord{}, Retirements: map[string]retirementRecord{}, Schedules: map[string]scheduleRecord{}}
+
+	path, err := stateFile()
+	if err != nil {
+		return state, err
+	}
+
+	data, etag, err := readStateFile(path)
+	state.etag = etag
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return state, err
+	}
+
+	if bytes.HasPrefix(data, []byte(encryptedStatePrefix)) {
+		aead, err := stateCipher()
+		if err != nil {
+			return state, err
+		}
+		if aead == nil {
+			return state, fmt.Errorf("State file %s is encrypted, FLC_STATE_KEY is required", path)
+		}
+
+		sealed, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data[len(encryptedStatePrefix):])))
+		if err != nil || len(sealed) < aead.NonceSize() {
+			return state, fmt.Errorf("State file %s is corrupt", path)
+		}
+
+		data, err = aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+		if err != nil {
+			return state, fmt.Errorf("Unable to decrypt state file %s: %s", path, err.Error())
+		}
+	}
+
+	err = json.Unmarshal(data, &state)
+	if state.Rotations == nil {
+		state.Rotations = map[string]rotationRecord{}
+	}
+	if state.Tokens == nil {
+		state.Tokens = map[string]tokenRecord{}
+	}
+	if state.Retirements == nil {
+		state.Retirements = map[string]retirementRecord{}
+	}
+	if state.Schedules == nil {
+		state.Schedules = map[string]scheduleRecord{}
+	}
+	return state, err
+}
+
+// saveState writes the state file, except in a -dryRun.
+func saveState(state rotationState) error {
+	if dryRun {
+		return nil
+	}
+	path, err := stateFile()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	aead, err := stateCipher()
+	if err != nil {
+		return err
+	}
+	if aead != nil {
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+			return err
+		}
+		sealed := aead.Seal(nonce, nonce, data, nil)
+		data = []byte(encryptedStatePrefix + base64.StdEncoding.EncodeToString(sealed) + "\n")
+	}
+
+	return writeStateFile(path, data, state.etag)
+}
+
+// stateUpdateAttempts is how many times updateState tries to save a change
+// before giving up on an s3:// state file that other runs keep changing.
+const stateUpdateAttempts = 5
+
+// updateState loads the state file, changes it and saves it, loading and
+// changing it again if another run saved it in the meantime. The change may
+// be made more than once, so should only depend on the state it's given.
+func updateState(change func(*rotationState)) error {
+	var err error
+	for i := 0; i < stateUpdateAttempts; i++ {
+		var state rotationState
+		state, err = loadState()
+		if err != nil {
+			return err
+		}
+		change(&state)
+		err = saveState(state)
+		if !isAWSStatus(err, http.StatusPreconditionFailed) && !isAWSStatus(err, http.StatusConflict) {
+			return err
+		}
+	}
+	return fmt.Errorf("The state file kept being changed by other runs: %s", err.Error())
+}
+
+// readStateFile reads the state file, which is an S3 object for an
+// s3://bucket/key path, so that it can be shared by every run, returning its
+// ETag if so.
+func readStateFile(path string) ([]byte, string, error) {
+	if !strings.HasPrefix(path, "s3://") {
+		data, err := ioutil.ReadFile(path)
+		return data, "", err
+	}
+
+	a, bucket, key, err := stateObject(path)
+	if err != nil {
+		return nil, "", err
+	}
+	data, header, err := a.do(context.Background(), "s3:GetObject", a.s3Object(http.MethodGet, "", bucket, key, nil, nil))
+	if isAWSStatus(err, http.StatusNotFound) {
+		return nil, "", os.ErrNotExist
+	} else if err != nil {
+		return nil, "", err
+	}
+	return data, header.Get("ETag"), nil
+}
+
+// writeStateFile writes the state file. An S3 object is only written if it
+// still has the ETag it was read with, or still doesn't exist if etag is
+// blank, failing with a 412 otherwise.
+func writeStateFile(path string, data []byte, etag string) error {
+	if !strings.HasPrefix(path, "s3://") {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(path, data, 0600)
+	}
+
+	a, bucket, key, err := stateObject(path)
+	if err != nil {
+		return err
+	}
+	header := http.Header{"Content-Type": {"application/json"}}
+	if etag != "" {
+		header.Set("If-Match", etag)
+	} else {
+		header.Set("If-None-Match", "*")
+	}
+	return a.putObject(context.Background(), "", bucket, key, header, data)
+}
+
+// stateObject is a client for the bucket of an s3://bucket/key state file.
+func stateObject(path string) (*awsClient, string, string, error) {
+	parts := strings.SplitN(strings.TrimPrefix(path, "s3://"), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, "", "", fmt.Errorf("Invalid state file %q, expected s3://bucket/key", path)
+	}
+
+	_, region, err := headBucket(context.Background(), "", parts[0])
+	if err != nil {
+		return nil, "", "", err
+	}
+	creds, err := operatorCredentials()
+	if err != nil {
+		return nil, "", "", err
+	}
+	return newAWSClient(creds, region), parts[0], parts[1], nil
+}
+
+// recordRotation notes a completed rotation from version from (and the old
+// credential) to version, in the state file and its history. The rotation
+// has already happened by now, so failures are reported but not fatal.
+func recordRotation(typ, serviceID, loggingName string, from, version int, oldCredential, credential string) {
+	now := time.Now().UTC()
+	err := updateState(func(state *rotationState) {
+		state.Rotations[stateKey(typ, serviceID, loggingName)] = rotationRecord{
+			Type:           typ,
+			ServiceID:      serviceID,
+			LoggingName:    loggingName,
+			Version:        version,
+			RotatedAt:      now,
+			KeyFingerprint: fingerprint(credential),
+		}
+		state.History = append(state.History, historyEntry{
+			Type:        typ,
+			ServiceID:   serviceID,
+			LoggingName: loggingName,
+			OldKey:      historyKeyID(oldCredential),
+			NewKey:      historyKeyID(credential),
+			FromVersion: from,
+			ToVersion:   version,
+			RotatedAt:   now,
+			Operator:    operatorName,
+			Command:     commandName,
+		})
+	})
+	if err != nil {
+		fmt.Fprintf(messages(), "Unable to record rotation in state file: %s\n", err.Error())
+	}
+}
+
+// recordToken notes a created token in the state file, so its expiry can be
+// tracked.
+func recordToken(id string, t tokenRecord) {
+	err := updateState(func(state *rotationState) {
+		state.Tokens[id] = t
+	})
+	if err != nil {
+		fmt.Fprintf(messages(), "Unable to record token in state file: %s\n", err.Error())
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	showStats bool
+	runStart  = time.Now()
+
+	statsMu    sync.Mutex
+	latencies  = map[string][]time.Duration{}
+	retryCount = map[string]int{}
+)
+
+// recordCall notes the latency of an API call, by endpoint.
+func recordCall(endpoint string, d time.Duration, retry bool) {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+
+	latencies[endpoint] = append(latencies[endpoint], d)
+	if retry {
+		retryCount[endpoint]++
+	}
+}
+
+// endpointTemplate groups Fastly API paths by endpoint, replacing IDs,
+// version numbers and names with placeholders.
+func endpointTemplate(path string) string {
+	parts := strings.Split(path, "/")
+	for i := 1; i < len(parts); i++ {
+		switch {
+		case parts[i-1] == "service" && parts[i] != "search":
+			parts[i] = "{service_id}"
+		case parts[i-1] == "version":
+			parts[i] = "{version}"
+		case i >= 2 && parts[i-2] == "logging":
+			parts[i] = "{name}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// printStats reports API performance for the run to stderr, if -stats was
+// given.
+func printStats() {
+	if !showStats {
+		return
+	}
+
+	statsMu.Lock()
+	defer statsMu.Unlock()
+
+	var endpoints []string
+	calls, retries := 0, 0
+	var apiTime time.Duration
+	for endpoint, ds := range latencies {
+		endpoints = append(endpoints, endpoint)
+		calls += len(ds)
+		retries += retryCount[endpoint]
+		for _, d := range ds {
+			apiTime += d
+		}
+	}
+	sort.Strings(endpoints)
+
+	fmt.Fprintf(os.Stderr, "\nAPI calls: %d (%d retries), %s in API calls, %s total.\n\n", calls, retries, round(apiTime), round(time.Since(runStart)))
+
+	w := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ENDPOINT\tCALLS\tRETRIES\tP50\tP90\tP99\tMAX")
+	for _, endpoint := range endpoints {
+		ds := append([]time.Duration(nil), latencies[endpoint]...)
+		sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%s\t%s\n", endpoint, len(ds), retryCount[endpoint],
+			round(percentile(ds, 50)), round(percentile(ds, 90)), round(percentile(ds, 99)), round(ds[len(ds)-1]))
+	}
+	w.Flush()
+}
+
+// percentile of sorted durations, by the nearest-rank method.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func round(d time.Duration) time.Duration {
+	return d.Round(time.Millisecond)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// stdinSecrets are the secrets to read from stdin, one per line, in the order
+// their flags were given, so wrapper scripts can pipe them in rather than put
+// them in env vars or flags (which show up in ps).
+var stdinSecrets listFlag
+
+// stdinSecretFlag is a bool flag, such as -fastlyKeyStdin, that reads one
+// secret from stdin.
+type stdinSecretFlag string
+
+func (f stdinSecretFlag) String() string {
+	return ""
+}
+
+func (f stdinSecretFlag) IsBoolFlag() bool {
+	return true
+}
+
+func (f stdinSecretFlag) Set(value string) error {
+	if value == "true" && !contains(stdinSecrets, string(f)) {
+		stdinSecrets = append(stdinSecrets, string(f))
+	}
+	return nil
+}
+
+// readStdinSecrets reads the stdin secrets, the first time any secret is
+// needed.
+func readStdinSecrets() {
+	if len(stdinSecrets) == 0 {
+		return
+	}
+	names := stdinSecrets
+	stdinSecrets = nil
+
+	in := bufio.NewReader(os.Stdin)
+	for _, name := range names {
+		line, err := in.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			check(fmt.Errorf("Unable to read %s from stdin (expected %s, one per line): %s", name, strings.Join(names, ", "), err.Error()))
+		}
+		secretValues[name] = strings.TrimRight(line, "\r\n")
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	assumeRoleARN   string
+	assumeRoleFor   time.Duration
+	assumedCreds    awsCredentials
+	assumedExpiry   time.Time
+	assumedCredsMux sync.Mutex
+)
+
+// refreshBefore is how long before assumed role credentials expire that
+// they are replaced, so no call is made with credentials about to expire.
+const refreshBefore = 5 * time.Minute
+
+func awsFlags(fs *flag.FlagSet) {
+	fs.StringVar(&assumeRoleARN, "assumeRole", "", "ARN of an IAM role to assume for the tool's own AWS calls, rather than using the AWS credentials given directly. The role is assumed again before its credentials expire.")
+	fs.StringVar(&webIdentityRole, "webIdentityRole", "", "ARN of an IAM role to assume with the CI job's OIDC token (from GitHub Actions, AWS_WEB_IDENTITY_TOKEN_FILE or OIDC_TOKEN), so CI needs no AWS keys at all.")
+	fs.DurationVar(&assumeRoleFor, "assumeRoleDuration", time.Hour, "How long each session of the -assumeRole or -webIdentityRole role lasts.")
+}
+
+// https://docs.aws.amazon.com/STS/latest/APIReference/API_AssumeRole.html
+func (a *awsClient) assumeRole(ctx context.Context, roleARN string, duration time.Duration) (awsCredentials, time.Time, error) {
+	var resp struct {
+		AccessKeyID     string    `xml:"AssumeRoleResult>Credentials>AccessKeyId"`
+		SecretAccessKey string    `xml:"AssumeRoleResult>Credentials>SecretAccessKey"`
+		SessionToken    string    `xml:"AssumeRoleResult>Credentials>SessionToken"`
+		Expiration      time.Time `xml:"AssumeRoleResult>Credentials>Expiration"`
+	}
+	params := url.Values{
+		"RoleArn":         {roleARN},
+		"RoleSessionName": {"fastly-logging-creds"},
+		"DurationSeconds": {fmt.Sprint(int(duration.Seconds()))},
+	}
+	err := a.queryAPI(ctx, "sts", "us-east-1", "sts.amazonaws.com", "AssumeRole", "2011-06-15", params, &resp)
+	creds := awsCredentials{accessKey: resp.AccessKeyID, secretKey: resp.SecretAccessKey, sessionToken: resp.SessionToken}
+	return creds, resp.Expiration, err
+}
+
+// assumedRoleCredentials are credentials for the -assumeRole role, assuming
+// it again if the session is about to expire.
+func assumedRoleCredentials(base awsCredentials) (awsCredentials, error) {
+	assumedCredsMux.Lock()
+	defer assumedCredsMux.Unlock()
+
+	if time.Until(assumedExpiry) > refreshBefore {
+		return assumedCreds, nil
+	}
+
+	creds, expiry, err := newAWSClient(base, "").assumeRole(context.Background(), assumeRoleARN, assumeRoleFor)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("Unable to assume role %s: %s", assumeRoleARN, err.Error())
+	}
+	assumedCreds, assumedExpiry = creds, expiry
+	return creds, nil
+}
+
+// checkLongLivedKey checks an access key isn't from STS, and so only
+// usable with its session token. Fastly S3 logging has no field for a session
+// token, so it can only be given long-lived IAM user keys (or an IAM role to
+// assume).
+//
+// https://docs.aws.amazon.com/IAM/latest/UserGuide/reference_identifiers.html#identifiers-unique-ids
+func checkLongLivedKey(accessKey string) error {
+	if strings.HasPrefix(accessKey, "ASIA") {
+		return fmt.Errorf("%s is a temporary (STS) access key, which Fastly can't use as it has no session token field: give an IAM user's access key, or have Fastly assume an IAM role instead (see migrate-to-iam-role)", accessKey)
+	}
+	return nil
+}
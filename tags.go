@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"strings"
+)
+
+// Logging configurations can be tagged by naming them name:tag1:tag2, so
+// that commands can select them by tag across services.
+
+var selectedTags listFlag
+
+// tagFlags adds the flags of commands that can select endpoints by tag.
+func tagFlags(fs *flag.FlagSet) {
+	fs.Var(&selectedTags, "tag", "Only logging configurations tagged with this, as in name:tag. Can be repeated to require several tags.")
+}
+
+// endpointTags are the tags in a logging configuration's name.
+func endpointTags(name string) []string {
+	parts := strings.Split(name, ":")
+	return parts[1:]
+}
+
+// hasTags tells whether a logging configuration is tagged with every tag.
+func hasTags(name string, tags []string) bool {
+	own := endpointTags(name)
+	for _, t := range tags {
+		if !contains(own, t) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+var (
+	clientCertFile string
+	clientKeyFile  string
+	caCertFile     string
+
+	sharedClient     *http.Client
+	sharedClientOnce sync.Once
+)
+
+func transportFlags(fs *flag.FlagSet) {
+	fs.StringVar(&clientCertFile, "clientCert", "", "PEM client certificate to present on outbound TLS connections, e.g. to an egress proxy.")
+	fs.StringVar(&clientKeyFile, "clientKey", "", "PEM private key for -clientCert.")
+	fs.StringVar(&caCertFile, "caCert", "", "PEM CA certificate(s) to trust in addition to the system roots.")
+}
+
+// httpClient is the client for all outbound requests, honouring the proxy
+// env vars and any client certificate.
+func httpClient() *http.Client {
+	sharedClientOnce.Do(func() {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+
+		config, err := tlsConfig()
+		check(err)
+		transport.TLSClientConfig = config
+
+		sharedClient = &http.Client{Transport: transport}
+	})
+	return sharedClient
+}
+
+func tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{}
+
+	if clientCertFile != "" || clientKeyFile != "" {
+		if clientCertFile == "" || clientKeyFile == "" {
+			return nil, fmt.Errorf("-clientCert and -clientKey must be given together")
+		}
+		cert, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load client certificate: %s", err.Error())
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if caCertFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := ioutil.ReadFile(caCertFile)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in %s", caCertFile)
+		}
+		config.RootCAs = pool
+	}
+
+	return config, nil
+}
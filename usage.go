@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// endpointUsage is the log volume an S3 logging configuration delivered over
+// the report window.
+type endpointUsage struct {
+	service  service
+	endpoint string
+	files    int
+	bytes    int64
+	earlier  int64 // bytes in the first half of the window
+	later    int64 // bytes in the second half
+	err      error
+}
+
+// usageReport estimates the volume and storage cost of the logs each S3
+// logging configuration delivers, from the files of the last few days.
+func usageReport(args []string) {
+	fs := flag.NewFlagSet("usage-report", flag.ExitOnError)
+	serviceID := fs.String("serviceID", "", "A Fastly Service ID (default: every service on the account).")
+	window := fs.Int("days", 7, "Number of days of delivered log files to sum.")
+	pricePerGB := fs.Float64("pricePerGB", 0.023, "Storage price in USD per GB-month (the default is S3 Standard in us-east-1).")
+	commonFlags(fs)
+
+	fs.Usage = commandUsage(fs, "Note, FASTLY_KEY (or the tokens of each account in the config file) and AWS credentials able to list the logging buckets (in the standard AWS env vars) must be provided.")
+	parseFlags(fs, args)
+
+	creds := awsEnvCredentials()
+	checkArg("AWS_ACCESS_KEY_ID", creds.accessKey)
+	ctx := context.Background()
+
+	var usages []endpointUsage
+	for _, s := range servicesFor(ctx, *serviceID) {
+		if s.svc.Version == 0 {
+			continue
+		}
+		loggings, err := s.f.s3Loggings(ctx, s.svc.ID, s.svc.Version)
+		check(err)
+
+		for _, l := range loggings {
+			u := endpointUsage{service: s.svc, endpoint: "s3/" + l.Name}
+			u.err = u.sum(ctx, l, creds, *window)
+			usages = append(usages, u)
+		}
+	}
+
+	fmt.Printf("Log volume over the last %d days, at $%.3f per GB-month:\n\n", *window, *pricePerGB)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVICE\tENDPOINT\tFILES\tPER DAY\tGB/MONTH\tCOST/MONTH\tTREND")
+	var totalGB float64
+	for _, u := range usages {
+		if u.err != nil {
+			fmt.Fprintf(w, "%s (%s)\t%s\t-\t-\t-\t-\t%s\n", u.service.Name, u.service.ID, u.endpoint, u.err.Error())
+			continue
+		}
+		perDay := float64(u.bytes) / float64(*window)
+		gbPerMonth := perDay * 30 / (1 << 30)
+		totalGB += gbPerMonth
+		fmt.Fprintf(w, "%s (%s)\t%s\t%d\t%s\t%.2f\t$%.2f\t%s\n", u.service.Name, u.service.ID, u.endpoint, u.files, byteSize(int64(perDay)), gbPerMonth, gbPerMonth**pricePerGB, u.trend())
+	}
+	w.Flush()
+
+	fmt.Printf("\nEach month of logs adds %.2f GB, growing the storage bill by $%.2f per month until retention expires it.\n", totalGB, totalGB**pricePerGB)
+}
+
+// sum adds up the log files l delivered over the last days.
+func (u *endpointUsage) sum(ctx context.Context, l s3Logging, creds awsCredentials, days int) error {
+	a, err := s3ClientFor(ctx, l, creds)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	since := now.AddDate(0, 0, -days)
+	middle := now.Add(-now.Sub(since) / 2)
+
+	listed, seen := map[string]bool{}, map[string]bool{}
+	for d := 0; d <= days; d++ {
+		prefix := dayPrefix(l.Path, now.AddDate(0, 0, -d))
+		if prefix == "" {
+			return fmt.Errorf("The path of %s (%q) has no directory to list log files under, and the whole bucket won't be listed", l.Name, l.Path)
+		}
+		if listed[prefix] {
+			continue
+		}
+		listed[prefix] = true
+
+		objects, err := a.listObjects(ctx, l.Domain, l.BucketName, prefix, maxPollPages)
+		if err != nil {
+			return err
+		}
+		for _, o := range objects {
+			if o.LastModified.Before(since) || seen[o.Key] {
+				continue
+			}
+			seen[o.Key] = true
+			u.files++
+			u.bytes += o.Size
+			if o.LastModified.Before(middle) {
+				u.earlier += o.Size
+			} else {
+				u.later += o.Size
+			}
+		}
+	}
+	return nil
+}
+
+// trend compares the volume of the second half of the window with the first.
+func (u endpointUsage) trend() string {
+	if u.earlier == 0 {
+		return "-"
+	}
+	change := float64(u.later-u.earlier) / float64(u.earlier) * 100
+	return fmt.Sprintf("%+.0f%%", change)
+}
+
+// dayPrefix is the object prefix of the logs l delivers on the day of t: its
+// path up to the first escape finer than a day.
+func dayPrefix(path string, t time.Time) string {
+	for _, escape := range []string{"%H", "%I", "%M", "%S", "%s", "%p", "%T", "%R"} {
+		if i := strings.Index(path, escape); i >= 0 {
+			path = path[:i]
+		}
+	}
+	return strings.TrimPrefix(strftime(path, t.UTC()), "/")
+}
+
+func byteSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
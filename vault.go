@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var (
+	secretSource  string
+	vaultPath     string
+	vaultAuth     string
+	vaultRole     string
+	vaultSecrets  map[string]interface{}
+	vaultLoggedIn string
+)
+
+// defaultKubernetesToken is where Kubernetes mounts a pod's service account
+// token.
+const defaultKubernetesToken = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+func vaultFlags(fs *flag.FlagSet) {
+	fs.StringVar(&secretSource, "secretSource", "env", "Where secrets not given by -secretFrom or -secretCmd come from: env (env vars, then the OS keyring), or vault (-vaultPath, then env).")
+	fs.StringVar(&vaultPath, "vaultPath", "", "Vault secret holding secrets under their env var names, with -secretSource vault, e.g. secret/data/fastly.")
+	fs.StringVar(&vaultAuth, "vaultAuth", "token", "How to authenticate to Vault (at VAULT_ADDR): token (VAULT_TOKEN), approle (VAULT_ROLE_ID and VAULT_SECRET_ID), or kubernetes (the pod's service account, with -vaultRole).")
+	fs.StringVar(&vaultRole, "vaultRole", "", "Vault role to log in as, with -vaultAuth kubernetes.")
+}
+
+// vaultSecret returns a secret from the -vaultPath secret, reading it from
+// Vault the first time.
+func vaultSecret(name string) (string, error) {
+	if vaultSecrets == nil {
+		if vaultPath == "" {
+			return "", errors.New("Missing required arg 'vaultPath' for -secretSource vault")
+		}
+		var data map[string]interface{}
+		if err := vaultRead(context.Background(), vaultPath, &data); err != nil {
+			return "", err
+		}
+		vaultSecrets = data
+	}
+
+	value, _ := vaultSecrets[name].(string)
+	return value, nil
+}
+
+// vaultRead reads the data of a secret, from either version of the KV
+// secrets engine.
+//
+// https://developer.hashicorp.com/vault/api-docs/secret/kv/kv-v2#read-secret-version
+func vaultRead(ctx context.Context, path string, data *map[string]interface{}) error {
+	token, err := vaultToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	var resp struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := vaultDo(ctx, http.MethodGet, path, token, nil, &resp); err != nil {
+		return err
+	}
+
+	// KV v2 nests the secret's data alongside its metadata.
+	if inner, ok := resp.Data["data"].(map[string]interface{}); ok {
+		if _, ok := resp.Data["metadata"]; ok {
+			*data = inner
+			return nil
+		}
+	}
+	*data = resp.Data
+	return nil
+}
+
+// vaultToken authenticates to Vault with the -vaultAuth method.
+//
+// https://developer.hashicorp.com/vault/api-docs/auth/approle#login-with-approle
+// https://developer.hashicorp.com/vault/api-docs/auth/kubernetes#login
+func vaultToken(ctx context.Context) (string, error) {
+	if vaultLoggedIn != "" {
+		return vaultLoggedIn, nil
+	}
+
+	var login map[string]string
+	var mount string
+	switch vaultAuth {
+	case "token":
+		token := os.Getenv("VAULT_TOKEN")
+		if token == "" {
+			return "", errors.New("Missing required arg 'VAULT_TOKEN'.")
+		}
+		return token, nil
+	case "approle":
+		mount = "approle"
+		login = map[string]string{"role_id": os.Getenv("VAULT_ROLE_ID"), "secret_id": os.Getenv("VAULT_SECRET_ID")}
+		if login["role_id"] == "" || login["secret_id"] == "" {
+			return "", errors.New("Missing required arg 'VAULT_ROLE_ID' or 'VAULT_SECRET_ID'.")
+		}
+	case "kubernetes":
+		if vaultRole == "" {
+			return "", errors.New("Missing required arg 'vaultRole'.")
+		}
+		jwt, err := ioutil.ReadFile(defaultKubernetesToken)
+		if err != nil {
+			return "", fmt.Errorf("Unable to read the service account token: %s", err.Error())
+		}
+		mount = "kubernetes"
+		login = map[string]string{"role": vaultRole, "jwt": strings.TrimSpace(string(jwt))}
+	default:
+		return "", fmt.Errorf("Unknown -vaultAuth '%s', expected token, approle or kubernetes", vaultAuth)
+	}
+
+	var resp struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	if err := vaultDo(ctx, http.MethodPost, "auth/"+mount+"/login", "", login, &resp); err != nil {
+		return "", err
+	}
+	vaultLoggedIn = resp.Auth.ClientToken
+	return vaultLoggedIn, nil
+}
+
+func vaultDo(ctx context.Context, method, path, token string, in, out interface{}) error {
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return errors.New("Missing required arg 'VAULT_ADDR'.")
+	}
+
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+
+	reqURL := strings.TrimRight(addr, "/") + "/v1/" + strings.TrimLeft(path, "/")
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if ns := os.Getenv("VAULT_NAMESPACE"); ns != "" {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+
+	resp, err := httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Vault %s %s failed: %d, %s", method, path, resp.StatusCode, string(respBody))
+	}
+	return json.Unmarshal(respBody, out)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// verifyDelivery checks that an S3 logging configuration is delivering log
+// files, and with -watch keeps printing each new one for a few periods.
+func verifyDelivery(args []string) {
+	fs := flag.NewFlagSet("verify-delivery", flag.ExitOnError)
+	serviceID := fs.String("serviceID", "", "A Fastly Service ID.")
+	loggingName := fs.String("loggingName", "", "Name of your service S3 logging configuration in Fastly.")
+	timeout := fs.Duration("timeout", 0, "How long to wait for a log file (default: logging period plus 10m).")
+	watch := fs.Bool("watch", false, "Keep printing each new log file as it is delivered.")
+	cycles := fs.Int("cycles", 3, "Number of logging periods to watch for, with -watch.")
+	prefix := fs.String("prefix", "", "Prefix to list log files under (default the directory of the logging path, for now and the previous period).")
+	commonFlags(fs)
+
+	fs.Usage = commandUsage(fs, "Note, FASTLY_KEY and AWS credentials able to list the bucket (in the standard AWS env vars) must be provided.")
+	parseFlags(fs, args)
+
+	checkArg("serviceID", *serviceID)
+	checkArg("loggingName", *loggingName)
+
+	creds := awsEnvCredentials()
+	checkArg("AWS_ACCESS_KEY_ID", creds.accessKey)
+
+	f := fastlyFor(*serviceID)
+	ctx := context.Background()
+
+	active, err := f.activeVersion(ctx, *serviceID)
+	check(err)
+
+	l, err := f.s3Logging(ctx, *serviceID, active, *loggingName)
+	check(err)
+
+	a, err := s3ClientFor(ctx, l, creds)
+	check(err)
+
+	period := time.Duration(l.Period) * time.Second
+	if period <= 0 {
+		period = time.Hour
+	}
+	if *timeout == 0 {
+		*timeout = deliveryTimeout(int(l.Period))
+	}
+
+	*prefix = strings.TrimPrefix(*prefix, "/")
+	if *prefix == "" && deliveryPrefix(l.Path, time.Now()) == "" {
+		check(fmt.Errorf("The path of %s (%q) has no directory to list log files under, and the whole bucket won't be listed every poll: give the -prefix of the log files", *loggingName, l.Path))
+	}
+	prefixAt := func(t time.Time) string {
+		if *prefix != "" {
+			return *prefix
+		}
+		return deliveryPrefix(l.Path, t)
+	}
+
+	// Anything delivered within the last period (and some slack) counts.
+	since := time.Now().Add(-period - 10*time.Minute)
+	seen := map[string]bool{}
+	var latest time.Time
+
+	poll := func() (bool, error) {
+		now := time.Now()
+		prefixes := []string{prefixAt(now)}
+		if previous := prefixAt(now.Add(-period)); previous != prefixes[0] {
+			prefixes = append(prefixes, previous)
+		}
+
+		found := false
+		for _, prefix := range prefixes {
+			objects, err := a.listObjects(ctx, l.Domain, l.BucketName, prefix, maxPollPages)
+			if err != nil {
+				return false, err
+			}
+			for _, o := range objects {
+				if seen[o.Key] || o.LastModified.Before(since) {
+					continue
+				}
+				seen[o.Key] = true
+				found = true
+				if o.LastModified.After(latest) {
+					latest = o.LastModified
+				}
+				fmt.Printf("%s  %d bytes  delivered %s  (%s after its period)\n", o.Key, o.Size, o.LastModified.UTC().Format(time.RFC3339), periodLag(o.LastModified, period))
+			}
+		}
+		return found, nil
+	}
+
+	fmt.Printf("Waiting up to %s for log files in s3://%s/%s\n", *timeout, l.BucketName, prefixAt(time.Now()))
+	err = waitFor(ctx, *timeout, 30*time.Second, poll)
+	if err == errTimeout {
+		check(fmt.Errorf("No log files delivered to s3://%s within %s", l.BucketName, *timeout))
+	}
+	check(err)
+
+	if !*watch {
+		fmt.Println("Logs are being delivered.")
+		return
+	}
+
+	end := time.Now().Add(time.Duration(*cycles) * period)
+	warned := false
+	for time.Now().Before(end) {
+		time.Sleep(30 * time.Second)
+		found, err := poll()
+		check(err)
+
+		if found {
+			warned = false
+		} else if !warned && time.Since(latest) > period+10*time.Minute {
+			fmt.Printf("No log file delivered for %s (period %s).\n", time.Since(latest).Round(time.Second), period)
+			warned = true
+		}
+	}
+	fmt.Printf("Watched %d periods, %d log file(s) delivered.\n", *cycles, len(seen))
+}
+
+// periodLag is how long after the end of its logging period a file was
+// delivered, taking periods to be aligned with the clock as Fastly does.
+func periodLag(delivered time.Time, period time.Duration) time.Duration {
+	return delivered.Sub(delivered.Truncate(period)).Round(time.Second)
+}
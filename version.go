@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build metadata, set at link time with e.g.
+//
+//	go build -ldflags "-X main.buildVersion=v1.2.0 -X main.buildCommit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	buildVersion = ""
+	buildCommit  = ""
+	buildDate    = ""
+)
+
+// versionCommand prints the build metadata, to confirm which binary is
+// being run.
+func versionCommand(args []string) {
+	fs := flag.NewFlagSet("version", flag.ExitOnError)
+	fs.Usage = commandUsage(fs, "")
+	parseFlags(fs, args)
+
+	fmt.Printf("fastly-logging-creds %s\n", binaryVersion())
+	fmt.Printf("commit: %s\n", orDash(buildCommit))
+	fmt.Printf("built: %s\n", orDash(buildDate))
+	fmt.Printf("go: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
+
+// binaryVersion is the version set at link time, or the module version of a
+// go install, or else "dev".
+func binaryVersion() string {
+	if buildVersion != "" {
+		return buildVersion
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "dev"
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The config file and fleet manifests can be YAML as well as JSON. Only the
+// parts of YAML that such files need are understood: block mappings and
+// sequences, flow [lists] and {maps}, quoted and plain scalars, | and >
+// block scalars, and comments. Anchors, tags and multiple documents aren't.
+
+// isYAMLFile tells whether a file is YAML, by its extension.
+func isYAMLFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// yamlToJSON converts a YAML document to JSON, so that it can be decoded
+// with the same struct tags as a JSON file.
+func yamlToJSON(data []byte) ([]byte, error) {
+	v, err := parseYAML(data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+type yamlLine struct {
+	number int // 1-based, for errors
+	indent int
+	text   string
+}
+
+type yamlParser struct {
+	source []string // every line as it is, for block scalars
+	lines  []yamlLine
+	i      int
+}
+
+func parseYAML(data []byte) (interface{}, error) {
+	p := &yamlParser{source: strings.Split(strings.Replace(string(data), "\r\n", "\n", -1), "\n")}
+	for n, raw := range p.source {
+		trimmed := strings.TrimLeft(raw, " ")
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %d: tabs can't be used for indentation", n+1)
+		}
+		text := strings.TrimRight(stripYAMLComment(trimmed), " \t")
+		if text == "" || text == "---" {
+			continue
+		}
+		p.lines = append(p.lines, yamlLine{number: n + 1, indent: len(raw) - len(trimmed), text: text})
+	}
+	if len(p.lines) == 0 {
+		return nil, nil
+	}
+
+	v, err := p.node(p.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.i < len(p.lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", p.lines[p.i].number)
+	}
+	return v, nil
+}
+
+// node parses the mapping, sequence or scalar at the current line.
+func (p *yamlParser) node(indent int) (interface{}, error) {
+	l := p.lines[p.i]
+	if isYAMLSequenceItem(l.text) {
+		return p.sequence(indent)
+	}
+	if _, _, ok := splitYAMLKey(l.text); ok {
+		return p.mapping(indent)
+	}
+	p.i++
+	return parseYAMLScalar(l.text, l.number)
+}
+
+func (p *yamlParser) sequence(indent int) (interface{}, error) {
+	items := []interface{}{}
+	for p.i < len(p.lines) && p.lines[p.i].indent == indent && isYAMLSequenceItem(p.lines[p.i].text) {
+		l := p.lines[p.i]
+		rest := strings.TrimLeft(strings.TrimPrefix(l.text, "-"), " ")
+		switch {
+		case rest == "":
+			p.i++
+			item, err := p.nested(indent, l.number)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		default:
+			// The item's content continues as though it started a line of
+			// its own, indented past the dash.
+			p.lines[p.i] = yamlLine{number: l.number, indent: indent + len(l.text) - len(rest), text: rest}
+			item, err := p.node(p.lines[p.i].indent)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+func (p *yamlParser) mapping(indent int) (interface{}, error) {
+	m := map[string]interface{}{}
+	for p.i < len(p.lines) && p.lines[p.i].indent == indent {
+		l := p.lines[p.i]
+		rawKey, value, ok := splitYAMLKey(l.text)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", l.number)
+		}
+		key, err := parseYAMLKey(rawKey, l.number)
+		if err != nil {
+			return nil, err
+		}
+		if _, dup := m[key]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key %q", l.number, key)
+		}
+		p.i++
+
+		switch {
+		case value == "":
+			// A sequence may be indented the same as its key.
+			if p.i < len(p.lines) && p.lines[p.i].indent == indent && isYAMLSequenceItem(p.lines[p.i].text) {
+				m[key], err = p.sequence(indent)
+			} else {
+				m[key], err = p.nested(indent, l.number)
+			}
+		case value == "|" || value == ">" || value == "|-" || value == ">-":
+			m[key] = p.blockScalar(indent, l.number, value)
+		default:
+			m[key], err = parseYAMLScalar(value, l.number)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if p.i < len(p.lines) && p.lines[p.i].indent > indent {
+		return nil, fmt.Errorf("line %d: unexpected indentation", p.lines[p.i].number)
+	}
+	return m, nil
+}
+
+// nested parses the node on the lines indented past indent, or is null if
+// there are none.
+func (p *yamlParser) nested(indent, number int) (interface{}, error) {
+	if p.i >= len(p.lines) || p.lines[p.i].indent <= indent {
+		return nil, nil
+	}
+	return p.node(p.lines[p.i].indent)
+}
+
+// blockScalar reads the lines of a | (literal) or > (folded) scalar that
+// follow line number, from the source as it is: blank lines, lines indented
+// past the first, and any # are all part of it.
+func (p *yamlParser) blockScalar(indent, number int, style string) string {
+	var lines []string
+	base, n := -1, number
+	for ; n < len(p.source); n++ {
+		raw := p.source[n]
+		if strings.TrimSpace(raw) == "" {
+			lines = append(lines, "")
+			continue
+		}
+		lineIndent := len(raw) - len(strings.TrimLeft(raw, " "))
+		if base < 0 {
+			base = lineIndent
+		}
+		if lineIndent <= indent || lineIndent < base {
+			break
+		}
+		lines = append(lines, raw[base:])
+	}
+	// Trailing blank lines are chomped, like the final line break with -.
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	for p.i < len(p.lines) && p.lines[p.i].number <= n {
+		p.i++
+	}
+
+	var b strings.Builder
+	for i, line := range lines {
+		if i > 0 {
+			b.WriteString(blockScalarBreak(style, lines[i-1], line))
+		}
+		b.WriteString(line)
+	}
+	if len(lines) > 0 && !strings.HasSuffix(style, "-") {
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// blockScalarBreak is what the line break between two lines of a block
+// scalar becomes. Folding turns one between lines of text into a space, and
+// drops one followed by blank lines, which are each a line break; lines
+// indented past the rest are kept as they are.
+func blockScalarBreak(style, prev, line string) string {
+	if !strings.HasPrefix(style, ">") || prev == "" || strings.HasPrefix(prev, " ") || strings.HasPrefix(line, " ") {
+		return "\n"
+	}
+	if line == "" {
+		return ""
+	}
+	return " "
+}
+
+func isYAMLSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// splitYAMLKey splits key: value at the first colon outside quotes and
+// brackets that is followed by a space or ends the line.
+func splitYAMLKey(text string) (string, string, bool) {
+	if strings.HasPrefix(text, "[") || strings.HasPrefix(text, "{") {
+		return "", "", false
+	}
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' {
+				i++
+			}
+		case c == '"' || c == '\'':
+			if i == 0 {
+				quote = c
+			}
+		case c == ':' && (i == len(text)-1 || text[i+1] == ' '):
+			return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+func parseYAMLKey(raw string, number int) (string, error) {
+	v, err := parseYAMLScalar(raw, number)
+	if err != nil {
+		return "", err
+	}
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	return raw, nil
+}
+
+// parseYAMLScalar parses a value on one line: a quoted or plain scalar, or
+// a flow sequence or mapping.
+func parseYAMLScalar(s string, number int) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		if len(s) < 2 || !strings.HasSuffix(s, `"`) {
+			return nil, fmt.Errorf("line %d: unterminated string %s", number, s)
+		}
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid string %s", number, s)
+		}
+		return v, nil
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return nil, fmt.Errorf("line %d: unterminated string %s", number, s)
+		}
+		return strings.Replace(s[1:len(s)-1], "''", "'", -1), nil
+	case strings.HasPrefix(s, "["):
+		if !strings.HasSuffix(s, "]") {
+			return nil, fmt.Errorf("line %d: unterminated list %s", number, s)
+		}
+		items := []interface{}{}
+		for _, item := range splitYAMLFlow(s[1 : len(s)-1]) {
+			v, err := parseYAMLScalar(item, number)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, v)
+		}
+		return items, nil
+	case strings.HasPrefix(s, "{"):
+		if !strings.HasSuffix(s, "}") {
+			return nil, fmt.Errorf("line %d: unterminated mapping %s", number, s)
+		}
+		m := map[string]interface{}{}
+		for _, entry := range splitYAMLFlow(s[1 : len(s)-1]) {
+			rawKey, value, ok := splitYAMLKey(entry)
+			if !ok {
+				return nil, fmt.Errorf("line %d: expected key: value in %s", number, s)
+			}
+			key, err := parseYAMLKey(rawKey, number)
+			if err != nil {
+				return nil, err
+			}
+			if m[key], err = parseYAMLScalar(value, number); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	}
+
+	switch s {
+	case "~", "null", "Null", "NULL":
+		return nil, nil
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	}
+	if jsonNumber.MatchString(s) {
+		return json.Number(s), nil
+	}
+	return s, nil
+}
+
+// jsonNumber is the JSON number grammar: plain scalars that are numbers in
+// YAML but not in JSON, such as .5, +1, 0700 and NaN, are kept as strings.
+var jsonNumber = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+// splitYAMLFlow splits the items of a flow collection at the commas outside
+// quotes and nested collections.
+func splitYAMLFlow(s string) []string {
+	var items []string
+	depth, start := 0, 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' {
+				i++
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		case c == ',' && depth == 0:
+			items = append(items, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(s[start:]); last != "" {
+		items = append(items, last)
+	}
+	return items
+}
+
+// stripYAMLComment removes a # comment, which starts a line or follows a
+// space, outside quotes.
+func stripYAMLComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' {
+				i++
+			}
+		case c == '"' || c == '\'':
+			if i == 0 || strings.ContainsRune(" [{,:-", rune(s[i-1])) {
+				quote = c
+			}
+		case c == '#' && (i == 0 || s[i-1] == ' '):
+			return s[:i]
+		}
+	}
+	return s
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestYAMLToJSON(t *testing.T) {
+	for yaml, want := range map[string]string{
+		"# nothing\n": `null`,
+		"---\na: 1\n": `{"a":1}`,
+		"service_id: abc123\nperiod: 300\nenabled: true\nnote: ~\n":    `{"service_id":"abc123","period":300,"enabled":true,"note":null}`,
+		"accounts:\n  prod:\n    token_env: FASTLY_KEY_PROD\n":         `{"accounts":{"prod":{"token_env":"FASTLY_KEY_PROD"}}}`,
+		"services:\n  - abc\n  - def\n":                                `{"services":["abc","def"]}`,
+		"services:\n- abc\n- def\n":                                    `{"services":["abc","def"]}`,
+		"- id: abc\n  name: logs\n- id: def\n":                         `[{"id":"abc","name":"logs"},{"id":"def"}]`,
+		"days: [Mon, \"Tue\"]\nwindow: {start: '09:00', end: 17:00}\n": `{"days":["Mon","Tue"],"window":{"end":"17:00","start":"09:00"}}`,
+		"a: \"x: #1\\n\"\nb: 'it''s'\nc: \"true\"\n":                   `{"a":"x: #1\n","b":"it's","c":"true"}`,
+		"# services\na: b # trailing\nc: d#e\n":                        `{"a":"b","c":"d#e"}`,
+		"format: |\n  %h %t\n  %r\n":                                   `{"format":"%h %t\n%r\n"}`,
+		"note: >-\n  one\n  two\n":                                     `{"note":"one two"}`,
+		"a: NaN\nb: .5\nc: +1\nd: 0700\ne: 1e5_\nf: -1.5e3\ng: 0\n":    `{"a":"NaN","b":".5","c":"+1","d":"0700","e":"1e5_","f":-1.5e3,"g":0}`,
+		"cert: |\n  -----BEGIN CERTIFICATE-----\n  MIIB # not a comment\n\n    indented\n  -----END CERTIFICATE-----\n\nnext: 1\n": `{"cert":"-----BEGIN CERTIFICATE-----\nMIIB # not a comment\n\n  indented\n-----END CERTIFICATE-----\n","next":1}`,
+		"note: >\n  one\n  two\n\n  three\n": `{"note":"one two\nthree\n"}`,
+	} {
+		data, err := yamlToJSON([]byte(yaml))
+		if err != nil {
+			t.Errorf("yamlToJSON(%q): %s", yaml, err)
+			continue
+		}
+		var got, expected interface{}
+		json.Unmarshal(data, &got)
+		json.Unmarshal([]byte(want), &expected)
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("yamlToJSON(%q) = %s, want %s", yaml, data, want)
+		}
+	}
+
+	for _, yaml := range []string{
+		"a: 1\na: 2\n",
+		"a:\n\tb: 1\n",
+		"a: 1\n  b: 2\n",
+		"a: \"unterminated\n",
+		"a: [1, 2\n",
+		"a: {b}\n",
+	} {
+		if data, err := yamlToJSON([]byte(yaml)); err == nil {
+			t.Errorf("yamlToJSON(%q) = %s, want an error", yaml, data)
+		}
+	}
+}